@@ -0,0 +1,279 @@
+/*
+Copyright 2026 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonnet
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ArchiveImporter imports data from the filesystem, like FileImporter, but
+// its library paths may also be zip or tar (optionally gzip-compressed)
+// archives. An archive serves as a library directory with the archived files,
+// without being unpacked.
+//
+// The location of an archived file is the path of the archive joined with
+// the path of the file in it, e.g. "libs.zip/k8s/k8s.libsonnet". The relative
+// imports from an archived file are resolved in its directory in the archive
+// first.
+//
+// Whether a library path is an archive is decided by whether it is a regular
+// file, the format is recognized by the contents. The archives are read when
+// they are needed for the first time. ArchiveImporter is safe for concurrent
+// use, but JPaths must not be modified after the first import.
+type ArchiveImporter struct {
+	JPaths []string
+
+	files FileImporter
+
+	archivesMu sync.Mutex
+	// archives are the read archives by their paths, nil for the library
+	// paths which are not archives.
+	archives map[string]*importArchive
+}
+
+// importArchive is the index of an archive.
+type importArchive struct {
+	files map[string]*archivedFile
+}
+
+type archivedFile struct {
+	// zipFile is the file to decompress, if it wasn't done yet.
+	zipFile  *zip.File
+	contents Contents
+}
+
+// archive returns the archive at the library path jpath, nil if it is not
+// an archive.
+func (importer *ArchiveImporter) archive(ctx context.Context, jpath string) (*importArchive, error) {
+	importer.archivesMu.Lock()
+	defer importer.archivesMu.Unlock()
+	if importer.archives == nil {
+		importer.archives = make(map[string]*importArchive)
+	}
+	if a, isCached := importer.archives[jpath]; isCached {
+		return a, nil
+	}
+	var a *importArchive
+	// The library paths of directories often end with a slash, which
+	// may have been added to the paths of archives too.
+	if info, err := os.Stat(filepath.Clean(jpath)); err == nil && info.Mode().IsRegular() {
+		// Nothing is read from an archive rejected by the sandbox.
+		if err := checkImportLocation(ctx, filepath.Clean(jpath)); err != nil {
+			return nil, err
+		}
+		data, err := os.ReadFile(filepath.Clean(jpath))
+		if err != nil {
+			return nil, err
+		}
+		a, err = readImportArchive(data)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read archive %#v: %v", jpath, err)
+		}
+	}
+	importer.archives[jpath] = a
+	return a, nil
+}
+
+func readImportArchive(data []byte) (*importArchive, error) {
+	a := &importArchive{files: make(map[string]*archivedFile)}
+	add := func(name string, file *archivedFile) {
+		name = path.Clean(strings.TrimLeft(name, "/"))
+		if _, exists := a.files[name]; !exists && fs.ValidPath(name) {
+			a.files[name] = file
+		}
+	}
+
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) || bytes.HasPrefix(data, []byte("PK\x05\x06")) {
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range zr.File {
+			if f.Mode().IsRegular() {
+				add(f.Name, &archivedFile{zipFile: f})
+			}
+		}
+		return a, nil
+	}
+
+	var r io.Reader = bytes.NewReader(data)
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		r = gr
+	}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		contents, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		add(header.Name, &archivedFile{contents: MakeContentsRaw(contents)})
+	}
+	return a, nil
+}
+
+func (importer *ArchiveImporter) tryArchive(jpath string, a *importArchive, name string) (found bool, contents Contents, foundHere string, err error) {
+	name = path.Clean(name)
+	importer.archivesMu.Lock()
+	defer importer.archivesMu.Unlock()
+	foundHere = filepath.Join(jpath, filepath.FromSlash(name))
+	file, exists := a.files[name]
+	if !exists {
+		return false, Contents{}, foundHere, nil
+	}
+	if file.zipFile != nil {
+		rc, err := file.zipFile.Open()
+		if err != nil {
+			return false, Contents{}, "", err
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return false, Contents{}, "", fmt.Errorf("couldn't read %#v from archive %#v: %v", name, jpath, err)
+		}
+		// The same Contents must be returned for every import.
+		file.zipFile = nil
+		file.contents = MakeContentsRaw(data)
+	}
+	return true, file.contents, foundHere, nil
+}
+
+// archiveOf finds the archive containing a file imported before.
+func (importer *ArchiveImporter) archiveOf(ctx context.Context, foundAt string) (jpath string, a *importArchive, name string, err error) {
+	for _, jpath := range importer.JPaths {
+		prefix := filepath.Clean(jpath) + string(filepath.Separator)
+		if !strings.HasPrefix(foundAt, prefix) {
+			continue
+		}
+		a, err := importer.archive(ctx, jpath)
+		if err != nil {
+			return "", nil, "", err
+		}
+		if a != nil {
+			return jpath, a, filepath.ToSlash(strings.TrimPrefix(foundAt, prefix)), nil
+		}
+	}
+	return "", nil, "", nil
+}
+
+// Invalidate forgets the files at the given locations, so that they are
+// read again, and the files which didn't exist. The archives containing
+// any of the files are read again too, so all their files must be
+// invalidated together.
+func (importer *ArchiveImporter) Invalidate(foundAts ...string) {
+	importer.files.Invalidate(foundAts...)
+	importer.archivesMu.Lock()
+	defer importer.archivesMu.Unlock()
+	for jpath := range importer.archives {
+		archivePath := filepath.Clean(jpath)
+		for _, foundAt := range foundAts {
+			if foundAt == archivePath || strings.HasPrefix(foundAt, archivePath+string(filepath.Separator)) {
+				delete(importer.archives, jpath)
+				break
+			}
+		}
+	}
+}
+
+// Import imports a file from the filesystem or from the archives.
+func (importer *ArchiveImporter) Import(importedFrom, importedPath string) (contents Contents, foundAt string, err error) {
+	return importer.ImportContext(context.Background(), importedFrom, importedPath)
+}
+
+// ImportContext imports a file from the filesystem or from the archives,
+// checking ctx before probing every location.
+func (importer *ArchiveImporter) ImportContext(ctx context.Context, importedFrom, importedPath string) (contents Contents, foundAt string, err error) {
+	notFound := &ImportNotFoundError{ImportedPath: importedPath}
+	probe := func(try func() (bool, Contents, string, error)) (bool, Contents, string, error) {
+		if err := ctx.Err(); err != nil {
+			return false, Contents{}, "", err
+		}
+		found, content, foundHere, err := try()
+		if err == nil && !found && foundHere != "" {
+			notFound.Probed = append(notFound.Probed, foundHere)
+		}
+		return found, content, foundHere, err
+	}
+
+	jpath, a, name, err := importer.archiveOf(ctx, importedFrom)
+	if err != nil {
+		return Contents{}, "", err
+	}
+	var found bool
+	var content Contents
+	var foundHere string
+	if a != nil && !filepath.IsAbs(importedPath) && !path.IsAbs(importedPath) {
+		found, content, foundHere, err = probe(func() (bool, Contents, string, error) {
+			return importer.tryArchive(jpath, a, path.Join(path.Dir(name), importedPath))
+		})
+	} else {
+		dir, _ := filepath.Split(importedFrom)
+		found, content, foundHere, err = probe(func() (bool, Contents, string, error) {
+			return importer.files.tryPath(ctx, dir, importedPath)
+		})
+	}
+	if err != nil {
+		return Contents{}, "", err
+	}
+
+	for i := len(importer.JPaths) - 1; !found && i >= 0; i-- {
+		jpath := importer.JPaths[i]
+		a, err := importer.archive(ctx, jpath)
+		if err != nil {
+			return Contents{}, "", err
+		}
+		found, content, foundHere, err = probe(func() (bool, Contents, string, error) {
+			if a != nil {
+				return importer.tryArchive(jpath, a, importedPath)
+			}
+			return importer.files.tryPath(ctx, jpath, importedPath)
+		})
+		if err != nil {
+			return Contents{}, "", err
+		}
+	}
+
+	if !found {
+		return Contents{}, "", notFound
+	}
+	return content, foundHere, nil
+}
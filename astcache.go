@@ -0,0 +1,127 @@
+/*
+Copyright 2026 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonnet
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/internal/astcodec"
+)
+
+// ASTCache is a persistent cache of the parsed and desugared ASTs
+// of the imported files, which outlives a single process.
+// See VM.SetASTCache.
+//
+// The cache stores opaque serialized ASTs. The keys are derived from the
+// go-jsonnet version, the location of the file and the hash of its contents,
+// so there is no need to invalidate the entries when the files change.
+// The keys consist only of lowercase letters and digits.
+//
+// The errors of the cache are not fatal. If an entry cannot be loaded,
+// the file is simply parsed again.
+//
+// Implementations must be safe for concurrent use.
+type ASTCache interface {
+	// Load returns the data stored under the key.
+	// It returns nil data and no error if there is no such entry.
+	Load(key string) (data []byte, err error)
+	// Store stores the data under the key.
+	Store(key string, data []byte) error
+}
+
+// DirASTCache is an ASTCache storing the entries as files in a directory.
+// The directory is created if it doesn't exist. The entries are never
+// removed, so the directory should be cleaned up from time to time.
+type DirASTCache struct {
+	Dir string
+}
+
+// Load reads the entry from its file.
+func (cache *DirASTCache) Load(key string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(cache.Dir, key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// Store writes the entry to its file. The file is replaced atomically,
+// so that concurrent processes never see partially written entries.
+func (cache *DirASTCache) Store(key string, data []byte) error {
+	if err := os.MkdirAll(cache.Dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(cache.Dir, key+".tmp*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(cache.Dir, key))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// astCacheKey returns the key of the AST of a file in an ASTCache.
+// The location of the file is a part of the key, because it is recorded
+// in the AST (and then in error messages).
+func astCacheKey(foundAt string, contents Contents) string {
+	h := sha256.New()
+	h.Write([]byte(version))
+	h.Write([]byte{0})
+	h.Write([]byte(astcodec.Version))
+	h.Write([]byte{0})
+	h.Write([]byte(foundAt))
+	h.Write([]byte{0})
+	h.Write(contents.Data())
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// loadAST loads the AST of a file from the persistent cache.
+// It returns nil if the AST is not available.
+func loadAST(cache ASTCache, key string) ast.Node {
+	data, err := cache.Load(key)
+	if err != nil || data == nil {
+		return nil
+	}
+	node, err := astcodec.Decode(data)
+	if err != nil {
+		// The entry is corrupted, so it is overwritten later.
+		return nil
+	}
+	return node
+}
+
+// storeAST stores the AST of a file in the persistent cache.
+func storeAST(cache ASTCache, key string, node ast.Node) {
+	data, err := astcodec.Encode(node)
+	if err != nil {
+		return
+	}
+	// The errors are ignored, the cache is just an optimization.
+	_ = cache.Store(key, data)
+}
@@ -25,6 +25,7 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math"
@@ -33,6 +34,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 
 	"github.com/google/go-jsonnet/ast"
 	"golang.org/x/crypto/sha3"
@@ -46,7 +48,7 @@ func builtinPlus(i *interpreter, x, y value) (value, error) {
 		if err != nil {
 			return nil, err
 		}
-		return concatStrings(left.(valueString), right), nil
+		return i.concatStrings(left.(valueString), right)
 
 	}
 	switch left := x.(type) {
@@ -61,7 +63,7 @@ func builtinPlus(i *interpreter, x, y value) (value, error) {
 		if err != nil {
 			return nil, err
 		}
-		return concatStrings(left, right.(valueString)), nil
+		return i.concatStrings(left, right.(valueString))
 	case *valueObject:
 		switch right := y.(type) {
 		case *valueObject:
@@ -75,6 +77,9 @@ func builtinPlus(i *interpreter, x, y value) (value, error) {
 		if err != nil {
 			return nil, err
 		}
+		if err := i.allocateArray(left.length() + right.length()); err != nil {
+			return nil, err
+		}
 		return concatArrays(left, right), nil
 	default:
 		return nil, i.typeErrorGeneral(x)
@@ -241,13 +246,18 @@ func valueToString(i *interpreter, x value) (string, error) {
 	}
 
 	var buf bytes.Buffer
-	if err := i.manifestAndSerializeJSON(&buf, x, false, ""); err != nil {
+	if err := i.manifestAndSerializeJSON(&buf, x, false, "", false); err != nil {
 		return "", err
 	}
 	return buf.String(), nil
 }
 
 func builtinToString(i *interpreter, x value) (value, error) {
+	// The strings are returned as they are, so that a concatenation
+	// doesn't flatten them.
+	if x, isString := x.(valueString); isString {
+		return x, nil
+	}
 	s, err := valueToString(i, x)
 	if err != nil {
 		return nil, err
@@ -255,11 +265,18 @@ func builtinToString(i *interpreter, x value) (value, error) {
 	return makeValueString(s), nil
 }
 
+// errTraceCalled stops the parallel multi mode, see interpreter.traceCalled.
+var errTraceCalled = errors.New("std.trace called in the parallel mode")
+
 func builtinTrace(i *interpreter, x value, y value) (value, error) {
 	xStr, err := i.getString(x)
 	if err != nil {
 		return nil, err
 	}
+	if i.traceCalled != nil {
+		atomic.StoreInt32(i.traceCalled, 1)
+		return nil, errTraceCalled
+	}
 	trace := i.stack.currentTrace
 	filename := trace.loc.File.DiagnosticFileName
 	line := trace.loc.Begin.Line
@@ -287,14 +304,20 @@ func builtinMakeArray(i *interpreter, szv, funcv value) (value, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := i.checkAllocation(sz * arrayElementSize); err != nil {
+		return nil, err
+	}
 	var elems []*cachedThunk
-	for i := 0; i < sz; i++ {
+	for index := 0; index < sz; index++ {
+		if err := i.checkContext(); err != nil {
+			return nil, err
+		}
 		elem := &cachedThunk{
 			env: &environment{},
 			body: &astMakeArrayElement{
 				NodeBase: ast.NodeBase{},
 				function: fun,
-				index:    i,
+				index:    index,
 			},
 		}
 		elems = append(elems, elem)
@@ -702,6 +725,9 @@ func builtinRange(i *interpreter, fromv, tov value) (value, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := i.checkAllocation((to - from + 1) * arrayElementSize); err != nil {
+		return nil, err
+	}
 	elems := make([]*cachedThunk, to-from+1)
 	for i := from; i <= to; i++ {
 		elems[i-from] = readyThunk(intToValue(i))
@@ -1504,6 +1530,7 @@ func builtinUglyObjectFlatMerge(i *interpreter, x value) (value, error) {
 		return nil, err
 	}
 	newFields := make(simpleObjectFieldMap)
+	var order []string
 	for _, elem := range objarr.elements {
 		obj, err := i.evaluateObject(elem)
 		if err != nil {
@@ -1522,6 +1549,7 @@ func builtinUglyObjectFlatMerge(i *interpreter, x value) (value, error) {
 			if _, alreadyExists := newFields[fieldName]; alreadyExists {
 				return nil, i.Error(duplicateFieldNameErrMsg(fieldName))
 			}
+			order = append(order, fieldName)
 
 			newFields[fieldName] = simpleObjectField{
 				hide: fieldVal.hide,
@@ -1536,6 +1564,7 @@ func builtinUglyObjectFlatMerge(i *interpreter, x value) (value, error) {
 	return makeValueSimpleObject(
 		nil,
 		newFields,
+		order,
 		[]unboundField{}, // No asserts allowed
 		nil,
 	), nil
@@ -1948,6 +1977,79 @@ func builtinManifestTomlEx(i *interpreter, arguments []value) (value, error) {
 	}
 }
 
+// builtinManifestIni is the native version of std.manifestIni from std.jsonnet.
+func builtinManifestIni(i *interpreter, iniv value) (value, error) {
+	ini, err := i.getObject(iniv)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	bodyLines := func(bodyv value) error {
+		body, err := i.getObject(bodyv)
+		if err != nil {
+			return err
+		}
+		fields := objectFields(body, withoutHidden)
+		sort.Strings(fields)
+		for _, key := range fields {
+			v, err := body.index(i, key)
+			if err != nil {
+				return err
+			}
+			values := []value{v}
+			if array, isArray := v.(*valueArray); isArray {
+				values = nil
+				for _, th := range array.elements {
+					element, err := th.getValue(i)
+					if err != nil {
+						return err
+					}
+					values = append(values, element)
+				}
+			}
+			for _, v := range values {
+				s, err := valueToString(i, v)
+				if err != nil {
+					return err
+				}
+				lines = append(lines, key+" = "+s)
+			}
+		}
+		return nil
+	}
+
+	if hide, hasMain := objectFieldsVisibility(ini)["main"]; hasMain && hide != ast.ObjectFieldHidden {
+		main, err := ini.index(i, "main")
+		if err != nil {
+			return nil, err
+		}
+		if err := bodyLines(main); err != nil {
+			return nil, err
+		}
+	}
+	sectionsv, err := ini.index(i, "sections")
+	if err != nil {
+		return nil, err
+	}
+	sections, err := i.getObject(sectionsv)
+	if err != nil {
+		return nil, err
+	}
+	names := objectFields(sections, withoutHidden)
+	sort.Strings(names)
+	for _, name := range names {
+		section, err := sections.index(i, name)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, "["+name+"]")
+		if err := bodyLines(section); err != nil {
+			return nil, err
+		}
+	}
+	return makeValueString(strings.Join(append(lines, ""), "\n")), nil
+}
+
 // We have a very similar logic here /interpreter.go@v0.16.0#L695 and here: /interpreter.go@v0.16.0#L627
 // These should ideally be unified
 // For backwards compatibility reasons, we are manually marshalling to json so we can control formatting
@@ -1970,6 +2072,11 @@ func builtinManifestJSONEx(i *interpreter, arguments []value) (value, error) {
 		return nil, err
 	}
 
+	vpreserveOrder, err := i.getBoolean(arguments[4])
+	if err != nil {
+		return nil, err
+	}
+
 	sindent := vindent.getGoString()
 	newline := vnewline.getGoString()
 	kvSep := vkvSep.getGoString()
@@ -2023,8 +2130,7 @@ func builtinManifestJSONEx(i *interpreter, arguments []value) (value, error) {
 			newIndent := cindent + sindent
 			lines := []string{"{" + newline}
 
-			fields := objectFields(v, withoutHidden)
-			sort.Strings(fields)
+			fields := manifestedFields(v, vpreserveOrder.value)
 			var objectLines []string
 			for _, fieldName := range fields {
 				fieldValue, err := v.index(i, fieldName)
@@ -2139,6 +2245,10 @@ func builtinManifestYamlDoc(i *interpreter, arguments []value) (value, error) {
 	if err != nil {
 		return nil, err
 	}
+	vpreserveOrder, err := i.getBoolean(arguments[3])
+	if err != nil {
+		return nil, err
+	}
 
 	var buf bytes.Buffer
 
@@ -2171,6 +2281,8 @@ func builtinManifestYamlDoc(i *interpreter, arguments []value) (value, error) {
 			}
 		case *valueNumber:
 			buf.WriteString(strconv.FormatFloat(v.value, 'f', -1, 64))
+		case *valueFunction:
+			return i.Error("tried to manifest function")
 		case *valueArray:
 			if v.length() == 0 {
 				buf.WriteString("[]")
@@ -2207,12 +2319,11 @@ func builtinManifestYamlDoc(i *interpreter, arguments []value) (value, error) {
 				cindent = prevIndent
 			}
 		case *valueObject:
-			fields := objectFields(v, withoutHidden)
+			fields := manifestedFields(v, vpreserveOrder.value)
 			if len(fields) == 0 {
 				buf.WriteString("{}")
 				return nil
 			}
-			sort.Strings(fields)
 			for ix, fieldName := range fields {
 				fieldValue, err := v.index(i, fieldName)
 				if err != nil {
@@ -2251,7 +2362,9 @@ func builtinManifestYamlDoc(i *interpreter, arguments []value) (value, error) {
 				} else {
 					buf.WriteByte(' ')
 				}
-				aux(fieldValue, buf, cindent)
+				if err := aux(fieldValue, buf, cindent); err != nil {
+					return err
+				}
 				cindent = prevIndent
 			}
 		}
@@ -2271,6 +2384,9 @@ func builtinExtVar(i *interpreter, name value) (value, error) {
 		return nil, err
 	}
 	index := str.getGoString()
+	if err := i.sandbox.checkExtVar(index); err != nil {
+		return nil, i.Error(err.Error())
+	}
 	if pv, ok := i.extVars[index]; ok {
 		return i.evaluatePV(pv)
 	}
@@ -2355,6 +2471,9 @@ func builtinNative(i *interpreter, name value) (value, error) {
 		return nil, err
 	}
 	index := str.getGoString()
+	if err := i.sandbox.checkNative(index); err != nil {
+		return nil, i.Error(err.Error())
+	}
 	if f, exists := i.nativeFuncs[index]; exists {
 		return &valueFunction{ec: f}, nil
 	}
@@ -2469,6 +2588,12 @@ func builtInObjectRemoveKey(i *interpreter, objv value, keyv value) (value, erro
 
 	newFields := make(simpleObjectFieldMap)
 	simpleObj := obj.uncached.(*simpleObject)
+	var order []string
+	for _, fieldName := range simpleObj.order {
+		if fieldName != key.getGoString() {
+			order = append(order, fieldName)
+		}
+	}
 	for fieldName, fieldVal := range simpleObj.fields {
 		if fieldName == key.getGoString() {
 			// skip the field which needs to be deleted
@@ -2487,6 +2612,7 @@ func builtInObjectRemoveKey(i *interpreter, objv value, keyv value) (value, erro
 	return makeValueSimpleObject(
 		nil,
 		newFields,
+		order,
 		[]unboundField{}, // No asserts allowed
 		nil,
 	), nil
@@ -2537,7 +2663,11 @@ func (b *unaryBuiltin) evalCall(args callArguments, i *interpreter) (value, erro
 	if err != nil {
 		return nil, err
 	}
-	return b.function(i, x)
+	result, err := b.function(i, x)
+	if err != nil {
+		return nil, err
+	}
+	return i.allocateResult(result, x)
 }
 
 func (b *unaryBuiltin) parameters() []namedParameter {
@@ -2571,7 +2701,11 @@ func (b *binaryBuiltin) evalCall(args callArguments, i *interpreter) (value, err
 	if err != nil {
 		return nil, err
 	}
-	return b.function(i, x, y)
+	result, err := b.function(i, x, y)
+	if err != nil {
+		return nil, err
+	}
+	return i.allocateResult(result, x, y)
 }
 
 func (b *binaryBuiltin) parameters() []namedParameter {
@@ -2609,7 +2743,11 @@ func (b *ternaryBuiltin) evalCall(args callArguments, i *interpreter) (value, er
 	if err != nil {
 		return nil, err
 	}
-	return b.function(i, x, y, z)
+	result, err := b.function(i, x, y, z)
+	if err != nil {
+		return nil, err
+	}
+	return i.allocateResult(result, x, y, z)
 }
 
 func (b *ternaryBuiltin) parameters() []namedParameter {
@@ -2680,7 +2818,11 @@ func (b *generalBuiltin) evalCall(args callArguments, i *interpreter) (value, er
 			return nil, err
 		}
 	}
-	return b.function(i, values)
+	result, err := b.function(i, values)
+	if err != nil {
+		return nil, err
+	}
+	return i.allocateResult(result, values...)
 }
 
 // End of builtin utils
@@ -2811,12 +2953,15 @@ var funcBuiltins = buildBuiltinMap([]builtin{
 	&unaryBuiltin{name: "parseYaml", function: builtinParseYAML, params: ast.Identifiers{"str"}},
 	&generalBuiltin{name: "manifestJsonEx", function: builtinManifestJSONEx, params: []generalBuiltinParameter{{name: "value"}, {name: "indent"},
 		{name: "newline", defaultValue: &valueFlatString{value: []rune("\n")}},
-		{name: "key_val_sep", defaultValue: &valueFlatString{value: []rune(": ")}}}},
+		{name: "key_val_sep", defaultValue: &valueFlatString{value: []rune(": ")}},
+		{name: "preserve_order", defaultValue: &valueBoolean{value: false}}}},
+	&unaryBuiltin{name: "manifestIni", function: builtinManifestIni, params: ast.Identifiers{"ini"}},
 	&generalBuiltin{name: "manifestTomlEx", function: builtinManifestTomlEx, params: []generalBuiltinParameter{{name: "value"}, {name: "indent"}}},
 	&generalBuiltin{name: "manifestYamlDoc", function: builtinManifestYamlDoc, params: []generalBuiltinParameter{
 		{name: "value"},
 		{name: "indent_array_in_object", defaultValue: &valueBoolean{value: false}},
 		{name: "quote_keys", defaultValue: &valueBoolean{value: true}},
+		{name: "preserve_order", defaultValue: &valueBoolean{value: false}},
 	}},
 	&unaryBuiltin{name: "base64", function: builtinBase64, params: ast.Identifiers{"input"}},
 	&unaryBuiltin{name: "encodeUTF8", function: builtinEncodeUTF8, params: ast.Identifiers{"str"}},
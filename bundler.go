@@ -0,0 +1,214 @@
+/*
+Copyright 2026 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonnet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	jsonnetfileName     = "jsonnetfile.json"
+	jsonnetfileLockName = "jsonnetfile.lock.json"
+)
+
+// JsonnetBundle is a project managed by jsonnet-bundler: a directory with
+// a jsonnetfile.json, whose dependencies are installed in its vendor
+// directory.
+type JsonnetBundle struct {
+	// Root is the directory containing jsonnetfile.json.
+	Root string
+	// Packages maps the names of the packages, as used by the legacy imports
+	// (e.g. "grafana-builder/grafana.libsonnet"), to their directories.
+	Packages map[string]string
+}
+
+// jsonnetfile is the part of jsonnetfile.json and jsonnetfile.lock.json
+// needed to find the installed packages.
+type jsonnetfile struct {
+	Dependencies []struct {
+		Source struct {
+			Git *struct {
+				Remote string `json:"remote"`
+				Subdir string `json:"subdir"`
+			} `json:"git"`
+			Local *struct {
+				Directory string `json:"directory"`
+			} `json:"local"`
+		} `json:"source"`
+		Name string `json:"name"`
+	} `json:"dependencies"`
+}
+
+// FindJsonnetBundle finds the jsonnet-bundler project containing dir, i.e.
+// the closest directory with a jsonnetfile.json among dir and its parents.
+// It returns nil if there is none.
+func FindJsonnetBundle(dir string) (*JsonnetBundle, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, jsonnetfileName)); err == nil {
+			return LoadJsonnetBundle(dir)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, nil
+		}
+		dir = parent
+	}
+}
+
+// LoadJsonnetBundle reads the jsonnet-bundler project in the root directory.
+// The packages are read from jsonnetfile.lock.json, or from jsonnetfile.json
+// if the dependencies were not installed yet.
+func LoadJsonnetBundle(root string) (*JsonnetBundle, error) {
+	filename := filepath.Join(root, jsonnetfileLockName)
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		filename = filepath.Join(root, jsonnetfileName)
+		data, err = os.ReadFile(filename)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var file jsonnetfile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("couldn't read %s: %v", filename, err)
+	}
+
+	b := &JsonnetBundle{Root: root, Packages: make(map[string]string)}
+	vendor := filepath.Join(root, "vendor")
+	for _, dep := range file.Dependencies {
+		var name, dir string
+		switch {
+		case dep.Source.Git != nil:
+			repo := gitRepoPath(dep.Source.Git.Remote)
+			subdir := strings.Trim(dep.Source.Git.Subdir, "/")
+			dir = filepath.Join(vendor, filepath.FromSlash(path.Join(repo, subdir)))
+			name = path.Base(path.Join(repo, subdir))
+		case dep.Source.Local != nil:
+			dir = filepath.Join(root, filepath.FromSlash(dep.Source.Local.Directory))
+			name = path.Base(filepath.ToSlash(dep.Source.Local.Directory))
+		default:
+			continue
+		}
+		if dep.Name != "" {
+			name = dep.Name
+		}
+		b.Packages[name] = dir
+	}
+	return b, nil
+}
+
+// gitRepoPath returns the path of a git repository in the vendor directory,
+// e.g. "github.com/grafana/jsonnet-libs" for
+// "https://github.com/grafana/jsonnet-libs.git" or
+// "git@github.com:grafana/jsonnet-libs.git".
+func gitRepoPath(remote string) string {
+	p := remote
+	if i := strings.Index(p, "://"); i >= 0 {
+		p = p[i+len("://"):]
+	} else if i := strings.Index(p, ":"); i >= 0 {
+		// The scp-like syntax of ssh.
+		p = p[:i] + "/" + p[i+1:]
+	}
+	host, repo, _ := strings.Cut(p, "/")
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		host = host[i+1:]
+	}
+	return strings.TrimSuffix(host+"/"+strings.Trim(repo, "/"), ".git")
+}
+
+// JPaths returns the library paths of the project, in the order of
+// FileImporter.JPaths: the vendor directory and the lib directory,
+// if there is one.
+func (b *JsonnetBundle) JPaths() []string {
+	jpaths := []string{filepath.Join(b.Root, "vendor")}
+	if info, err := os.Stat(filepath.Join(b.Root, "lib")); err == nil && info.IsDir() {
+		jpaths = append(jpaths, filepath.Join(b.Root, "lib"))
+	}
+	return jpaths
+}
+
+// JsonnetBundleImporter imports data like ArchiveImporter, with the library
+// paths of a jsonnet-bundler project before JPaths. The imports which are
+// not found otherwise and start with the name of a package of the project
+// are resolved in the directory of the package. This way the legacy imports
+// work even if jsonnet-bundler didn't create the links to the packages
+// in the vendor directory.
+//
+// It is safe for concurrent use, but its fields must not be modified
+// after the first import.
+type JsonnetBundleImporter struct {
+	// Bundle is the project, nil means none.
+	Bundle *JsonnetBundle
+	// JPaths are the additional library paths, with a higher priority than
+	// the ones of the project.
+	JPaths []string
+
+	once     sync.Once
+	importer ArchiveImporter
+}
+
+// Invalidate forgets the files at the given locations, as
+// ArchiveImporter.Invalidate does.
+func (importer *JsonnetBundleImporter) Invalidate(foundAts ...string) {
+	importer.importer.Invalidate(foundAts...)
+}
+
+// Import imports a file from the project, the library paths or the packages.
+func (importer *JsonnetBundleImporter) Import(importedFrom, importedPath string) (contents Contents, foundAt string, err error) {
+	return importer.ImportContext(context.Background(), importedFrom, importedPath)
+}
+
+// ImportContext imports a file like Import, checking ctx before probing
+// every location.
+func (importer *JsonnetBundleImporter) ImportContext(ctx context.Context, importedFrom, importedPath string) (contents Contents, foundAt string, err error) {
+	importer.once.Do(func() {
+		if importer.Bundle != nil {
+			importer.importer.JPaths = importer.Bundle.JPaths()
+		}
+		importer.importer.JPaths = append(importer.importer.JPaths, importer.JPaths...)
+	})
+	contents, foundAt, err = importer.importer.ImportContext(ctx, importedFrom, importedPath)
+	if importer.Bundle == nil || !errors.Is(err, ErrImportNotFound) {
+		return contents, foundAt, err
+	}
+	name, rest, _ := strings.Cut(path.Clean(filepath.ToSlash(importedPath)), "/")
+	dir, isPackage := importer.Bundle.Packages[name]
+	if !isPackage || rest == "" {
+		return Contents{}, "", err
+	}
+	contents, foundAt, packageErr := importer.importer.ImportContext(ctx, importedFrom, filepath.Join(dir, filepath.FromSlash(rest)))
+	if packageErr == nil {
+		return contents, foundAt, nil
+	}
+	notFound := &ImportNotFoundError{ImportedPath: importedPath}
+	if !mergeNotFound(notFound, err, "") || !mergeNotFound(notFound, packageErr, "") {
+		return Contents{}, "", packageErr
+	}
+	return Contents{}, "", notFound
+}
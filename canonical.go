@@ -0,0 +1,176 @@
+/*
+Copyright 2026 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonnet
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// serializeCanonicalJSON serializes a manifested value following the JSON
+// Canonicalization Scheme (RFC 8785): the numbers are formatted as in
+// ECMAScript, the strings are escaped as by JSON.stringify, the fields are
+// sorted by their UTF-16 code units and there is no whitespace.
+func serializeCanonicalJSON(v interface{}, buf jsonWriter) {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteString("null")
+
+	case bool:
+		if v {
+			buf.WriteString("true")
+		} else {
+			buf.WriteString("false")
+		}
+
+	case float64:
+		buf.WriteString(canonicalNumber(v))
+
+	case string:
+		writeCanonicalString(v, buf)
+
+	case []interface{}:
+		buf.WriteByte('[')
+		for index, elem := range v {
+			if index > 0 {
+				buf.WriteByte(',')
+			}
+			serializeCanonicalJSON(elem, buf)
+		}
+		buf.WriteByte(']')
+
+	case map[string]interface{}:
+		serializeCanonicalObject(v, buf)
+
+	case *orderedObject:
+		// The order of the definitions doesn't matter here.
+		serializeCanonicalObject(v.fields, buf)
+
+	default:
+		panic(fmt.Sprintf("Unsupported value for serialization %#+v", v))
+	}
+}
+
+func serializeCanonicalObject(fields map[string]interface{}, buf jsonWriter) {
+	type field struct {
+		name  string
+		units []uint16
+	}
+	sorted := make([]field, 0, len(fields))
+	for name := range fields {
+		sorted = append(sorted, field{name: name, units: utf16.Encode([]rune(name))})
+	}
+	sort.Slice(sorted, func(a, b int) bool {
+		return utf16Less(sorted[a].units, sorted[b].units)
+	})
+	buf.WriteByte('{')
+	for index, f := range sorted {
+		if index > 0 {
+			buf.WriteByte(',')
+		}
+		writeCanonicalString(f.name, buf)
+		buf.WriteByte(':')
+		serializeCanonicalJSON(fields[f.name], buf)
+	}
+	buf.WriteByte('}')
+}
+
+func utf16Less(a, b []uint16) bool {
+	for index := 0; index < len(a) && index < len(b); index++ {
+		if a[index] != b[index] {
+			return a[index] < b[index]
+		}
+	}
+	return len(a) < len(b)
+}
+
+// writeCanonicalString writes a string literal, escaping only the quotes,
+// the backslashes and the control characters.
+func writeCanonicalString(s string, buf jsonWriter) {
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(buf, `\u%04x`, r)
+			} else {
+				buf.WriteRune(r)
+			}
+		}
+	}
+	buf.WriteByte('"')
+}
+
+// canonicalNumber formats a number like Number.prototype.toString
+// of ECMAScript: the shortest representation which reads back as the same
+// number, in the exponential notation only for very large and very small
+// numbers.
+func canonicalNumber(f float64) string {
+	if f == 0 {
+		// Also for -0.
+		return "0"
+	}
+	sign := ""
+	if f < 0 {
+		sign = "-"
+		f = -f
+	}
+	// The shortest digits, as in "1.2345e+06".
+	mantissa, exponent, _ := strings.Cut(strconv.FormatFloat(f, 'e', -1, 64), "e")
+	digits := strings.Replace(mantissa, ".", "", 1)
+	exp, _ := strconv.Atoi(exponent)
+	// The number is 0.<digits> * 10^n.
+	n := exp + 1
+	k := len(digits)
+	switch {
+	case k <= n && n <= 21:
+		return sign + digits + strings.Repeat("0", n-k)
+	case 0 < n && n <= 21:
+		return sign + digits[:n] + "." + digits[n:]
+	case -6 < n && n <= 0:
+		return sign + "0." + strings.Repeat("0", -n) + digits
+	}
+	expSign := "+"
+	if n-1 < 0 {
+		expSign = "-"
+	}
+	e := n - 1
+	if e < 0 {
+		e = -e
+	}
+	if k == 1 {
+		return sign + digits + "e" + expSign + strconv.Itoa(e)
+	}
+	return sign + digits[:1] + "." + digits[1:] + "e" + expSign + strconv.Itoa(e)
+}
@@ -19,6 +19,7 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
@@ -27,6 +28,9 @@ import (
 	"runtime"
 	"runtime/pprof"
 	"strconv"
+	"strings"
+
+	"github.com/google/go-jsonnet"
 )
 
 // NextArg retrieves the next argument from the commandline.
@@ -142,6 +146,87 @@ func WriteOutputFile(output string, outputFile string, createDirs bool) (err err
 	return err
 }
 
+// SplitJPath splits a library path of the form <prefix>=<path>, which makes
+// the imports starting with the prefix resolve in the directory or archive.
+// The prefix is empty for the plain library paths, including the existing
+// paths containing "=".
+func SplitJPath(jpath string) (prefix string, dir string) {
+	if _, err := os.Stat(jpath); err == nil {
+		return "", jpath
+	}
+	prefix, dir, found := strings.Cut(jpath, "=")
+	if !found || prefix == "" {
+		return "", jpath
+	}
+	return prefix, dir
+}
+
+// MakeImporter creates the importer of the command line tools, with the
+// library paths jpaths (directories or archives, or <prefix>=<path>, see
+// SplitJPath). If dir belongs to a jsonnet-bundler project, the vendor
+// directory and the packages of the project are used too, with a lower
+// priority than jpaths. A project which cannot be read is ignored with
+// a warning, and no project is looked for if dir is empty. The prefixes take
+// precedence over everything else.
+func MakeImporter(dir string, jpaths []string) (jsonnet.Importer, error) {
+	var bundle *jsonnet.JsonnetBundle
+	if dir != "" {
+		var err error
+		bundle, err = jsonnet.FindJsonnetBundle(dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: ignoring the jsonnet-bundler project: %v\n", err)
+			bundle = nil
+		}
+	}
+	var plainJPaths []string
+	prefixes := make(map[string]jsonnet.Importer)
+	for _, jpath := range jpaths {
+		prefix, libDir := SplitJPath(jpath)
+		if prefix == "" {
+			plainJPaths = append(plainJPaths, jpath)
+			continue
+		}
+		libDir, err := filepath.Abs(libDir)
+		if err != nil {
+			return nil, err
+		}
+		// The right-most path wins, as for the plain paths.
+		prefixes[prefix] = &libraryImporter{
+			ArchiveImporter: &jsonnet.ArchiveImporter{JPaths: []string{libDir}},
+			root:            libDir,
+		}
+	}
+	importer := &jsonnet.JsonnetBundleImporter{Bundle: bundle, JPaths: plainJPaths}
+	if len(prefixes) == 0 {
+		return importer, nil
+	}
+	return &jsonnet.ImporterChain{Importers: []jsonnet.Importer{
+		&jsonnet.PrefixImporter{Prefixes: prefixes},
+		importer,
+	}}, nil
+}
+
+// libraryImporter imports the files of a <prefix>=<path> library path, which
+// may be a directory or an archive, like ArchiveImporter. The paths with the
+// prefix are resolved in the library only, and the locations of the files are
+// absolute, so the PrefixImporter returns them as they are.
+type libraryImporter struct {
+	*jsonnet.ArchiveImporter
+	root string
+}
+
+func (importer *libraryImporter) Import(importedFrom, importedPath string) (jsonnet.Contents, string, error) {
+	return importer.ImportContext(context.Background(), importedFrom, importedPath)
+}
+
+func (importer *libraryImporter) ImportContext(ctx context.Context, importedFrom, importedPath string) (jsonnet.Contents, string, error) {
+	if importedFrom == "" {
+		// Not the current directory.
+		importedFrom = importer.root + string(filepath.Separator)
+	}
+	return importer.ArchiveImporter.ImportContext(ctx, importedFrom, importedPath)
+}
+
 // StartCPUProfile creates a CPU profile if requested by environment
 // variable.
 func StartCPUProfile() {
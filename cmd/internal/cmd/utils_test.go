@@ -17,6 +17,9 @@ limitations under the License.
 package cmd
 
 import (
+	"archive/zip"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -56,3 +59,101 @@ func TestSimplifyArgs(t *testing.T) {
 	testSimplifyAux(t, "-abc", []string{"-abc"}, []string{"-a", "-b", "-c"})
 	testSimplifyAux(t, "-acb", []string{"-acb"}, []string{"-a", "-c", "-b"})
 }
+
+func TestSplitJPath(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "a=b")
+	if err := os.Mkdir(existing, 0755); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		jpath, prefix, dir string
+	}{
+		{"lib", "", "lib"},
+		{"corp=/path/to/lib", "corp", "/path/to/lib"},
+		{"github.com/corp=vendor/corp", "github.com/corp", "vendor/corp"},
+		{"=lib", "", "=lib"},
+		{existing, "", existing},
+	}
+	for _, test := range tests {
+		prefix, dir := SplitJPath(test.jpath)
+		if prefix != test.prefix || dir != test.dir {
+			t.Errorf("SplitJPath(%q): got %q, %q, expected %q, %q", test.jpath, prefix, dir, test.prefix, test.dir)
+		}
+	}
+}
+
+func TestMakeImporterBadBundle(t *testing.T) {
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "jsonnetfile.json"), []byte("{bad"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(sub, "a.libsonnet"), []byte("1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The malformed jsonnetfile.json is ignored.
+	for _, projectDir := range []string{sub, ""} {
+		importer, err := MakeImporter(projectDir, nil)
+		if err != nil {
+			t.Fatalf("MakeImporter(%q): unexpected error: %v", projectDir, err)
+		}
+		if _, _, err := importer.Import(filepath.Join(sub, "main.jsonnet"), "a.libsonnet"); err != nil {
+			t.Errorf("MakeImporter(%q): unexpected import error: %v", projectDir, err)
+		}
+	}
+}
+
+func TestMakeImporterPrefixes(t *testing.T) {
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "lib")
+	if err := os.Mkdir(lib, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"foo.libsonnet": `import "bar.libsonnet"`,
+		"bar.libsonnet": `"bar"`,
+	}
+	archive, err := os.Create(filepath.Join(dir, "lib.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(archive)
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(lib, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	importer, err := MakeImporter("", []string{"corp=" + lib, "arch=" + filepath.Join(dir, "lib.zip")})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// The locations are the real paths of the files, also inside the archives.
+	for prefix, libPath := range map[string]string{"corp": lib, "arch": filepath.Join(dir, "lib.zip")} {
+		_, foundAt, err := importer.Import(filepath.Join(dir, "main.jsonnet"), prefix+"/foo.libsonnet")
+		if expected := filepath.Join(libPath, "foo.libsonnet"); err != nil || foundAt != expected {
+			t.Errorf("%s: expected %q, got %q (%v)", prefix, expected, foundAt, err)
+		}
+		_, foundAt, err = importer.Import(foundAt, "bar.libsonnet")
+		if expected := filepath.Join(libPath, "bar.libsonnet"); err != nil || foundAt != expected {
+			t.Errorf("%s: expected %q, got %q (%v)", prefix, expected, foundAt, err)
+		}
+	}
+}
@@ -40,8 +40,11 @@ func usage(o io.Writer) {
 	fmt.Fprintln(o)
 	fmt.Fprintln(o, "Available options:")
 	fmt.Fprintln(o, "  -h / --help                This message")
-	fmt.Fprintln(o, "  -J / --jpath <dir>         Specify an additional library search dir")
-	fmt.Fprintln(o, "                             (right-most wins)")
+	fmt.Fprintln(o, "  -J / --jpath <dir>         Specify an additional library search dir, or")
+	fmt.Fprintln(o, "                             a zip or tar(.gz) archive (right-most wins).")
+	fmt.Fprintln(o, "                             With <prefix>=<path>, the imports starting with")
+	fmt.Fprintln(o, "                             <prefix>/ are resolved in <path>")
+	fmt.Fprintln(o, "  --no-jsonnetfile           Ignore the jsonnet-bundler project of the input")
 	fmt.Fprintln(o, "  -o / --output-file <file>  Write to the output file rather than stdout")
 	fmt.Fprintln(o, "  --version                  Print version")
 	fmt.Fprintln(o)
@@ -53,6 +56,14 @@ func usage(o io.Writer) {
 	fmt.Fprintln(o, "    JSONNET_PATH=d:c:a:b jsonnet")
 	fmt.Fprintln(o, "    jsonnet -J b -J a -J c -J d")
 	fmt.Fprintln(o)
+	fmt.Fprintln(o, "jsonnet-bundler projects:")
+	fmt.Fprintln(o, "  If the (first) input file is in a directory with a jsonnetfile.json, or in")
+	fmt.Fprintln(o, "  a subdirectory of one, its vendor and lib directories are library paths with")
+	fmt.Fprintln(o, "  a lower priority than JSONNET_PATH and --jpath. The imports starting with")
+	fmt.Fprintln(o, "  the name of a package from jsonnetfile.lock.json are resolved in it. An")
+	fmt.Fprintln(o, "  unreadable jsonnetfile.json is ignored with a warning. --no-jsonnetfile")
+	fmt.Fprintln(o, "  turns this off.")
+	fmt.Fprintln(o)
 	fmt.Fprintln(o, "In all cases:")
 	fmt.Fprintln(o, "  Multichar options are expanded e.g. -abc becomes -a -b -c.")
 	fmt.Fprintln(o, "  The -- option suppresses option processing for subsequent arguments.")
@@ -61,9 +72,10 @@ func usage(o io.Writer) {
 }
 
 type config struct {
-	inputFiles []string
-	outputFile string
-	jPaths     []string
+	inputFiles    []string
+	outputFile    string
+	jPaths        []string
+	noJsonnetfile bool
 }
 
 type processArgsStatus int
@@ -99,6 +111,8 @@ func processArgs(givenArgs []string, conf *config, vm *jsonnet.VM) (processArgsS
 				return processArgsStatusFailure, fmt.Errorf("-J argument was empty string")
 			}
 			conf.jPaths = append(conf.jPaths, dir)
+		} else if arg == "--no-jsonnetfile" {
+			conf.noJsonnetfile = true
 		} else if arg == "--" {
 			// All subsequent args are not options.
 			i++
@@ -184,7 +198,18 @@ func main() {
 		os.Exit(1)
 	}
 
-	vm.Importer(&jsonnet.FileImporter{JPaths: conf.jPaths})
+	projectDir := "."
+	if conf.noJsonnetfile {
+		projectDir = ""
+	} else if len(conf.inputFiles) > 0 {
+		projectDir = filepath.Dir(conf.inputFiles[0])
+	}
+	importer, err := cmd.MakeImporter(projectDir, conf.jPaths)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	vm.Importer(importer)
 
 	for _, file := range conf.inputFiles {
 		if _, err := os.Stat(file); err != nil {
@@ -24,8 +24,11 @@ func usage(o io.Writer) {
 	fmt.Fprintln(o)
 	fmt.Fprintln(o, "Available options:")
 	fmt.Fprintln(o, "  -h / --help                This message")
-	fmt.Fprintln(o, "  -J / --jpath <dir>         Specify an additional library search dir")
-	fmt.Fprintln(o, "                             (right-most wins)")
+	fmt.Fprintln(o, "  -J / --jpath <dir>         Specify an additional library search dir, or")
+	fmt.Fprintln(o, "                             a zip or tar(.gz) archive (right-most wins).")
+	fmt.Fprintln(o, "                             With <prefix>=<path>, the imports starting with")
+	fmt.Fprintln(o, "                             <prefix>/ are resolved in <path>")
+	fmt.Fprintln(o, "  --no-jsonnetfile           Ignore the jsonnet-bundler project of the input")
 	fmt.Fprintln(o, "  --version                  Print version")
 	fmt.Fprintln(o)
 	fmt.Fprintln(o, "Environment variables:")
@@ -36,6 +39,14 @@ func usage(o io.Writer) {
 	fmt.Fprintln(o, "    JSONNET_PATH=d:c:a:b jsonnet")
 	fmt.Fprintln(o, "    jsonnet -J b -J a -J c -J d")
 	fmt.Fprintln(o)
+	fmt.Fprintln(o, "jsonnet-bundler projects:")
+	fmt.Fprintln(o, "  If the (first) input file is in a directory with a jsonnetfile.json, or in")
+	fmt.Fprintln(o, "  a subdirectory of one, its vendor and lib directories are library paths with")
+	fmt.Fprintln(o, "  a lower priority than JSONNET_PATH and --jpath. The imports starting with")
+	fmt.Fprintln(o, "  the name of a package from jsonnetfile.lock.json are resolved in it. An")
+	fmt.Fprintln(o, "  unreadable jsonnetfile.json is ignored with a warning. --no-jsonnetfile")
+	fmt.Fprintln(o, "  turns this off.")
+	fmt.Fprintln(o)
 	fmt.Fprintln(o, "In all cases:")
 	fmt.Fprintln(o, "  <filename> can be - (stdin)")
 	fmt.Fprintln(o, "  Multichar options are expanded e.g. -abc becomes -a -b -c.")
@@ -52,8 +63,9 @@ func usage(o io.Writer) {
 
 type config struct {
 	// TODO(sbarzowski) Allow multiple root files checked at once for greater efficiency
-	inputFiles []string
-	evalJpath  []string
+	inputFiles    []string
+	evalJpath     []string
+	noJsonnetfile bool
 }
 
 func makeConfig() config {
@@ -100,6 +112,8 @@ func processArgs(givenArgs []string, config *config, vm *jsonnet.VM) (processArg
 				dir += "/"
 			}
 			config.evalJpath = append(config.evalJpath, dir)
+		} else if arg == "--no-jsonnetfile" {
+			config.noJsonnetfile = true
 		} else if len(arg) > 1 && arg[0] == '-' {
 			return processArgsStatusFailure, fmt.Errorf("unrecognized argument: %s", arg)
 		} else {
@@ -155,9 +169,17 @@ func main() {
 		os.Exit(1)
 	}
 
-	vm.Importer(&jsonnet.FileImporter{
-		JPaths: config.evalJpath,
-	})
+	projectDir := "."
+	if config.noJsonnetfile {
+		projectDir = ""
+	} else if len(config.inputFiles) > 0 {
+		projectDir = filepath.Dir(config.inputFiles[0])
+	}
+	importer, err := cmd.MakeImporter(projectDir, config.evalJpath)
+	if err != nil {
+		die(err)
+	}
+	vm.Importer(importer)
 
 	var snippets []linter.Snippet
 	for _, inputFile := range config.inputFiles {
@@ -17,16 +17,21 @@ limitations under the License.
 package main
 
 import (
+	"bufio"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/fatih/color"
 
 	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
 	"github.com/google/go-jsonnet/cmd/internal/cmd"
 )
 
@@ -42,17 +47,49 @@ func usage(o io.Writer) {
 	fmt.Fprintln(o, "Available options:")
 	fmt.Fprintln(o, "  -h / --help                This message")
 	fmt.Fprintln(o, "  -e / --exec                Treat filename as code")
-	fmt.Fprintln(o, "  -J / --jpath <dir>         Specify an additional library search dir")
-	fmt.Fprintln(o, "                             (right-most wins)")
+	fmt.Fprintln(o, "  -J / --jpath <dir>         Specify an additional library search dir, or")
+	fmt.Fprintln(o, "                             a zip or tar(.gz) archive (right-most wins).")
+	fmt.Fprintln(o, "                             With <prefix>=<path>, the imports starting with")
+	fmt.Fprintln(o, "                             <prefix>/ are resolved in <path>")
+	fmt.Fprintln(o, "  --no-jsonnetfile           Ignore the jsonnet-bundler project of the input")
+	fmt.Fprintln(o, "  --cache-dir <dir>          Cache the parsed imported files in the directory")
 	fmt.Fprintln(o, "  -o / --output-file <file>  Write to the output file rather than stdout")
 	fmt.Fprintln(o, "  -m / --multi <dir>         Write multiple files to the directory, list files")
 	fmt.Fprintln(o, "                             on stdout")
+	fmt.Fprintln(o, "  --parallel <n>             With --multi, manifest the files using n")
+	fmt.Fprintln(o, "                             goroutines")
 	fmt.Fprintln(o, "  -c / --create-output-dirs  Automatically creates all parent directories for")
 	fmt.Fprintln(o, "                             files")
 	fmt.Fprintln(o, "  -y / --yaml-stream         Write output as a YAML stream of JSON documents")
 	fmt.Fprintln(o, "  -S / --string              Expect a string, manifest as plain text")
+	fmt.Fprintln(o, "  --canonical                Write the JSON output in the canonical form of")
+	fmt.Fprintln(o, "                             RFC 8785, without whitespace, e.g. for hashing")
+	fmt.Fprintln(o, "  --preserve-order           Manifest the fields of the objects in the order")
+	fmt.Fprintln(o, "                             of their definitions instead of sorting them")
+	fmt.Fprintln(o, "  --output-format <format>   Manifest the output as json (the default), yaml,")
+	fmt.Fprintln(o, "                             toml or ini. With --multi, the files ending with")
+	fmt.Fprintln(o, "                             .json, .yaml, .yml, .toml or .ini are manifested")
+	fmt.Fprintln(o, "                             in the format of the extension")
 	fmt.Fprintln(o, "  -s / --max-stack <n>       Number of allowed stack frames")
 	fmt.Fprintln(o, "  -t / --max-trace <n>       Max length of stack trace before cropping")
+	fmt.Fprintln(o, "  --profile <file>           Write a profile of the evaluation in the pprof")
+	fmt.Fprintln(o, "                             format, see 'go tool pprof'")
+	fmt.Fprintln(o, "  --coverage-out <file>      Write the coverage of the evaluated files, as")
+	fmt.Fprintln(o, "                             Cobertura XML if <file> ends with .xml and as")
+	fmt.Fprintln(o, "                             LCOV otherwise (can be repeated)")
+	fmt.Fprintln(o, "  --source-map <file>        Write a JSON map from the paths in the output to")
+	fmt.Fprintln(o, "                             the code which produced the values, with all the")
+	fmt.Fprintln(o, "                             definitions of the overridden fields")
+	fmt.Fprintln(o, "  --path <path>              Evaluate and output only the value at <path>")
+	fmt.Fprintln(o, "                             (e.g. a.b[3].c), without evaluating the rest")
+	fmt.Fprintln(o, "  --explain <path>           Instead of the output, list the definitions of")
+	fmt.Fprintln(o, "                             the field at <path> (e.g. a.b[3].c) in the")
+	fmt.Fprintln(o, "                             objects combined with +, starting with the one")
+	fmt.Fprintln(o, "                             which overrides the others")
+	fmt.Fprintln(o, "  --watch                    Evaluate again whenever the input file, the files")
+	fmt.Fprintln(o, "                             of --ext-*-file and --tla-*-file or any of the")
+	fmt.Fprintln(o, "                             files they import changes, or a missing import")
+	fmt.Fprintln(o, "                             is created")
 	fmt.Fprintln(o, "  --version                  Print version")
 	fmt.Fprintln(o)
 	fmt.Fprintln(o, "Available options for specifying values of 'external' variables:")
@@ -83,6 +120,14 @@ func usage(o io.Writer) {
 	fmt.Fprintln(o, "    JSONNET_PATH=d:c:a:b jsonnet")
 	fmt.Fprintln(o, "    jsonnet -J b -J a -J c -J d")
 	fmt.Fprintln(o)
+	fmt.Fprintln(o, "jsonnet-bundler projects:")
+	fmt.Fprintln(o, "  If the (first) input file is in a directory with a jsonnetfile.json, or in")
+	fmt.Fprintln(o, "  a subdirectory of one, its vendor and lib directories are library paths with")
+	fmt.Fprintln(o, "  a lower priority than JSONNET_PATH and --jpath. The imports starting with")
+	fmt.Fprintln(o, "  the name of a package from jsonnetfile.lock.json are resolved in it. An")
+	fmt.Fprintln(o, "  unreadable jsonnetfile.json is ignored with a warning. --no-jsonnetfile")
+	fmt.Fprintln(o, "  turns this off.")
+	fmt.Fprintln(o)
 	fmt.Fprintln(o, "In all cases:")
 	fmt.Fprintln(o, "  <filename> can be - (stdin)")
 	fmt.Fprintln(o, "  Multichar options are expanded e.g. -abc becomes -a -b -c.")
@@ -96,10 +141,20 @@ type config struct {
 	evalMultiOutputDir   string
 	inputFiles           []string
 	evalJpath            []string
+	noJsonnetfile        bool
 	filenameIsCode       bool
 	evalMulti            bool
 	evalStream           bool
 	evalCreateOutputDirs bool
+	profileFile          string
+	coverageFiles        []string
+	sourceMapFile        string
+	explainPath          string
+	valuePath            string
+	watch                bool
+	varCodeFiles         []string
+	varStrFiles          []string
+	outputFormatSet      bool
 }
 
 func makeConfig() config {
@@ -165,6 +220,13 @@ func processArgs(givenArgs []string, config *config, vm *jsonnet.VM) (processArg
 			return err
 		}
 		handle(name, content)
+		// The files are watched with --watch.
+		file := strings.SplitN(next, "=", 2)[1]
+		if imp == "import" {
+			config.varCodeFiles = append(config.varCodeFiles, file)
+		} else {
+			config.varStrFiles = append(config.varStrFiles, file)
+		}
 		return nil
 	}
 
@@ -202,6 +264,14 @@ func processArgs(givenArgs []string, config *config, vm *jsonnet.VM) (processArg
 				return processArgsStatusFailure, fmt.Errorf("-J argument was empty string")
 			}
 			config.evalJpath = append(config.evalJpath, dir)
+		} else if arg == "--no-jsonnetfile" {
+			config.noJsonnetfile = true
+		} else if arg == "--cache-dir" {
+			dir := cmd.NextArg(&i, args)
+			if len(dir) == 0 {
+				return processArgsStatusFailure, fmt.Errorf("--cache-dir argument was empty string")
+			}
+			vm.SetASTCache(&jsonnet.DirASTCache{Dir: dir})
 		} else if arg == "-V" || arg == "--ext-str" {
 			if err := handleVarVal(vm.ExtVar); err != nil {
 				return processArgsStatusFailure, err
@@ -250,12 +320,61 @@ func processArgs(givenArgs []string, config *config, vm *jsonnet.VM) (processArg
 				outputDir += "/"
 			}
 			config.evalMultiOutputDir = outputDir
+		} else if arg == "--parallel" {
+			l := cmd.SafeStrToInt(cmd.NextArg(&i, args))
+			if l < 1 {
+				return processArgsStatusFailure, fmt.Errorf("invalid --parallel value: %d", l)
+			}
+			vm.Parallelism = l
+		} else if arg == "--profile" {
+			profileFile := cmd.NextArg(&i, args)
+			if len(profileFile) == 0 {
+				return processArgsStatusFailure, fmt.Errorf("--profile argument was empty string")
+			}
+			config.profileFile = profileFile
+		} else if arg == "--coverage-out" {
+			coverageFile := cmd.NextArg(&i, args)
+			if len(coverageFile) == 0 {
+				return processArgsStatusFailure, fmt.Errorf("--coverage-out argument was empty string")
+			}
+			config.coverageFiles = append(config.coverageFiles, coverageFile)
+		} else if arg == "--source-map" {
+			sourceMapFile := cmd.NextArg(&i, args)
+			if len(sourceMapFile) == 0 {
+				return processArgsStatusFailure, fmt.Errorf("--source-map argument was empty string")
+			}
+			config.sourceMapFile = sourceMapFile
+		} else if arg == "--path" {
+			valuePath := cmd.NextArg(&i, args)
+			if len(valuePath) == 0 {
+				return processArgsStatusFailure, fmt.Errorf("--path argument was empty string")
+			}
+			config.valuePath = valuePath
+		} else if arg == "--explain" {
+			explainPath := cmd.NextArg(&i, args)
+			if len(explainPath) == 0 {
+				return processArgsStatusFailure, fmt.Errorf("--explain argument was empty string")
+			}
+			config.explainPath = explainPath
+		} else if arg == "--watch" {
+			config.watch = true
 		} else if arg == "-c" || arg == "--create-output-dirs" {
 			config.evalCreateOutputDirs = true
 		} else if arg == "-y" || arg == "--yaml-stream" {
 			config.evalStream = true
 		} else if arg == "-S" || arg == "--string" {
 			vm.StringOutput = true
+		} else if arg == "--canonical" {
+			vm.CanonicalJSON = true
+		} else if arg == "--preserve-order" {
+			vm.PreserveOrder = true
+		} else if arg == "--output-format" {
+			format, err := jsonnet.ParseOutputFormat(cmd.NextArg(&i, args))
+			if err != nil {
+				return processArgsStatusFailure, err
+			}
+			vm.OutputFormat = format
+			config.outputFormatSet = true
 		} else if len(arg) > 1 && arg[0] == '-' {
 			return processArgsStatusFailure, fmt.Errorf("unrecognized argument: %s", arg)
 		} else {
@@ -280,6 +399,44 @@ func processArgs(givenArgs []string, config *config, vm *jsonnet.VM) (processArg
 		}
 	}
 
+	if config.outputFormatSet {
+		if vm.StringOutput {
+			return processArgsStatusFailure, fmt.Errorf("--output-format cannot be used with --string")
+		}
+		// The format of each file is picked by its extension.
+		vm.OutputFormatByExtension = config.evalMulti
+	}
+
+	if vm.CanonicalJSON {
+		if vm.StringOutput || vm.PreserveOrder || config.evalStream {
+			return processArgsStatusFailure, fmt.Errorf("--canonical cannot be used with --string, --preserve-order or --yaml-stream")
+		}
+		if config.outputFormatSet && vm.OutputFormat != jsonnet.OutputFormatJSON {
+			return processArgsStatusFailure, fmt.Errorf("--canonical requires the json output format")
+		}
+	}
+
+	if config.sourceMapFile != "" && (config.evalMulti || config.evalStream) {
+		return processArgsStatusFailure, fmt.Errorf("--source-map cannot be used with --multi or --yaml-stream")
+	}
+
+	if config.explainPath != "" && (config.evalMulti || config.evalStream || config.sourceMapFile != "") {
+		return processArgsStatusFailure, fmt.Errorf("--explain cannot be used with --multi, --yaml-stream or --source-map")
+	}
+
+	if config.valuePath != "" && (config.evalMulti || config.evalStream || config.sourceMapFile != "" || config.explainPath != "") {
+		return processArgsStatusFailure, fmt.Errorf("--path cannot be used with --multi, --yaml-stream, --source-map or --explain")
+	}
+
+	if config.watch {
+		if config.filenameIsCode || remainingArgs[0] == "-" {
+			return processArgsStatusFailure, fmt.Errorf("--watch requires an input file")
+		}
+		if config.profileFile != "" || len(config.coverageFiles) > 0 {
+			return processArgsStatusFailure, fmt.Errorf("--watch cannot be used with --profile or --coverage-out")
+		}
+	}
+
 	config.inputFiles = remainingArgs
 	return processArgsStatusContinue, nil
 }
@@ -352,6 +509,216 @@ func writeMultiOutputFiles(output map[string]string, outputDir, outputFile strin
 	return nil
 }
 
+// writeProfile writes the profile of the evaluation to a file.
+func writeProfile(profiler *jsonnet.Profiler, profileFile string) (err error) {
+	f, err := os.Create(profileFile)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if ferr := f.Close(); ferr != nil && err == nil {
+			err = ferr
+		}
+	}()
+	return profiler.WriteProfile(f)
+}
+
+// writeCoverage writes a coverage report to a file, in the Cobertura format
+// if its name ends with .xml and in the LCOV format otherwise.
+func writeCoverage(coverage *jsonnet.Coverage, coverageFile string) (err error) {
+	f, err := os.Create(coverageFile)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if ferr := f.Close(); ferr != nil && err == nil {
+			err = ferr
+		}
+	}()
+	if strings.HasSuffix(coverageFile, ".xml") {
+		return coverage.WriteCobertura(f)
+	}
+	return coverage.WriteLCOV(f)
+}
+
+// evaluateWithSourceMap evaluates the input, writes the output and the source
+// map.
+func evaluateWithSourceMap(vm *jsonnet.VM, config *config, filename string, input string, output io.Writer) error {
+	var result string
+	var sourceMap jsonnet.SourceMap
+	var err error
+	if config.filenameIsCode || config.inputFiles[0] == "-" {
+		result, sourceMap, err = vm.EvaluateAnonymousSnippetWithSourceMap(filename, input)
+	} else {
+		result, sourceMap, err = vm.EvaluateFileWithSourceMap(filename)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(output, result); err != nil {
+		return err
+	}
+	return writeSourceMap(sourceMap, config.sourceMapFile)
+}
+
+// evaluatePath evaluates the input and writes the value at config.valuePath.
+func evaluatePath(vm *jsonnet.VM, config *config, filename string, input string, output io.Writer) error {
+	var result string
+	var err error
+	if config.filenameIsCode || config.inputFiles[0] == "-" {
+		result, err = vm.EvaluateAnonymousSnippetPath(filename, input, config.valuePath)
+	} else {
+		result, err = vm.EvaluateFilePath(filename, config.valuePath)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(output, result)
+	return err
+}
+
+// explain evaluates the input and writes the definitions of the field at
+// config.explainPath, one per line, e.g.
+//
+//	app.jsonnet:4:15-32: spec.replicas: (consults super)
+//	lib/deployment.libsonnet:9:17-18: spec.replicas:
+func explain(vm *jsonnet.VM, config *config, filename string, input string, output io.Writer) error {
+	var v jsonnet.Value
+	var err error
+	if config.filenameIsCode || config.inputFiles[0] == "-" {
+		v, err = vm.EvaluateAnonymousSnippetToValue(filename, input)
+	} else {
+		v, err = vm.EvaluateFileToValue(filename)
+	}
+	if err != nil {
+		return err
+	}
+	definitions, err := v.FieldDefinitions(config.explainPath)
+	var runtimeErr jsonnet.RuntimeError
+	if errors.As(err, &runtimeErr) {
+		// The errors of the values are not formatted.
+		return errors.New(vm.ErrorFormatter.Format(err))
+	} else if err != nil {
+		return err
+	}
+	for _, definition := range definitions {
+		loc := definition.Loc.String()
+		if !definition.Loc.IsSet() {
+			loc = "(not defined by code)"
+		}
+		operator := map[ast.ObjectFieldHide]string{
+			ast.ObjectFieldHidden:  "::",
+			ast.ObjectFieldInherit: ":",
+			ast.ObjectFieldVisible: ":::",
+		}[definition.Hide]
+		if definition.PlusSuper {
+			operator = "+" + operator
+		}
+		line := fmt.Sprintf("%s: %s%s", loc, config.explainPath, operator)
+		if definition.PlusSuper || definition.UsesSuper {
+			line += " (consults super)"
+		}
+		if _, err := fmt.Fprintln(output, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeSourceMap(sourceMap jsonnet.SourceMap, sourceMapFile string) (err error) {
+	data, err := json.MarshalIndent(sourceMap, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(sourceMapFile)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if ferr := f.Close(); ferr != nil && err == nil {
+			err = ferr
+		}
+	}()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// outputFileWriter writes the output to a temporary file in the directory of
+// the output file, which replaces the output file only when the evaluation
+// succeeds, so that the existing file is left alone if it fails. The output
+// to an existing file which is not a regular one, e.g. /dev/stdout or
+// a symbolic link, is written to it directly.
+type outputFileWriter struct {
+	filename   string
+	createDirs bool
+	f          *os.File
+	direct     bool
+}
+
+func (w *outputFileWriter) Write(p []byte) (int, error) {
+	if w.f == nil {
+		if w.createDirs {
+			if err := os.MkdirAll(filepath.Dir(w.filename), 0755); err != nil {
+				return 0, err
+			}
+		}
+		var f *os.File
+		var err error
+		if info, statErr := os.Lstat(w.filename); statErr == nil && !info.Mode().IsRegular() {
+			w.direct = true
+			f, err = os.Create(w.filename)
+		} else {
+			f, err = os.CreateTemp(filepath.Dir(w.filename), "."+filepath.Base(w.filename)+".tmp*")
+		}
+		if err != nil {
+			return 0, err
+		}
+		w.f = f
+	}
+	return w.f.Write(p)
+}
+
+// close closes the temporary file and renames it to the output file if
+// the evaluation succeeded, or removes it otherwise.
+func (w *outputFileWriter) close(failed bool) error {
+	if failed {
+		if w.f == nil {
+			return nil
+		}
+		if w.direct {
+			return w.f.Close()
+		}
+		w.f.Close()
+		return os.Remove(w.f.Name())
+	}
+	if w.f == nil {
+		// No output, the file is still created.
+		if _, err := w.Write(nil); err != nil {
+			return err
+		}
+	}
+	if w.direct {
+		return w.f.Close()
+	}
+	// The file gets the permissions of the file it replaces, or the ones
+	// os.Create would give it.
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(w.filename); err == nil {
+		mode = info.Mode().Perm()
+	}
+	err := w.f.Chmod(mode)
+	if closeErr := w.f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(w.f.Name(), w.filename)
+	}
+	if err != nil {
+		os.Remove(w.f.Name())
+	}
+	return err
+}
+
 // writeOutputStream writes the output as a YAML stream.
 func writeOutputStream(output []string, outputFile string) (err error) {
 	var f *os.File
@@ -391,6 +758,224 @@ func writeOutputStream(output []string, outputFile string) (err error) {
 	return nil
 }
 
+// watchInterval is how often the watched files are checked for changes.
+const watchInterval = 500 * time.Millisecond
+
+// watchedFile is the state of a file watched with --watch.
+type watchedFile struct {
+	exists  bool
+	size    int64
+	modTime time.Time
+	// foundAts are the locations of the imported files contained in this
+	// one (more than one for an archive).
+	foundAts []string
+}
+
+func statWatchedFile(filename string) watchedFile {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return watchedFile{}
+	}
+	return watchedFile{exists: true, size: info.Size(), modTime: info.ModTime()}
+}
+
+// sourceFile returns the file containing the imported file at foundAt:
+// the file itself or the archive it is in.
+func sourceFile(foundAt string) string {
+	file := foundAt
+	// The archived files are watched through their archives.
+	for p := file; ; {
+		if info, err := os.Stat(p); err == nil {
+			if info.Mode().IsRegular() {
+				return p
+			}
+			return file
+		}
+		parent := filepath.Dir(p)
+		if parent == p {
+			return file
+		}
+		p = parent
+	}
+}
+
+// watch evaluates the input file whenever it or any of the files it imports
+// changes, until the process is killed. Only the changed files are read and
+// parsed again.
+func watch(vm *jsonnet.VM, config *config, filename string, input string) {
+	w := &watcher{vm: vm, config: config, filename: filename, input: input}
+	for {
+		if err := w.evaluate(); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+		}
+		var changed []string
+		for len(changed) == 0 {
+			time.Sleep(watchInterval)
+			changed = w.changed()
+		}
+		vm.InvalidateImports(changed...)
+	}
+}
+
+// watcher keeps the state of the files watched with --watch.
+type watcher struct {
+	vm       *jsonnet.VM
+	config   *config
+	filename string
+	input    string
+	// files are the watched files by their names.
+	files map[string]*watchedFile
+}
+
+// evaluate evaluates the input and starts watching the files it depends on.
+func (w *watcher) evaluate() error {
+	if w.files == nil {
+		w.files = make(map[string]*watchedFile)
+	}
+	// The state is taken before the evaluation, so that the changes
+	// made during it are not missed.
+	for name, file := range w.files {
+		state := statWatchedFile(name)
+		state.foundAts = file.foundAts
+		w.files[name] = &state
+	}
+	err := evaluate(w.vm, w.config, w.filename, w.input)
+
+	for _, foundAt := range watchedLocations(w.vm, w.config, w.filename, err) {
+		name := sourceFile(foundAt)
+		file, isWatched := w.files[name]
+		if !isWatched {
+			state := statWatchedFile(name)
+			file = &state
+			w.files[name] = file
+		}
+		if !stringsContain(file.foundAts, foundAt) {
+			file.foundAts = append(file.foundAts, foundAt)
+		}
+	}
+	return err
+}
+
+// changed returns the locations of the imported files contained in
+// the watched files which changed since the last evaluation.
+func (w *watcher) changed() []string {
+	var changed []string
+	for name, file := range w.files {
+		state := statWatchedFile(name)
+		if state.exists != file.exists || state.size != file.size || !state.modTime.Equal(file.modTime) {
+			changed = append(changed, file.foundAts...)
+		}
+	}
+	return changed
+}
+
+// watchedLocations returns the locations of the files to watch after
+// an evaluation which returned err: the input file, the files of the external
+// variables and the top-level arguments and the files they import. If an
+// import was not found, the locations where it was looked for are watched too,
+// so that creating the file is noticed.
+func watchedLocations(vm *jsonnet.VM, config *config, filename string, err error) []string {
+	var foundAts []string
+	addProbed := func(err error) {
+		var notFound *jsonnet.ImportNotFoundError
+		if errors.As(err, &notFound) {
+			foundAts = append(foundAts, notFound.Probed...)
+		}
+	}
+	addProbed(err)
+	addFile := func(file string, withDependencies bool) {
+		// If the dependencies can't be found (e.g. because of a syntax error),
+		// the ones found before are still watched.
+		if withDependencies {
+			deps, err := vm.FindDependencies("", []string{file})
+			foundAts = append(foundAts, deps...)
+			addProbed(err)
+		}
+		if foundAt, err := vm.ResolveImport("", file); err == nil {
+			foundAts = append(foundAts, foundAt)
+		} else {
+			foundAts = append(foundAts, file)
+		}
+	}
+	addFile(filename, true)
+	for _, file := range config.varCodeFiles {
+		addFile(file, true)
+	}
+	for _, file := range config.varStrFiles {
+		addFile(file, false)
+	}
+	return foundAts
+}
+
+func stringsContain(list []string, s string) bool {
+	for _, element := range list {
+		if element == s {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluate evaluates the input and writes the output.
+func evaluate(vm *jsonnet.VM, config *config, filename string, input string) (err error) {
+	// The regular output is streamed, so that it is never held in memory
+	// as a whole. Like with EvaluateFileTo, the part of the output written
+	// before an error stays on stdout, but the output file is replaced only
+	// on success.
+	stdout := bufio.NewWriter(os.Stdout)
+	var output io.Writer = stdout
+	var outputFile *outputFileWriter
+	if config.outputFile != "" && !config.evalMulti && !config.evalStream {
+		outputFile = &outputFileWriter{filename: config.outputFile, createDirs: config.evalCreateOutputDirs}
+		output = outputFile
+	}
+	var outputArray []string
+	var outputDict map[string]string
+	if config.explainPath != "" {
+		err = explain(vm, config, filename, input, output)
+	} else if config.sourceMapFile != "" {
+		err = evaluateWithSourceMap(vm, config, filename, input, output)
+	} else if config.valuePath != "" {
+		err = evaluatePath(vm, config, filename, input, output)
+	} else if config.filenameIsCode || config.inputFiles[0] == "-" {
+		if config.evalMulti {
+			outputDict, err = vm.EvaluateAnonymousSnippetMulti(filename, input)
+		} else if config.evalStream {
+			outputArray, err = vm.EvaluateAnonymousSnippetStream(filename, input)
+		} else {
+			err = vm.EvaluateAnonymousSnippetTo(output, filename, input)
+		}
+	} else {
+		if config.evalMulti {
+			outputDict, err = vm.EvaluateFileMulti(filename)
+		} else if config.evalStream {
+			outputArray, err = vm.EvaluateFileStream(filename)
+		} else {
+			err = vm.EvaluateFileTo(output, filename)
+		}
+	}
+
+	if outputFile != nil {
+		if closeErr := outputFile.close(err != nil); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := stdout.Flush(); err != nil {
+		return err
+	}
+
+	// Write output JSON.
+	if config.evalMulti {
+		return writeMultiOutputFiles(outputDict, config.evalMultiOutputDir, config.outputFile, config.evalCreateOutputDirs)
+	} else if config.evalStream {
+		return writeOutputStream(outputArray, config.outputFile)
+	}
+	return nil
+}
+
 func main() {
 	cmd.StartCPUProfile()
 	defer cmd.StopCPUProfile()
@@ -426,64 +1011,63 @@ func main() {
 		os.Exit(1)
 	}
 
-	vm.Importer(&jsonnet.FileImporter{
-		JPaths: config.evalJpath,
-	})
-
 	if len(config.inputFiles) != 1 {
 		// Should already have been caught by processArgs.
 		panic("Internal error: expected a single input file.")
 	}
 	filename := config.inputFiles[0]
-	// TODO(sbarzowski) Clean up SafeReadInput to be more in line with the new API
-	input := cmd.SafeReadInput(config.filenameIsCode, &filename)
-	var output string
-	var outputArray []string
-	var outputDict map[string]string
-	if config.filenameIsCode || config.inputFiles[0] == "-" {
-		if config.evalMulti {
-			outputDict, err = vm.EvaluateAnonymousSnippetMulti(filename, input)
-		} else if config.evalStream {
-			outputArray, err = vm.EvaluateAnonymousSnippetStream(filename, input)
-		} else {
-			output, err = vm.EvaluateAnonymousSnippet(filename, input)
-		}
-	} else {
-		if config.evalMulti {
-			outputDict, err = vm.EvaluateFileMulti(filename)
-		} else if config.evalStream {
-			outputArray, err = vm.EvaluateFileStream(filename)
-		} else {
-			output, err = vm.EvaluateFile(filename)
-		}
-	}
-
-	cmd.MemProfile()
 
+	projectDir := "."
+	if config.noJsonnetfile {
+		projectDir = ""
+	} else if !config.filenameIsCode && filename != "-" {
+		projectDir = filepath.Dir(filename)
+	}
+	importer, err := cmd.MakeImporter(projectDir, config.evalJpath)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(1)
 	}
+	vm.Importer(importer)
+	// TODO(sbarzowski) Clean up SafeReadInput to be more in line with the new API
+	input := cmd.SafeReadInput(config.filenameIsCode, &filename)
+	if config.watch {
+		watch(vm, &config, filename, input)
+	}
+	var profiler *jsonnet.Profiler
+	if config.profileFile != "" {
+		profiler = jsonnet.NewProfiler()
+		vm.SetProfiler(profiler)
+	}
+	var coverage *jsonnet.Coverage
+	if len(config.coverageFiles) > 0 {
+		coverage = jsonnet.NewCoverage()
+		vm.EvalHook = coverage.EvalHook()
+	}
 
-	// Write output JSON.
-	if config.evalMulti {
-		err := writeMultiOutputFiles(outputDict, config.evalMultiOutputDir, config.outputFile, config.evalCreateOutputDirs)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, err.Error())
-			os.Exit(1)
-		}
-	} else if config.evalStream {
-		err := writeOutputStream(outputArray, config.outputFile)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, err.Error())
+	err = evaluate(vm, &config, filename, input)
+
+	cmd.MemProfile()
+
+	if profiler != nil {
+		// The profile is also useful when the evaluation fails.
+		profiler.Stop()
+		if profileErr := writeProfile(profiler, config.profileFile); profileErr != nil {
+			fmt.Fprintln(os.Stderr, profileErr.Error())
 			os.Exit(1)
 		}
-	} else {
-		err := cmd.WriteOutputFile(output, config.outputFile, config.evalCreateOutputDirs)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, err.Error())
+	}
+	for _, coverageFile := range config.coverageFiles {
+		// The coverage is also useful when the evaluation fails.
+		if coverageErr := writeCoverage(coverage, coverageFile); coverageErr != nil {
+			fmt.Fprintln(os.Stderr, coverageErr.Error())
 			os.Exit(1)
 		}
 	}
 
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
 }
@@ -0,0 +1,87 @@
+/*
+Copyright 2026 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/cmd/internal/cmd"
+)
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, contents string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("main.jsonnet", `[import "a.libsonnet", import "b.libsonnet"]`)
+	write("a.libsonnet", `1`)
+
+	vm := jsonnet.MakeVM()
+	importer, err := cmd.MakeImporter("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm.Importer(importer)
+	config := makeConfig()
+	filename := filepath.Join(dir, "main.jsonnet")
+	config.inputFiles = []string{filename}
+	config.outputFile = filepath.Join(dir, "output.json")
+	w := &watcher{vm: vm, config: &config, filename: filename}
+
+	// Re-evaluates the input after a change and checks the output.
+	reevaluate := func(expected string) {
+		t.Helper()
+		changed := w.changed()
+		if len(changed) == 0 {
+			t.Fatalf("Expected a change to be noticed")
+		}
+		vm.InvalidateImports(changed...)
+		if err := w.evaluate(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		output, err := os.ReadFile(config.outputFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := strings.Join(strings.Fields(string(output)), ""); actual != expected {
+			t.Errorf("Expected %s, but got %s", expected, actual)
+		}
+	}
+
+	var notFound *jsonnet.ImportNotFoundError
+	if err := w.evaluate(); !errors.As(err, &notFound) {
+		t.Fatalf("Expected an import error, but got %v", err)
+	}
+	if changed := w.changed(); len(changed) != 0 {
+		t.Errorf("Expected no changes, but got %v", changed)
+	}
+
+	// The missing import is watched where it was looked for.
+	write("b.libsonnet", `2`)
+	reevaluate(`[1,2]`)
+
+	write("a.libsonnet", `"changed"`)
+	reevaluate(`["changed",2]`)
+}
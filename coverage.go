@@ -0,0 +1,517 @@
+/*
+Copyright 2026 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonnet
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/toolutils"
+)
+
+// Coverage collects the coverage of Jsonnet files by evaluations: which
+// expressions, object fields and branches of conditionals were evaluated.
+// Because of laziness, a field counts as covered only if its value was
+// actually needed, e.g. for the output.
+//
+// Coverage is collected through the evaluation hooks, set VM.EvalHook to
+// the result of EvalHook. Evaluations of many VMs, also concurrent ones, may
+// share one Coverage; the files are identified by their names.
+//
+// Only files are covered, not anonymous snippets or the standard library.
+// A file is covered only if it is evaluated at all, the files which are never
+// imported don't appear in the reports.
+type Coverage struct {
+	mu sync.Mutex
+	// hits are the numbers of evaluations of the registered nodes.
+	hits    map[ast.Node]int64
+	sources map[*ast.Source]bool
+	files   map[string]*fileCoverage
+}
+
+// fileCoverage is the coverage of a single file. The same file may be parsed
+// multiple times (e.g. by VMs with different import caches), so the entries
+// contain the corresponding nodes of all the parsed versions.
+type fileCoverage struct {
+	name string
+	// lines are the expressions beginning on each line, each with its nodes
+	// from all the parses.
+	lines    map[int][][]ast.Node
+	fields   []coveredField
+	branches []coveredBranch
+}
+
+// coveredField is a field of an object, identified by the path of the field
+// names leading to it.
+type coveredField struct {
+	path   string
+	line   int
+	column int
+	bodies []ast.Node
+}
+
+// coveredBranch is a conditional expression.
+type coveredBranch struct {
+	line  int
+	conds []*ast.Conditional
+}
+
+// NewCoverage creates an empty Coverage.
+func NewCoverage() *Coverage {
+	return &Coverage{
+		hits:    make(map[ast.Node]int64),
+		sources: make(map[*ast.Source]bool),
+		files:   make(map[string]*fileCoverage),
+	}
+}
+
+// EvalHook returns the hook which records the coverage. It is meant
+// for VM.EvalHook.
+func (c *Coverage) EvalHook() EvalHook {
+	return EvalHook{
+		pre:  func(i *interpreter, n ast.Node) { c.record(n) },
+		post: func(i *interpreter, n ast.Node, v value, err error) {},
+	}
+}
+
+func (c *Coverage) record(n ast.Node) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if count, registered := c.hits[n]; registered {
+		c.hits[n] = count + 1
+		return
+	}
+	loc := n.Loc()
+	if loc == nil || loc.File == nil || loc.FileName == "" || c.sources[loc.File] {
+		// Not a part of a file (e.g. the standard library or an anonymous snippet).
+		return
+	}
+	// The first evaluated node of a file is always its root, because all
+	// the other nodes are only reachable through the value of the file.
+	c.sources[loc.File] = true
+	file, exists := c.files[loc.FileName]
+	if !exists {
+		file = &fileCoverage{name: loc.FileName, lines: make(map[int][][]ast.Node)}
+		c.files[loc.FileName] = file
+	}
+	w := coverageWalker{c: c, file: file, source: loc.File, lineIndexes: make(map[int]int)}
+	w.walk(n, nil)
+	c.hits[n]++
+}
+
+// coverageWalker registers the nodes of a file. If the file was already
+// registered from another parse, the nodes are matched with the existing
+// entries by their order.
+type coverageWalker struct {
+	c           *Coverage
+	file        *fileCoverage
+	source      *ast.Source
+	fieldIndex  int
+	condIndex   int
+	lineIndexes map[int]int
+}
+
+func (w *coverageWalker) walk(node ast.Node, fieldPath []string) {
+	if node == nil {
+		return
+	}
+	loc := node.Loc()
+	if loc != nil && loc.File == w.source && loc.IsSet() {
+		w.c.hits[node] = 0
+		line := loc.Begin.Line
+		if index := w.lineIndexes[line]; index < len(w.file.lines[line]) {
+			w.file.lines[line][index] = append(w.file.lines[line][index], node)
+		} else {
+			w.file.lines[line] = append(w.file.lines[line], []ast.Node{node})
+		}
+		w.lineIndexes[line]++
+	}
+
+	switch node := node.(type) {
+	case *ast.Conditional:
+		if loc != nil && loc.File == w.source && loc.IsSet() {
+			// The branches are registered even if they don't have locations,
+			// like the implicit "else null".
+			w.c.hits[node.BranchTrue] += 0
+			w.c.hits[node.BranchFalse] += 0
+			if w.condIndex < len(w.file.branches) {
+				w.file.branches[w.condIndex].conds = append(w.file.branches[w.condIndex].conds, node)
+			} else {
+				w.file.branches = append(w.file.branches, coveredBranch{line: loc.Begin.Line, conds: []*ast.Conditional{node}})
+			}
+			w.condIndex++
+		}
+
+	case *ast.DesugaredObject:
+		fieldNames := make(map[ast.Node]string, len(node.Fields))
+		for _, field := range node.Fields {
+			name := "[computed]"
+			if str, isString := field.Name.(*ast.LiteralString); isString {
+				name = str.Value
+			}
+			fieldNames[field.Body] = name
+			if field.LocRange.File != w.source || !field.LocRange.IsSet() {
+				continue
+			}
+			w.c.hits[field.Body] += 0
+			if w.fieldIndex < len(w.file.fields) {
+				w.file.fields[w.fieldIndex].bodies = append(w.file.fields[w.fieldIndex].bodies, field.Body)
+			} else {
+				w.file.fields = append(w.file.fields, coveredField{
+					path:   strings.Join(append(fieldPath[:len(fieldPath):len(fieldPath)], name), "."),
+					line:   field.LocRange.Begin.Line,
+					column: field.LocRange.Begin.Column,
+					bodies: []ast.Node{field.Body},
+				})
+			}
+			w.fieldIndex++
+		}
+		for _, child := range toolutils.Children(node) {
+			if name, isBody := fieldNames[child]; isBody {
+				w.walk(child, append(fieldPath[:len(fieldPath):len(fieldPath)], name))
+			} else {
+				w.walk(child, fieldPath)
+			}
+		}
+		return
+	}
+
+	for _, child := range toolutils.Children(node) {
+		w.walk(child, fieldPath)
+	}
+}
+
+// coverageReport is a summary of the coverage of a file, for the reports.
+type coverageReport struct {
+	name     string
+	lines    []coverageLine
+	fields   []coverageField
+	branches []coverageBranch
+}
+
+type coverageLine struct {
+	number int
+	hits   int64
+}
+
+type coverageField struct {
+	name string
+	line int
+	hits int64
+}
+
+// coverageBranch is a conditional with the number of evaluations of the
+// condition and of each branch.
+type coverageBranch struct {
+	line                  int
+	hits, taken, notTaken int64
+}
+
+func (c *Coverage) reports() []coverageReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	names := make([]string, 0, len(c.files))
+	for name := range c.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	reports := make([]coverageReport, 0, len(names))
+	for _, name := range names {
+		file := c.files[name]
+		report := coverageReport{name: name}
+
+		lineNumbers := make([]int, 0, len(file.lines))
+		for line := range file.lines {
+			lineNumbers = append(lineNumbers, line)
+		}
+		sort.Ints(lineNumbers)
+		for _, line := range lineNumbers {
+			// A line is as covered as the most evaluated expression on it.
+			var hits int64
+			for _, nodes := range file.lines[line] {
+				var nodeHits int64
+				for _, node := range nodes {
+					nodeHits += c.hits[node]
+				}
+				if nodeHits > hits {
+					hits = nodeHits
+				}
+			}
+			report.lines = append(report.lines, coverageLine{number: line, hits: hits})
+		}
+
+		seen := make(map[string]bool)
+		for _, field := range file.fields {
+			var hits int64
+			for _, body := range field.bodies {
+				hits += c.hits[body]
+			}
+			// The names must be unique.
+			name := field.path
+			if seen[name] {
+				name = fmt.Sprintf("%s:%d:%d", field.path, field.line, field.column)
+			}
+			seen[name] = true
+			report.fields = append(report.fields, coverageField{name: name, line: field.line, hits: hits})
+		}
+
+		for _, branch := range file.branches {
+			b := coverageBranch{line: branch.line}
+			for _, cond := range branch.conds {
+				b.hits += c.hits[cond]
+				b.taken += c.hits[cond.BranchTrue]
+				b.notTaken += c.hits[cond.BranchFalse]
+			}
+			report.branches = append(report.branches, b)
+		}
+		reports = append(reports, report)
+	}
+	return reports
+}
+
+// WriteLCOV writes the coverage as an LCOV tracefile, as read e.g. by genhtml.
+// The object fields are reported as functions, named by the paths of fields
+// leading to them. Each conditional is a block with two branches.
+func (c *Coverage) WriteLCOV(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "TN:")
+	for _, report := range c.reports() {
+		fmt.Fprintf(bw, "SF:%s\n", report.name)
+
+		fieldsHit := 0
+		for _, field := range report.fields {
+			fmt.Fprintf(bw, "FN:%d,%s\n", field.line, field.name)
+		}
+		for _, field := range report.fields {
+			fmt.Fprintf(bw, "FNDA:%d,%s\n", field.hits, field.name)
+			if field.hits > 0 {
+				fieldsHit++
+			}
+		}
+		fmt.Fprintf(bw, "FNF:%d\nFNH:%d\n", len(report.fields), fieldsHit)
+
+		branchesHit := 0
+		for block, branch := range report.branches {
+			for index, taken := range []int64{branch.taken, branch.notTaken} {
+				if branch.hits == 0 {
+					fmt.Fprintf(bw, "BRDA:%d,%d,%d,-\n", branch.line, block, index)
+					continue
+				}
+				fmt.Fprintf(bw, "BRDA:%d,%d,%d,%d\n", branch.line, block, index, taken)
+				if taken > 0 {
+					branchesHit++
+				}
+			}
+		}
+		fmt.Fprintf(bw, "BRF:%d\nBRH:%d\n", 2*len(report.branches), branchesHit)
+
+		linesHit := 0
+		for _, line := range report.lines {
+			fmt.Fprintf(bw, "DA:%d,%d\n", line.number, line.hits)
+			if line.hits > 0 {
+				linesHit++
+			}
+		}
+		fmt.Fprintf(bw, "LF:%d\nLH:%d\n", len(report.lines), linesHit)
+		fmt.Fprintln(bw, "end_of_record")
+	}
+	return bw.Flush()
+}
+
+// The structure of a Cobertura report, see
+// https://github.com/cobertura/web/blob/master/htdocs/xml/coverage-04.dtd
+type coberturaCoverage struct {
+	XMLName         xml.Name           `xml:"coverage"`
+	LineRate        string             `xml:"line-rate,attr"`
+	BranchRate      string             `xml:"branch-rate,attr"`
+	LinesCovered    int                `xml:"lines-covered,attr"`
+	LinesValid      int                `xml:"lines-valid,attr"`
+	BranchesCovered int                `xml:"branches-covered,attr"`
+	BranchesValid   int                `xml:"branches-valid,attr"`
+	Complexity      string             `xml:"complexity,attr"`
+	Version         string             `xml:"version,attr"`
+	Timestamp       int64              `xml:"timestamp,attr"`
+	Sources         []string           `xml:"sources>source"`
+	Packages        []coberturaPackage `xml:"packages>package"`
+}
+
+type coberturaPackage struct {
+	Name       string           `xml:"name,attr"`
+	LineRate   string           `xml:"line-rate,attr"`
+	BranchRate string           `xml:"branch-rate,attr"`
+	Complexity string           `xml:"complexity,attr"`
+	Classes    []coberturaClass `xml:"classes>class"`
+}
+
+type coberturaClass struct {
+	Name       string            `xml:"name,attr"`
+	Filename   string            `xml:"filename,attr"`
+	LineRate   string            `xml:"line-rate,attr"`
+	BranchRate string            `xml:"branch-rate,attr"`
+	Complexity string            `xml:"complexity,attr"`
+	Methods    []coberturaMethod `xml:"methods>method"`
+	Lines      []coberturaLine   `xml:"lines>line"`
+}
+
+type coberturaMethod struct {
+	Name       string          `xml:"name,attr"`
+	Signature  string          `xml:"signature,attr"`
+	LineRate   string          `xml:"line-rate,attr"`
+	BranchRate string          `xml:"branch-rate,attr"`
+	Complexity string          `xml:"complexity,attr"`
+	Lines      []coberturaLine `xml:"lines>line"`
+}
+
+type coberturaLine struct {
+	Number            int    `xml:"number,attr"`
+	Hits              int64  `xml:"hits,attr"`
+	Branch            bool   `xml:"branch,attr"`
+	ConditionCoverage string `xml:"condition-coverage,attr,omitempty"`
+}
+
+// coverageCounts counts the covered and all lines and branches.
+type coverageCounts struct {
+	linesCovered, lines       int
+	branchesCovered, branches int
+}
+
+func (counts *coverageCounts) add(other coverageCounts) {
+	counts.linesCovered += other.linesCovered
+	counts.lines += other.lines
+	counts.branchesCovered += other.branchesCovered
+	counts.branches += other.branches
+}
+
+func coverageRate(covered, all int) string {
+	if all == 0 {
+		return "1"
+	}
+	return fmt.Sprintf("%.4g", float64(covered)/float64(all))
+}
+
+// WriteCobertura writes the coverage as a Cobertura XML report. Every file
+// is a class (in a package named after its directory) and the object fields
+// are its methods.
+func (c *Coverage) WriteCobertura(w io.Writer) error {
+	var total coverageCounts
+	packages := map[string]*coberturaPackage{}
+	packageCounts := map[string]*coverageCounts{}
+	var packageNames []string
+
+	for _, report := range c.reports() {
+		var counts coverageCounts
+		branchesByLine := make(map[int][]coverageBranch)
+		for _, branch := range report.branches {
+			branchesByLine[branch.line] = append(branchesByLine[branch.line], branch)
+		}
+
+		class := coberturaClass{Name: report.name, Filename: report.name, Complexity: "0"}
+		lineHits := make(map[int]int64)
+		for _, line := range report.lines {
+			lineHits[line.number] = line.hits
+			l := coberturaLine{Number: line.number, Hits: line.hits}
+			if branches := branchesByLine[line.number]; len(branches) > 0 {
+				covered := 0
+				for _, branch := range branches {
+					if branch.taken > 0 {
+						covered++
+					}
+					if branch.notTaken > 0 {
+						covered++
+					}
+				}
+				l.Branch = true
+				l.ConditionCoverage = fmt.Sprintf("%d%% (%d/%d)", covered*100/(2*len(branches)), covered, 2*len(branches))
+				counts.branchesCovered += covered
+				counts.branches += 2 * len(branches)
+			}
+			if line.hits > 0 {
+				counts.linesCovered++
+			}
+			counts.lines++
+			class.Lines = append(class.Lines, l)
+		}
+		for _, field := range report.fields {
+			rate := "0"
+			if field.hits > 0 {
+				rate = "1"
+			}
+			class.Methods = append(class.Methods, coberturaMethod{
+				Name:       field.name,
+				LineRate:   rate,
+				BranchRate: rate,
+				Complexity: "0",
+				Lines:      []coberturaLine{{Number: field.line, Hits: field.hits}},
+			})
+		}
+		class.LineRate = coverageRate(counts.linesCovered, counts.lines)
+		class.BranchRate = coverageRate(counts.branchesCovered, counts.branches)
+
+		packageName := path.Dir(report.name)
+		pkg, exists := packages[packageName]
+		if !exists {
+			pkg = &coberturaPackage{Name: packageName, Complexity: "0"}
+			packages[packageName] = pkg
+			packageCounts[packageName] = &coverageCounts{}
+			packageNames = append(packageNames, packageName)
+		}
+		pkg.Classes = append(pkg.Classes, class)
+		packageCounts[packageName].add(counts)
+		total.add(counts)
+	}
+
+	result := coberturaCoverage{
+		LineRate:        coverageRate(total.linesCovered, total.lines),
+		BranchRate:      coverageRate(total.branchesCovered, total.branches),
+		LinesCovered:    total.linesCovered,
+		LinesValid:      total.lines,
+		BranchesCovered: total.branchesCovered,
+		BranchesValid:   total.branches,
+		Complexity:      "0",
+		Version:         version,
+		Timestamp:       time.Now().UnixMilli(),
+		Sources:         []string{"."},
+	}
+	sort.Strings(packageNames)
+	for _, name := range packageNames {
+		pkg := packages[name]
+		counts := packageCounts[name]
+		pkg.LineRate = coverageRate(counts.linesCovered, counts.lines)
+		pkg.BranchRate = coverageRate(counts.branchesCovered, counts.branches)
+		result.Packages = append(result.Packages, *pkg)
+	}
+
+	if _, err := io.WriteString(w, xml.Header+`<!DOCTYPE coverage SYSTEM "http://cobertura.sourceforge.net/xml/coverage-04.dtd">`+"\n"); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(result); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,658 @@
+/*
+Copyright 2026 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonnet
+
+import (
+	"bytes"
+	"context"
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/go-jsonnet/ast"
+)
+
+// EvaluateInto evaluates Jsonnet code in a file and stores the result in
+// the value pointed to by out.
+//
+// The result is the same as from json.Unmarshal of the output of EvaluateFile
+// (in particular the json tags of struct fields are honored), but the output
+// is never serialized. Unlike json.Unmarshal, the decoding stops at the first
+// error, e.g. a field of a wrong type.
+//
+// The importer is used to fetch the contents of the file.
+func (vm *VM) EvaluateInto(filename string, out interface{}) (formattedErr error) {
+	if err := checkDecodeTarget(out); err != nil {
+		return err
+	}
+	output, err := vm.evaluateFile(context.Background(), filename, evalKindValue)
+	return vm.decodeResult(output, err, out)
+}
+
+// EvaluateAnonymousSnippetInto evaluates a string containing Jsonnet code
+// and stores the result in the value pointed to by out, like EvaluateInto.
+//
+// The filename parameter is only used for error messages.
+func (vm *VM) EvaluateAnonymousSnippetInto(filename string, snippet string, out interface{}) (formattedErr error) {
+	if err := checkDecodeTarget(out); err != nil {
+		return err
+	}
+	output, err := vm.evaluateSnippet(context.Background(), ast.DiagnosticFileName(filename), "", snippet, evalKindValue)
+	return vm.decodeResult(output, err, out)
+}
+
+// EvaluateProgramInto evaluates a compiled program and stores the result
+// in the value pointed to by out, like EvaluateInto.
+func (vm *VM) EvaluateProgramInto(p *Program, out interface{}) (formattedErr error) {
+	if err := checkDecodeTarget(out); err != nil {
+		return err
+	}
+	output, err := vm.evaluateNode(context.Background(), p.node, evalKindValue)
+	return vm.decodeResult(output, err, out)
+}
+
+func (vm *VM) decodeResult(output interface{}, err error, out interface{}) error {
+	if err != nil {
+		return vm.formatError(err)
+	}
+	v := output.(Value)
+	err = func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("(CRASH) %v\n%s", r, debug.Stack())
+			}
+		}()
+		v.i.stack.setCurrentTrace(manifestationTrace())
+		defer v.i.stack.clearCurrentTrace()
+		val, err := v.force(v.i)
+		if err != nil {
+			return err
+		}
+		return v.i.manifestInto(val, reflect.ValueOf(out).Elem())
+	}()
+	if err != nil {
+		return vm.formatError(err)
+	}
+	return nil
+}
+
+// Decode evaluates the value completely and stores it in the value pointed
+// to by out, following the rules of json.Unmarshal, like VM.EvaluateInto.
+func (v Value) Decode(out interface{}) error {
+	if err := checkDecodeTarget(out); err != nil {
+		return err
+	}
+	return v.inEvaluation(func() error {
+		val, err := v.force(v.i)
+		if err != nil {
+			return err
+		}
+		return v.i.manifestInto(val, reflect.ValueOf(out).Elem())
+	})
+}
+
+func checkDecodeTarget(out interface{}) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("the result can only be stored through a non-nil pointer, got %T", out)
+	}
+	return nil
+}
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+func (i *interpreter) decodeTypeError(v value, out reflect.Value) error {
+	return i.Error(fmt.Sprintf("cannot store %s in Go value of type %v", v.getType().name, out.Type()))
+}
+
+// manifestInto is like manifestJSON, but it stores the result directly
+// in out, following the rules of json.Unmarshal.
+func (i *interpreter) manifestInto(v value, out reflect.Value) error {
+	if i.stack.currentTrace == (traceElement{}) {
+		panic("manifesting JSON with empty traceElement")
+	}
+
+	_, isNull := v.(*valueNull)
+	if out.Kind() == reflect.Interface && !out.IsNil() {
+		// Like json.Unmarshal, the value is stored in the target of a non-nil
+		// pointer in an interface. Null only resets a pointer it points to.
+		if target := out.Elem(); target.Kind() == reflect.Ptr && !target.IsNil() {
+			if !isNull {
+				return i.manifestInto(v, target)
+			}
+			if target.Elem().Kind() == reflect.Ptr {
+				return i.manifestInto(v, target.Elem())
+			}
+		}
+	}
+	if isNull {
+		// Like json.Unmarshal, null is passed to a json.Unmarshaler which
+		// is not behind a pointer, and otherwise it only affects the values
+		// which can be nil.
+		if out.Kind() != reflect.Ptr && out.CanAddr() && out.Addr().Type().Implements(jsonUnmarshalerType) {
+			return i.manifestIntoUnmarshaler(v, out.Addr().Interface().(json.Unmarshaler))
+		}
+		switch out.Kind() {
+		case reflect.Interface, reflect.Ptr, reflect.Map, reflect.Slice:
+			out.Set(reflect.Zero(out.Type()))
+		}
+		return nil
+	}
+	for out.Kind() == reflect.Ptr {
+		if out.IsNil() {
+			out.Set(reflect.New(out.Type().Elem()))
+		}
+		out = out.Elem()
+	}
+
+	if out.CanAddr() {
+		if out.Addr().Type().Implements(jsonUnmarshalerType) {
+			return i.manifestIntoUnmarshaler(v, out.Addr().Interface().(json.Unmarshaler))
+		}
+		if str, isString := v.(valueString); isString && out.Addr().Type().Implements(textUnmarshalerType) {
+			err := out.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(str.getGoString()))
+			if err != nil {
+				return i.Error(err.Error())
+			}
+			return nil
+		}
+	}
+
+	if out.Kind() == reflect.Interface {
+		if out.NumMethod() != 0 {
+			return i.decodeTypeError(v, out)
+		}
+		json, err := i.manifestJSON(v)
+		if err != nil {
+			return err
+		}
+		if json == nil {
+			out.Set(reflect.Zero(out.Type()))
+		} else {
+			out.Set(reflect.ValueOf(json))
+		}
+		return nil
+	}
+
+	// Fresh frame for better stack traces
+	err := i.newCall(environment{}, false)
+	if err != nil {
+		return err
+	}
+	stackSize := len(i.stack.stack)
+	defer i.stack.popIfExists(stackSize)
+
+	switch v := v.(type) {
+
+	case *valueBoolean:
+		if out.Kind() != reflect.Bool {
+			return i.decodeTypeError(v, out)
+		}
+		out.SetBool(v.value)
+
+	case *valueFunction:
+		return makeRuntimeError("couldn't manifest function as JSON", i.getCurrentStackTrace())
+
+	case *valueNumber:
+		return i.manifestNumberInto(v, out)
+
+	case valueString:
+		switch {
+		case out.Kind() == reflect.String:
+			out.SetString(v.getGoString())
+		case out.Kind() == reflect.Slice && out.Type().Elem().Kind() == reflect.Uint8:
+			// Like json.Unmarshal, byte slices are encoded as base64.
+			data, err := base64.StdEncoding.DecodeString(v.getGoString())
+			if err != nil {
+				return i.Error(fmt.Sprintf("cannot decode base64 string into Go value of type %v: %v", out.Type(), err))
+			}
+			out.SetBytes(data)
+		default:
+			return i.decodeTypeError(v, out)
+		}
+
+	case *valueArray:
+		// Like json.Unmarshal, the existing elements are decoded into and
+		// the ones after the decoded elements are dropped or zeroed.
+		switch out.Kind() {
+		case reflect.Slice:
+			if len(v.elements) == 0 {
+				out.Set(reflect.MakeSlice(out.Type(), 0, 0))
+			} else if out.Cap() < len(v.elements) {
+				grown := reflect.MakeSlice(out.Type(), len(v.elements), len(v.elements))
+				reflect.Copy(grown, out)
+				out.Set(grown)
+			} else {
+				oldLen := out.Len()
+				out.SetLen(len(v.elements))
+				for index := oldLen; index < out.Len(); index++ {
+					out.Index(index).Set(reflect.Zero(out.Type().Elem()))
+				}
+			}
+		case reflect.Array:
+			for index := len(v.elements); index < out.Len(); index++ {
+				out.Index(index).Set(reflect.Zero(out.Type().Elem()))
+			}
+		default:
+			return i.decodeTypeError(v, out)
+		}
+		for index, th := range v.elements {
+			if index >= out.Len() {
+				// Like json.Unmarshal, the extra elements are ignored,
+				// but they are still evaluated.
+				if err := i.manifestElementInto(index, th, reflect.New(emptyInterfaceType).Elem()); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := i.manifestElementInto(index, th, out.Index(index)); err != nil {
+				return err
+			}
+		}
+
+	case *valueObject:
+		return i.manifestObjectInto(v, out)
+
+	default:
+		return makeRuntimeError(
+			fmt.Sprintf("manifesting this value not implemented yet: %s", reflect.TypeOf(v)),
+			i.getCurrentStackTrace(),
+		)
+	}
+	return nil
+}
+
+var emptyInterfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+
+func (i *interpreter) manifestIntoUnmarshaler(v value, unmarshaler json.Unmarshaler) error {
+	json, err := i.manifestJSON(v)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	serializeJSON(json, false, "", &buf)
+	if err := unmarshaler.UnmarshalJSON(buf.Bytes()); err != nil {
+		return i.Error(err.Error())
+	}
+	return nil
+}
+
+func (i *interpreter) manifestNumberInto(v *valueNumber, out reflect.Value) error {
+	switch out.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.value != math.Trunc(v.value) || v.value < math.MinInt64 || v.value >= math.MaxInt64 || out.OverflowInt(int64(v.value)) {
+			return i.Error(fmt.Sprintf("cannot store number %v in Go value of type %v", unparseNumber(v.value), out.Type()))
+		}
+		out.SetInt(int64(v.value))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.value != math.Trunc(v.value) || v.value < 0 || v.value >= math.MaxUint64 || out.OverflowUint(uint64(v.value)) {
+			return i.Error(fmt.Sprintf("cannot store number %v in Go value of type %v", unparseNumber(v.value), out.Type()))
+		}
+		out.SetUint(uint64(v.value))
+	case reflect.Float32, reflect.Float64:
+		if out.OverflowFloat(v.value) {
+			return i.Error(fmt.Sprintf("cannot store number %v in Go value of type %v", unparseNumber(v.value), out.Type()))
+		}
+		out.SetFloat(v.value)
+	default:
+		return i.decodeTypeError(v, out)
+	}
+	return nil
+}
+
+func (i *interpreter) manifestElementInto(index int, th *cachedThunk, out reflect.Value) error {
+	msg := ast.MakeLocationRangeMessage(fmt.Sprintf("Array element %d", index))
+	i.stack.setCurrentTrace(traceElement{
+		loc: &msg,
+	})
+	defer i.stack.clearCurrentTrace()
+	elVal, err := i.evaluatePV(th)
+	if err != nil {
+		return err
+	}
+	return i.manifestInto(elVal, out)
+}
+
+func (i *interpreter) manifestFieldInto(obj *valueObject, fieldName string, quoted bool, out reflect.Value) error {
+	msg := ast.MakeLocationRangeMessage(fmt.Sprintf("Field %#v", fieldName))
+	i.stack.setCurrentTrace(traceElement{
+		loc: &msg,
+	})
+	defer i.stack.clearCurrentTrace()
+	fieldVal, err := obj.index(i, fieldName)
+	if err != nil {
+		return err
+	}
+	if quoted {
+		return i.manifestQuotedInto(fieldVal, out)
+	}
+	return i.manifestInto(fieldVal, out)
+}
+
+// manifestQuotedInto stores a value of a struct field with the ,string
+// option, which like in json.Unmarshal must be a string containing JSON.
+func (i *interpreter) manifestQuotedInto(v value, out reflect.Value) error {
+	switch v := v.(type) {
+	case *valueNull:
+		return i.manifestInto(v, out)
+	case valueString:
+		if err := json.Unmarshal([]byte(v.getGoString()), out.Addr().Interface()); err != nil {
+			return i.Error(fmt.Sprintf("cannot store %q in Go value of type %v with the ,string option", v.getGoString(), out.Type()))
+		}
+		return nil
+	default:
+		return i.Error(fmt.Sprintf("cannot store %s in Go value of type %v with the ,string option", v.getType().name, out.Type()))
+	}
+}
+
+func (i *interpreter) manifestObjectInto(obj *valueObject, out reflect.Value) error {
+	var fields *decodeFields
+	switch out.Kind() {
+	case reflect.Map:
+		switch out.Type().Key().Kind() {
+		case reflect.String,
+			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		default:
+			if !reflect.PtrTo(out.Type().Key()).Implements(textUnmarshalerType) {
+				return i.decodeTypeError(obj, out)
+			}
+		}
+		if out.IsNil() {
+			out.Set(reflect.MakeMap(out.Type()))
+		}
+	case reflect.Struct:
+		fields = cachedDecodeFields(out.Type())
+	default:
+		return i.decodeTypeError(obj, out)
+	}
+
+	fieldNames := objectFields(obj, withoutHidden)
+	sort.Strings(fieldNames)
+
+	err := i.checkAssertionsForManifestation(obj)
+	if err != nil {
+		return err
+	}
+
+	for _, fieldName := range fieldNames {
+		if fields == nil {
+			key, err := i.decodeMapKey(fieldName, out.Type().Key())
+			if err != nil {
+				return err
+			}
+			elem := reflect.New(out.Type().Elem()).Elem()
+			if err := i.manifestFieldInto(obj, fieldName, false, elem); err != nil {
+				return err
+			}
+			out.SetMapIndex(key, elem)
+			continue
+		}
+		var target reflect.Value
+		quoted := false
+		if field := fields.lookup(fieldName); field != nil {
+			quoted = field.quoted
+			target, err = i.structField(out, field.index)
+			if err != nil {
+				return err
+			}
+		} else {
+			// Like json.Unmarshal, unknown fields are ignored,
+			// but they are still evaluated.
+			target = reflect.New(emptyInterfaceType).Elem()
+		}
+		if err := i.manifestFieldInto(obj, fieldName, quoted, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (i *interpreter) decodeMapKey(fieldName string, keyType reflect.Type) (reflect.Value, error) {
+	key := reflect.New(keyType).Elem()
+	// Like json.Unmarshal, an encoding.TextUnmarshaler takes precedence.
+	if unmarshaler, ok := key.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		if err := unmarshaler.UnmarshalText([]byte(fieldName)); err != nil {
+			return key, i.Error(err.Error())
+		}
+		return key, nil
+	}
+	switch keyType.Kind() {
+	case reflect.String:
+		key.SetString(fieldName)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(fieldName, 10, 64)
+		if err != nil || key.OverflowInt(n) {
+			return key, i.Error(fmt.Sprintf("cannot store field name %#v in Go value of type %v", fieldName, keyType))
+		}
+		key.SetInt(n)
+	default:
+		n, err := strconv.ParseUint(fieldName, 10, 64)
+		if err != nil || key.OverflowUint(n) {
+			return key, i.Error(fmt.Sprintf("cannot store field name %#v in Go value of type %v", fieldName, keyType))
+		}
+		key.SetUint(n)
+	}
+	return key, nil
+}
+
+// structField returns the field of a struct with the given index, allocating
+// the embedded structs on the way if necessary.
+func (i *interpreter) structField(v reflect.Value, index []int) (reflect.Value, error) {
+	for depth, fieldIndex := range index {
+		if depth > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return v, i.Error(fmt.Sprintf("cannot set embedded pointer to unexported struct %v", v.Type().Elem()))
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(fieldIndex)
+	}
+	return v, nil
+}
+
+// decodeField is a field of a struct to which an object field can be decoded.
+type decodeField struct {
+	name   string
+	index  []int
+	tagged bool
+	// quoted is set for the ,string option, which is honored only for
+	// the fields of a boolean, numeric or string type.
+	quoted bool
+}
+
+// decodeFields are the fields of a struct, found as by json.Unmarshal.
+type decodeFields struct {
+	list   []decodeField
+	byName map[string]*decodeField
+}
+
+// lookup finds a field by name. Like json.Unmarshal, it prefers
+// an exact match, but it also accepts a case-insensitive one.
+func (fields *decodeFields) lookup(name string) *decodeField {
+	if field, exists := fields.byName[name]; exists {
+		return field
+	}
+	for index := range fields.list {
+		if strings.EqualFold(fields.list[index].name, name) {
+			return &fields.list[index]
+		}
+	}
+	return nil
+}
+
+var decodeFieldsCache sync.Map // map[reflect.Type]*decodeFields
+
+func cachedDecodeFields(t reflect.Type) *decodeFields {
+	if fields, cached := decodeFieldsCache.Load(t); cached {
+		return fields.(*decodeFields)
+	}
+	fields, _ := decodeFieldsCache.LoadOrStore(t, structDecodeFields(t))
+	return fields.(*decodeFields)
+}
+
+// structDecodeFields finds the fields of a struct, including the ones
+// promoted from embedded structs, following the rules of encoding/json.
+func structDecodeFields(t reflect.Type) *decodeFields {
+	type queued struct {
+		t     reflect.Type
+		index []int
+	}
+	var found []decodeField
+	current := []queued{}
+	next := []queued{{t: t}}
+	visited := map[reflect.Type]bool{}
+
+	for len(next) > 0 {
+		current, next = next, nil
+		// The fields found at this depth, by name.
+		atDepth := map[string][]decodeField{}
+		var names []string
+
+		for _, q := range current {
+			if visited[q.t] {
+				continue
+			}
+			visited[q.t] = true
+			for fieldIndex := 0; fieldIndex < q.t.NumField(); fieldIndex++ {
+				sf := q.t.Field(fieldIndex)
+				ft := sf.Type
+				if ft.Name() == "" && ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if sf.Anonymous {
+					if !sf.IsExported() && ft.Kind() != reflect.Struct {
+						continue
+					}
+				} else if !sf.IsExported() {
+					continue
+				}
+				tag := sf.Tag.Get("json")
+				if tag == "-" {
+					continue
+				}
+				name, opts, _ := strings.Cut(tag, ",")
+				index := make([]int, len(q.index)+1)
+				copy(index, q.index)
+				index[len(q.index)] = fieldIndex
+
+				if name != "" || !sf.Anonymous || ft.Kind() != reflect.Struct {
+					tagged := name != ""
+					if name == "" {
+						name = sf.Name
+					}
+					if _, seen := atDepth[name]; !seen {
+						names = append(names, name)
+					}
+					quoted := false
+					if hasTagOption(opts, "string") {
+						switch ft.Kind() {
+						case reflect.Bool,
+							reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+							reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+							reflect.Float32, reflect.Float64, reflect.String:
+							quoted = true
+						}
+					}
+					atDepth[name] = append(atDepth[name], decodeField{name: name, index: index, tagged: tagged, quoted: quoted})
+					continue
+				}
+				next = append(next, queued{t: ft, index: index})
+			}
+		}
+
+		for _, name := range names {
+			candidates := atDepth[name]
+			if containsDecodeField(found, name) {
+				// Shadowed by a field at a lower depth.
+				continue
+			}
+			if len(candidates) == 1 {
+				found = append(found, candidates[0])
+				continue
+			}
+			// Like in encoding/json, a conflict is resolved only if exactly one of the fields is tagged.
+			var tagged []decodeField
+			for _, candidate := range candidates {
+				if candidate.tagged {
+					tagged = append(tagged, candidate)
+				}
+			}
+			if len(tagged) == 1 {
+				found = append(found, tagged[0])
+			} else {
+				// The name is ambiguous, it is ignored, also at the higher depths.
+				found = append(found, decodeField{name: name})
+			}
+		}
+	}
+
+	fields := &decodeFields{byName: make(map[string]*decodeField)}
+	for _, field := range found {
+		if field.index != nil {
+			fields.list = append(fields.list, field)
+		}
+	}
+	// Like in encoding/json, the fields are in the order of the index sequences.
+	sort.Slice(fields.list, func(a, b int) bool {
+		x, y := fields.list[a].index, fields.list[b].index
+		for k := 0; k < len(x) && k < len(y); k++ {
+			if x[k] != y[k] {
+				return x[k] < y[k]
+			}
+		}
+		return len(x) < len(y)
+	})
+	for index := range fields.list {
+		fields.byName[fields.list[index].name] = &fields.list[index]
+	}
+	return fields
+}
+
+func hasTagOption(opts string, option string) bool {
+	for opts != "" {
+		var opt string
+		opt, opts, _ = strings.Cut(opts, ",")
+		if opt == option {
+			return true
+		}
+	}
+	return false
+}
+
+func containsDecodeField(fields []decodeField, name string) bool {
+	for _, field := range fields {
+		if field.name == name {
+			return true
+		}
+	}
+	return false
+}
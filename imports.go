@@ -17,9 +17,15 @@ limitations under the License.
 package jsonnet
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
+	"sync"
 	"unsafe"
 
 	"github.com/google/go-jsonnet/ast"
@@ -27,7 +33,12 @@ import (
 )
 
 // An Importer imports data from a path.
-// TODO(sbarzowski) caching of errors (may require breaking changes)
+//
+// Importers should report the imports of files which don't exist with
+// an *ImportNotFoundError, which the ImportCache caches, so that the importer
+// is not asked again. Other errors (e.g. permission denied) are not cached.
+// Importers which may block (e.g. fetch the files over the network) should
+// implement ContextImporter.
 type Importer interface {
 	// Import fetches data from a given path. It may be relative
 	// to the file where we do the import. What "relative path"
@@ -57,6 +68,79 @@ type Importer interface {
 	Import(importedFrom, importedPath string) (contents Contents, foundAt string, err error)
 }
 
+// ContextImporter is an Importer which is also given the context of
+// the evaluation, so that the imports can be aborted when it is cancelled.
+// The evaluations use ImportContext instead of Import.
+type ContextImporter interface {
+	Importer
+	// ImportContext is like Import, but it should return an error wrapping
+	// ctx.Err() when ctx is cancelled.
+	ImportContext(ctx context.Context, importedFrom, importedPath string) (contents Contents, foundAt string, err error)
+}
+
+// InvalidatingImporter is an Importer whose cache of the imported files can
+// be invalidated, so that the files which changed are read again
+// (see ImportCache.Invalidate).
+type InvalidatingImporter interface {
+	Importer
+	// Invalidate forgets the files at the given locations, as returned by
+	// Import, and the cached nonexistence of all files, so that the files
+	// created in the meantime are found.
+	Invalidate(foundAts ...string)
+}
+
+// ErrImportNotFound is matched by the errors of the imports of files which
+// don't exist, with errors.Is. See ImportNotFoundError for the details.
+var ErrImportNotFound = errors.New("import not found")
+
+// ImportNotFoundError is the error of an import of a file which doesn't exist
+// in any of the probed locations. It matches ErrImportNotFound.
+//
+// During evaluation it is the cause of the RuntimeError of the import,
+// so it is available with errors.As.
+type ImportNotFoundError struct {
+	ImportedPath string
+	// Probed are the locations where the file was looked for, in order.
+	Probed []string
+	// message replaces the message about the library paths, e.g. for
+	// the importers which don't search any.
+	message string
+}
+
+func (err *ImportNotFoundError) Error() string {
+	if err.message != "" {
+		return err.message
+	}
+	return fmt.Sprintf("couldn't open import %#v: no match locally or in the Jsonnet library paths", err.ImportedPath)
+}
+
+// Is makes errors.Is(err, ErrImportNotFound) true.
+func (err *ImportNotFoundError) Is(target error) bool {
+	return target == ErrImportNotFound
+}
+
+// importWithContext imports data with ImportContext, if the importer
+// supports it.
+func importWithContext(ctx context.Context, importer Importer, importedFrom, importedPath string) (contents Contents, foundAt string, err error) {
+	if ci, ok := importer.(ContextImporter); ok {
+		return ci.ImportContext(ctx, importedFrom, importedPath)
+	}
+	return importer.Import(importedFrom, importedPath)
+}
+
+// mergeNotFound merges the locations probed by another importer into
+// the error of an import which is not found, if err is such an error.
+func mergeNotFound(notFound *ImportNotFoundError, err error, prefix string) bool {
+	var other *ImportNotFoundError
+	if !errors.As(err, &other) {
+		return false
+	}
+	for _, probed := range other.Probed {
+		notFound.Probed = append(notFound.Probed, prefix+probed)
+	}
+	return true
+}
+
 // Contents is a representation of imported data. It is a simple
 // byte wrapper, which makes it easier to enforce the caching policy.
 type Contents struct {
@@ -89,37 +173,103 @@ func MakeContentsRaw(bytes []byte) Contents {
 	}
 }
 
-// importCache represents a cache of imported data.
+// ImportCache represents a cache of imported data.
 //
 // While the user-defined Importer implementations
 // are required to cache file content, this cache
-// is an additional layer of optimization that caches values
-// (i.e. the result of executing the file content).
+// is an additional layer of optimization that caches parsed
+// and desugared ASTs of the imported files.
 // It also verifies that the content pointer is the same for two foundAt values.
-type importCache struct {
+// The imports of files which don't exist (see ImportNotFoundError) are cached
+// too, so the importer is asked only once about them.
+//
+// ImportCache is safe for concurrent use. It may be shared by
+// multiple VMs (see VM.SetImportCache), so that the imported files are
+// parsed only once. Calls to the underlying Importer are serialized,
+// so the Importer itself does not need to be safe for concurrent use.
+type ImportCache struct {
+	// importMu serializes the calls to the importer.
+	importMu sync.Mutex
+	importer Importer
+
+	// mu protects the maps below.
+	mu                  sync.Mutex
 	foundAtVerification map[string]Contents
-	astCache            map[string]ast.Node
-	codeCache           map[string]potentialValue
-	importer            Importer
+	astCache            map[string]astCacheEntry
+	// notFound are the errors of the imports of files which don't exist.
+	notFound map[importKey]error
+
+	// persistentCache is an optional second level cache of the ASTs.
+	persistentCache ASTCache
 }
 
-// makeImportCache creates an importCache using an Importer.
-func makeImportCache(importer Importer) *importCache {
-	return &importCache{
+type importKey struct {
+	importedFrom string
+	importedPath string
+}
+
+type astCacheEntry struct {
+	node ast.Node
+	err  error
+}
+
+// MakeImportCache creates an ImportCache using an Importer.
+func MakeImportCache(importer Importer) *ImportCache {
+	return &ImportCache{
 		importer:            importer,
 		foundAtVerification: make(map[string]Contents),
-		astCache:            make(map[string]ast.Node),
-		codeCache:           make(map[string]potentialValue),
+		astCache:            make(map[string]astCacheEntry),
+		notFound:            make(map[importKey]error),
 	}
 }
 
-func (cache *importCache) flushValueCache() {
-	cache.codeCache = make(map[string]potentialValue)
+// SetASTCache makes the ImportCache use a persistent cache of the ASTs,
+// so that the files are not parsed again by other processes (or other
+// ImportCaches). It must be called before the first import.
+func (cache *ImportCache) SetASTCache(astCache ASTCache) {
+	cache.persistentCache = astCache
 }
 
-func (cache *importCache) importData(importedFrom, importedPath string) (contents Contents, foundAt string, err error) {
-	contents, foundAt, err = cache.importer.Import(importedFrom, importedPath)
+// Invalidate forgets the files at the given locations (the foundAt values
+// returned by the importer), which are read and parsed again when they are
+// imported next time, and the imports of files which didn't exist.
+// The ASTs of the other files are reused. If the importer is
+// an InvalidatingImporter, its cache is invalidated too, otherwise
+// it must not return the old contents of the files anymore.
+//
+// It must not be called during an evaluation using the cache.
+func (cache *ImportCache) Invalidate(foundAts ...string) {
+	cache.importMu.Lock()
+	if importer, ok := cache.importer.(InvalidatingImporter); ok {
+		importer.Invalidate(foundAts...)
+	}
+	cache.importMu.Unlock()
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	for _, foundAt := range foundAts {
+		delete(cache.foundAtVerification, foundAt)
+		delete(cache.astCache, foundAt)
+	}
+	cache.notFound = make(map[importKey]error)
+}
+
+func (cache *ImportCache) importData(ctx context.Context, importedFrom, importedPath string) (contents Contents, foundAt string, err error) {
+	key := importKey{importedFrom: importedFrom, importedPath: importedPath}
+	cache.mu.Lock()
+	err, isNotFound := cache.notFound[key]
+	cache.mu.Unlock()
+	if isNotFound {
+		return Contents{}, "", err
+	}
+	cache.importMu.Lock()
+	contents, foundAt, err = importWithContext(ctx, cache.importer, importedFrom, importedPath)
+	cache.importMu.Unlock()
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
 	if err != nil {
+		if errors.Is(err, ErrImportNotFound) {
+			cache.notFound[key] = err
+		}
 		return Contents{}, "", err
 	}
 	if cached, importedBefore := cache.foundAtVerification[foundAt]; importedBefore {
@@ -132,35 +282,80 @@ func (cache *importCache) importData(importedFrom, importedPath string) (content
 	return
 }
 
-func (cache *importCache) importAST(importedFrom, importedPath string) (ast.Node, string, error) {
-	contents, foundAt, err := cache.importData(importedFrom, importedPath)
+func (cache *ImportCache) importAST(ctx context.Context, importedFrom, importedPath string) (ast.Node, string, error) {
+	contents, foundAt, err := cache.importData(ctx, importedFrom, importedPath)
 	if err != nil {
 		return nil, "", err
 	}
-	if cachedNode, isCached := cache.astCache[foundAt]; isCached {
-		return cachedNode, foundAt, nil
+	cache.mu.Lock()
+	entry, isCached := cache.astCache[foundAt]
+	cache.mu.Unlock()
+	if isCached {
+		return entry.node, foundAt, entry.err
 	}
-	node, err := program.SnippetToAST(ast.DiagnosticFileName(foundAt), foundAt, contents.String())
-	cache.astCache[foundAt] = node
+	// The lock is not held while parsing, so that different files can be
+	// parsed in parallel. If the same file is parsed concurrently,
+	// the first result to be stored wins.
+	node, err := cache.parse(foundAt, contents)
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if entry, isCached := cache.astCache[foundAt]; isCached {
+		return entry.node, foundAt, entry.err
+	}
+	cache.astCache[foundAt] = astCacheEntry{node: node, err: err}
 	return node, foundAt, err
 }
 
+func (cache *ImportCache) parse(foundAt string, contents Contents) (ast.Node, error) {
+	if cache.persistentCache == nil {
+		return program.SnippetToAST(ast.DiagnosticFileName(foundAt), foundAt, contents.String())
+	}
+	key := astCacheKey(foundAt, contents)
+	if node := loadAST(cache.persistentCache, key); node != nil {
+		return node, nil
+	}
+	node, err := program.SnippetToAST(ast.DiagnosticFileName(foundAt), foundAt, contents.String())
+	if err != nil {
+		// Only the successfully parsed files are cached.
+		return nil, err
+	}
+	storeAST(cache.persistentCache, key, node)
+	return node, nil
+}
+
 // ImportString imports a string, caches it and then returns it.
-func (cache *importCache) importString(importedFrom, importedPath string, i *interpreter) (valueString, error) {
-	data, _, err := cache.importData(importedFrom, importedPath)
+func (cache *ImportCache) importString(importedFrom, importedPath string, i *interpreter) (valueString, error) {
+	ctx := i.sandbox.importContext(i.ctx, "importstr", importedPath)
+	data, foundAt, err := cache.importData(ctx, importedFrom, importedPath)
 	if err != nil {
+		return nil, i.importError(err)
+	}
+	// The file may have been read before by an evaluation without the sandbox.
+	if err := i.sandbox.checkImported("importstr", importedPath, foundAt); err != nil {
 		return nil, i.Error(err.Error())
 	}
-	return makeValueString(data.String()), nil
+	result := makeValueString(data.String())
+	if err := i.allocateString(result.length()); err != nil {
+		return nil, err
+	}
+	return result, nil
 }
 
 // ImportString imports an array of bytes, caches it and then returns it.
-func (cache *importCache) importBinary(importedFrom, importedPath string, i *interpreter) (*valueArray, error) {
-	data, _, err := cache.importData(importedFrom, importedPath)
+func (cache *ImportCache) importBinary(importedFrom, importedPath string, i *interpreter) (*valueArray, error) {
+	ctx := i.sandbox.importContext(i.ctx, "importbin", importedPath)
+	data, foundAt, err := cache.importData(ctx, importedFrom, importedPath)
 	if err != nil {
+		return nil, i.importError(err)
+	}
+	// The file may have been read before by an evaluation without the sandbox.
+	if err := i.sandbox.checkImported("importbin", importedPath, foundAt); err != nil {
 		return nil, i.Error(err.Error())
 	}
 	bytes := data.Data()
+	if err := i.allocateArray(len(bytes)); err != nil {
+		return nil, err
+	}
 	elements := make([]*cachedThunk, len(bytes))
 	for i := range bytes {
 		elements[i] = readyThunk(intToValue(int(bytes[i])))
@@ -191,21 +386,30 @@ func codeToPV(i *interpreter, filename string, code string) *cachedThunk {
 }
 
 // ImportCode imports code from a path.
-func (cache *importCache) importCode(importedFrom, importedPath string, i *interpreter) (value, error) {
-	node, foundAt, err := cache.importAST(importedFrom, importedPath)
+//
+// The resulting values are cached in the interpreter, not in the ImportCache,
+// because values are mutated as they are evaluated and so they cannot
+// be shared between evaluations, which may run concurrently.
+func (cache *ImportCache) importCode(importedFrom, importedPath string, i *interpreter) (value, error) {
+	ctx := i.sandbox.importContext(i.ctx, "import", importedPath)
+	node, foundAt, err := cache.importAST(ctx, importedFrom, importedPath)
 	if err != nil {
+		return nil, i.importError(err)
+	}
+	// The file may have been read before by an evaluation without the sandbox.
+	if err := i.sandbox.checkImported("import", importedPath, foundAt); err != nil {
 		return nil, i.Error(err.Error())
 	}
 	var pv potentialValue
-	if cachedPV, isCached := cache.codeCache[foundAt]; !isCached {
-		// File hasn't been parsed and analyzed before, update the cache record.
+	if cachedPV, isCached := i.codeCache[foundAt]; !isCached {
+		// File hasn't been evaluated before, update the cache record.
 		env := makeInitialEnv(foundAt, i.baseStd)
 		pv = &cachedThunk{
 			env:     &env,
 			body:    node,
 			content: nil,
 		}
-		cache.codeCache[foundAt] = pv
+		i.codeCache[foundAt] = pv
 	} else {
 		pv = cachedPV
 	}
@@ -216,9 +420,12 @@ func (cache *importCache) importCode(importedFrom, importedPath string, i *inter
 // -------------------------------------
 
 // FileImporter imports data from the filesystem.
+// It is safe for concurrent use, but JPaths must not be modified
+// after the first import.
 type FileImporter struct {
-	fsCache map[string]*fsCacheEntry
-	JPaths  []string
+	fsCache   map[string]*fsCacheEntry
+	fsCacheMu sync.Mutex
+	JPaths    []string
 }
 
 type fsCacheEntry struct {
@@ -226,7 +433,9 @@ type fsCacheEntry struct {
 	exists   bool
 }
 
-func (importer *FileImporter) tryPath(dir, importedPath string) (found bool, contents Contents, foundHere string, err error) {
+func (importer *FileImporter) tryPath(ctx context.Context, dir, importedPath string) (found bool, contents Contents, foundHere string, err error) {
+	importer.fsCacheMu.Lock()
+	defer importer.fsCacheMu.Unlock()
 	if importer.fsCache == nil {
 		importer.fsCache = make(map[string]*fsCacheEntry)
 	}
@@ -239,6 +448,14 @@ func (importer *FileImporter) tryPath(dir, importedPath string) (found bool, con
 	var entry *fsCacheEntry
 	if cacheEntry, isCached := importer.fsCache[absPath]; isCached {
 		entry = cacheEntry
+	} else if err := checkImportLocation(ctx, absPath); err != nil {
+		// The file is not opened, so that nothing is read from the locations
+		// rejected by the sandbox (which might even block, e.g. a FIFO).
+		if _, statErr := os.Stat(absPath); !os.IsNotExist(statErr) {
+			return false, Contents{}, "", err
+		}
+		entry = &fsCacheEntry{exists: false}
+		importer.fsCache[absPath] = entry
 	} else {
 		contentBytes, err := os.ReadFile(absPath)
 		if err != nil {
@@ -260,30 +477,157 @@ func (importer *FileImporter) tryPath(dir, importedPath string) (found bool, con
 	return entry.exists, entry.contents, absPath, nil
 }
 
+// invalidateFSCache removes the entries of the given paths and of the files
+// which don't exist from an fsCache.
+func invalidateFSCache(fsCache map[string]*fsCacheEntry, paths []string) {
+	for _, p := range paths {
+		delete(fsCache, p)
+	}
+	for p, entry := range fsCache {
+		if !entry.exists {
+			delete(fsCache, p)
+		}
+	}
+}
+
+// Invalidate forgets the files at the given paths, so that they are read
+// again, and the files which didn't exist.
+func (importer *FileImporter) Invalidate(foundAts ...string) {
+	importer.fsCacheMu.Lock()
+	defer importer.fsCacheMu.Unlock()
+	invalidateFSCache(importer.fsCache, foundAts)
+}
+
 // Import imports file from the filesystem.
 func (importer *FileImporter) Import(importedFrom, importedPath string) (contents Contents, foundAt string, err error) {
+	return importer.ImportContext(context.Background(), importedFrom, importedPath)
+}
+
+// ImportContext imports file from the filesystem, checking ctx before
+// probing every location.
+func (importer *FileImporter) ImportContext(ctx context.Context, importedFrom, importedPath string) (contents Contents, foundAt string, err error) {
 	// TODO(sbarzowski) Make sure that dir is absolute and resolving of ""
 	// is independent from current CWD. The default path should be saved
 	// in the importer.
 	// We need to relativize the paths in the error formatter, so that the stack traces
 	// don't have ugly absolute paths (less readable and messy with golden tests).
+	notFound := &ImportNotFoundError{ImportedPath: importedPath}
 	dir, _ := filepath.Split(importedFrom)
-	found, content, foundHere, err := importer.tryPath(dir, importedPath)
-	if err != nil {
-		return Contents{}, "", err
+	dirs := []string{dir}
+	for i := len(importer.JPaths) - 1; i >= 0; i-- {
+		dirs = append(dirs, importer.JPaths[i])
 	}
-
-	for i := len(importer.JPaths) - 1; !found && i >= 0; i-- {
-		found, content, foundHere, err = importer.tryPath(importer.JPaths[i], importedPath)
+	for _, dir := range dirs {
+		if err := ctx.Err(); err != nil {
+			return Contents{}, "", err
+		}
+		found, content, foundHere, err := importer.tryPath(ctx, dir, importedPath)
 		if err != nil {
 			return Contents{}, "", err
 		}
+		if found {
+			return content, foundHere, nil
+		}
+		notFound.Probed = append(notFound.Probed, foundHere)
 	}
+	return Contents{}, "", notFound
+}
 
-	if !found {
-		return Contents{}, "", fmt.Errorf("couldn't open import %#v: no match locally or in the Jsonnet library paths", importedPath)
+// FSImporter imports data from an fs.FS, e.g. an embed.FS or fstest.MapFS.
+// It works like FileImporter, with the paths in the slash-separated form
+// of io/fs. Absolute import paths start at the root of the FS and the paths
+// leading outside of it are never found. The locations of the imported files
+// (and so std.thisFile) are their paths in the FS.
+//
+// It is safe for concurrent use if FS is, but JPaths must not be modified
+// after the first import.
+type FSImporter struct {
+	FS fs.FS
+	// JPaths are the library directories in the FS, searched last to first.
+	JPaths    []string
+	fsCache   map[string]*fsCacheEntry
+	fsCacheMu sync.Mutex
+}
+
+func (importer *FSImporter) tryPath(dir, importedPath string) (found bool, contents Contents, foundHere string, err error) {
+	importer.fsCacheMu.Lock()
+	defer importer.fsCacheMu.Unlock()
+	if importer.fsCache == nil {
+		importer.fsCache = make(map[string]*fsCacheEntry)
 	}
-	return content, foundHere, nil
+	var fsPath string
+	if path.IsAbs(importedPath) {
+		fsPath = path.Clean(strings.TrimLeft(importedPath, "/"))
+	} else {
+		fsPath = path.Join(dir, importedPath)
+	}
+	if !fs.ValidPath(fsPath) {
+		// E.g. the path leads outside of the FS.
+		return false, Contents{}, "", nil
+	}
+	var entry *fsCacheEntry
+	if cacheEntry, isCached := importer.fsCache[fsPath]; isCached {
+		entry = cacheEntry
+	} else {
+		contentBytes, err := fs.ReadFile(importer.FS, fsPath)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				entry = &fsCacheEntry{
+					exists: false,
+				}
+			} else {
+				return false, Contents{}, "", err
+			}
+		} else {
+			entry = &fsCacheEntry{
+				exists:   true,
+				contents: MakeContentsRaw(contentBytes),
+			}
+		}
+		importer.fsCache[fsPath] = entry
+	}
+	return entry.exists, entry.contents, fsPath, nil
+}
+
+// Invalidate forgets the files at the given paths in the FS, so that they
+// are read again, and the files which didn't exist.
+func (importer *FSImporter) Invalidate(foundAts ...string) {
+	importer.fsCacheMu.Lock()
+	defer importer.fsCacheMu.Unlock()
+	invalidateFSCache(importer.fsCache, foundAts)
+}
+
+// Import imports a file from the FS. The relative paths are resolved
+// against the directory of the importing file, then against JPaths.
+// The imports from snippets are resolved against the root of the FS.
+func (importer *FSImporter) Import(importedFrom, importedPath string) (contents Contents, foundAt string, err error) {
+	return importer.ImportContext(context.Background(), importedFrom, importedPath)
+}
+
+// ImportContext imports a file from the FS like Import, checking ctx before
+// probing every location.
+func (importer *FSImporter) ImportContext(ctx context.Context, importedFrom, importedPath string) (contents Contents, foundAt string, err error) {
+	notFound := &ImportNotFoundError{ImportedPath: importedPath}
+	dirs := []string{path.Dir(importedFrom)}
+	for i := len(importer.JPaths) - 1; i >= 0; i-- {
+		dirs = append(dirs, importer.JPaths[i])
+	}
+	for _, dir := range dirs {
+		if err := ctx.Err(); err != nil {
+			return Contents{}, "", err
+		}
+		found, content, foundHere, err := importer.tryPath(dir, importedPath)
+		if err != nil {
+			return Contents{}, "", err
+		}
+		if found {
+			return content, foundHere, nil
+		}
+		if foundHere != "" {
+			notFound.Probed = append(notFound.Probed, foundHere)
+		}
+	}
+	return Contents{}, "", notFound
 }
 
 // MemoryImporter "imports" data from an in-memory map.
@@ -297,5 +641,180 @@ func (importer *MemoryImporter) Import(importedFrom, importedPath string) (conte
 	if content, ok := importer.Data[importedPath]; ok {
 		return content, importedPath, nil
 	}
-	return Contents{}, "", fmt.Errorf("import not available %v", importedPath)
+	return Contents{}, "", &ImportNotFoundError{
+		ImportedPath: importedPath,
+		Probed:       []string{importedPath},
+		message:      fmt.Sprintf("import not available %v", importedPath),
+	}
+}
+
+// ImporterChain imports data with the first of Importers which finds
+// the file. If an importer fails otherwise (e.g. permission denied), its error
+// is returned. If none of them finds the file, the returned
+// *ImportNotFoundError contains the locations probed by all of them.
+//
+// The locations of the imported files must be unique among all the importers,
+// because the relative imports from a file are resolved by every importer.
+type ImporterChain struct {
+	Importers []Importer
+}
+
+// Import imports data with the importers in order.
+func (chain *ImporterChain) Import(importedFrom, importedPath string) (contents Contents, foundAt string, err error) {
+	return chain.ImportContext(context.Background(), importedFrom, importedPath)
+}
+
+// ImportContext imports data with the importers in order, passing ctx to
+// the ones which are ContextImporters.
+func (chain *ImporterChain) ImportContext(ctx context.Context, importedFrom, importedPath string) (contents Contents, foundAt string, err error) {
+	notFound := &ImportNotFoundError{ImportedPath: importedPath}
+	for _, importer := range chain.Importers {
+		contents, foundAt, err := importWithContext(ctx, importer, importedFrom, importedPath)
+		if err == nil {
+			return contents, foundAt, nil
+		}
+		if !mergeNotFound(notFound, err, "") {
+			return Contents{}, "", err
+		}
+	}
+	return Contents{}, "", notFound
+}
+
+// Invalidate invalidates the caches of the importers which are
+// InvalidatingImporters.
+func (chain *ImporterChain) Invalidate(foundAts ...string) {
+	for _, importer := range chain.Importers {
+		if importer, ok := importer.(InvalidatingImporter); ok {
+			importer.Invalidate(foundAts...)
+		}
+	}
+}
+
+// PrefixImporter routes the imports starting with a prefix, e.g. "corp" for
+// "corp/foo.libsonnet", to the importer of the prefix, similarly to the module
+// paths of Go. The importer gets the rest of the path ("foo.libsonnet"),
+// with an empty importedFrom. The longest matching prefix wins.
+//
+// The locations returned by the importer which are absolute paths, e.g. from
+// a FileImporter with an absolute library path, are returned as they are.
+// The other ones are prefixed in the same way as the imports, so they should
+// be relative to the root of the library, as with FSImporter.
+//
+// The relative imports from the files of a prefix are resolved by its importer
+// first, so the files of a library can import each other.
+type PrefixImporter struct {
+	// Prefixes maps the prefixes to their importers.
+	Prefixes map[string]Importer
+
+	ownersMu sync.Mutex
+	// owners are the prefixes of the imported files with absolute locations.
+	owners map[string]prefixOwner
+}
+
+type prefixOwner struct {
+	prefix string
+	target Importer
+}
+
+// owner finds the prefix of an imported file and its location as returned
+// by the importer of the prefix.
+func (importer *PrefixImporter) owner(foundAt string) (prefix string, location string, target Importer) {
+	importer.ownersMu.Lock()
+	owner, owned := importer.owners[foundAt]
+	importer.ownersMu.Unlock()
+	if owned {
+		return owner.prefix, foundAt, owner.target
+	}
+	return importer.match(foundAt)
+}
+
+// location returns the location of a file imported by the importer of
+// the prefix.
+func (importer *PrefixImporter) location(prefix string, target Importer, foundAt string) string {
+	if !filepath.IsAbs(foundAt) {
+		return prefix + "/" + foundAt
+	}
+	importer.ownersMu.Lock()
+	defer importer.ownersMu.Unlock()
+	if importer.owners == nil {
+		importer.owners = make(map[string]prefixOwner)
+	}
+	importer.owners[foundAt] = prefixOwner{prefix: prefix, target: target}
+	return foundAt
+}
+
+// mergeNotFound adds the locations probed by the importer of the prefix
+// to notFound, like the package-level mergeNotFound.
+func (importer *PrefixImporter) mergeNotFound(notFound *ImportNotFoundError, err error, prefix string) bool {
+	var other *ImportNotFoundError
+	if !errors.As(err, &other) {
+		return false
+	}
+	for _, probed := range other.Probed {
+		if filepath.IsAbs(probed) {
+			notFound.Probed = append(notFound.Probed, probed)
+		} else {
+			notFound.Probed = append(notFound.Probed, prefix+"/"+probed)
+		}
+	}
+	return true
+}
+
+// match finds the longest prefix of the path and the rest of the path.
+func (importer *PrefixImporter) match(p string) (prefix string, rest string, target Importer) {
+	for candidate, candidateTarget := range importer.Prefixes {
+		candidate = strings.Trim(candidate, "/")
+		if strings.HasPrefix(p, candidate+"/") && (target == nil || len(candidate) > len(prefix)) {
+			prefix, rest, target = candidate, strings.TrimPrefix(p, candidate+"/"), candidateTarget
+		}
+	}
+	return prefix, rest, target
+}
+
+// Import imports data with the importer of the matching prefix.
+func (importer *PrefixImporter) Import(importedFrom, importedPath string) (contents Contents, foundAt string, err error) {
+	return importer.ImportContext(context.Background(), importedFrom, importedPath)
+}
+
+// ImportContext imports data with the importer of the matching prefix,
+// passing ctx to it if it is a ContextImporter.
+func (importer *PrefixImporter) ImportContext(ctx context.Context, importedFrom, importedPath string) (contents Contents, foundAt string, err error) {
+	notFound := &ImportNotFoundError{ImportedPath: importedPath}
+	if prefix, from, target := importer.owner(importedFrom); target != nil {
+		contents, foundAt, err := importWithContext(ctx, target, from, importedPath)
+		if err == nil {
+			return contents, importer.location(prefix, target, foundAt), nil
+		}
+		if !importer.mergeNotFound(notFound, err, prefix) {
+			return Contents{}, "", err
+		}
+	}
+	if prefix, rest, target := importer.match(importedPath); target != nil {
+		contents, foundAt, err := importWithContext(ctx, target, "", rest)
+		if err == nil {
+			return contents, importer.location(prefix, target, foundAt), nil
+		}
+		if !importer.mergeNotFound(notFound, err, prefix) {
+			return Contents{}, "", err
+		}
+	}
+	return Contents{}, "", notFound
+}
+
+// Invalidate invalidates the caches of the importers of the prefixes which
+// are InvalidatingImporters, with the locations of their files.
+func (importer *PrefixImporter) Invalidate(foundAts ...string) {
+	for prefix, target := range importer.Prefixes {
+		target, ok := target.(InvalidatingImporter)
+		if !ok {
+			continue
+		}
+		var inner []string
+		for _, foundAt := range foundAts {
+			if p, location, _ := importer.owner(foundAt); p == strings.Trim(prefix, "/") {
+				inner = append(inner, location)
+			}
+		}
+		target.Invalidate(inner...)
+	}
 }
@@ -0,0 +1,495 @@
+/*
+Copyright 2026 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package astcodec provides a binary serialization of ASTs, used for
+// persistent caching of parsed and desugared files.
+//
+// The encoding is driven by reflection. It preserves the sharing of pointers
+// (in particular all nodes of a file point to the same ast.Source), so
+// the size of the result is proportional to the size of the AST.
+// The encoding is not stable between versions; the users must make
+// sure that the data is decoded by the same version which encoded it.
+package astcodec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"unsafe"
+
+	"github.com/google/go-jsonnet/ast"
+)
+
+// Version identifies the format of the encoding. It changes whenever
+// the format changes in an incompatible way.
+const Version = "1"
+
+var nodeTypes = []ast.Node{
+	&ast.Apply{},
+	&ast.ApplyBrace{},
+	&ast.Array{},
+	&ast.ArrayComp{},
+	&ast.Assert{},
+	&ast.Binary{},
+	&ast.Conditional{},
+	&ast.DesugaredObject{},
+	&ast.Dollar{},
+	&ast.Error{},
+	&ast.Function{},
+	&ast.Import{},
+	&ast.ImportBin{},
+	&ast.ImportStr{},
+	&ast.InSuper{},
+	&ast.Index{},
+	&ast.LiteralBoolean{},
+	&ast.LiteralNull{},
+	&ast.LiteralNumber{},
+	&ast.LiteralString{},
+	&ast.Local{},
+	&ast.Object{},
+	&ast.ObjectComp{},
+	&ast.Parens{},
+	&ast.Self{},
+	&ast.Slice{},
+	&ast.SuperIndex{},
+	&ast.Unary{},
+	&ast.Var{},
+}
+
+var (
+	nodeInterface = reflect.TypeOf((*ast.Node)(nil)).Elem()
+	nodeTypeIDs   = make(map[reflect.Type]uint64, len(nodeTypes))
+)
+
+func init() {
+	for i, node := range nodeTypes {
+		nodeTypeIDs[reflect.TypeOf(node)] = uint64(i)
+	}
+}
+
+// Pointers are encoded as one of:
+// - ptrNil for a nil pointer,
+// - ptrNew followed by the pointed value, when the pointer is seen for the first time,
+// - ptrRef+n, a reference to n-th pointer seen before.
+const (
+	ptrNil = iota
+	ptrNew
+	ptrRef
+)
+
+// pointerKey identifies a pointer. The type of the pointed value is a part
+// of the key, because a pointer to a struct and a pointer to its first field
+// are equal.
+type pointerKey struct {
+	t reflect.Type
+	p uintptr
+}
+
+// Strings are encoded as strNew followed by the length and the content,
+// when the string is seen for the first time, or as strRef+n, a reference
+// to n-th string seen before. Most strings, like file names and
+// identifiers, are repeated many times.
+const (
+	strNew = iota
+	strRef
+)
+
+type encoder struct {
+	buf      bytes.Buffer
+	scratch  [binary.MaxVarintLen64]byte
+	pointers map[pointerKey]uint64
+	strings  map[string]uint64
+}
+
+// Encode serializes an AST.
+func Encode(node ast.Node) ([]byte, error) {
+	e := &encoder{
+		pointers: make(map[pointerKey]uint64),
+		strings:  make(map[string]uint64),
+	}
+	if err := e.encode(reflect.ValueOf(&node).Elem()); err != nil {
+		return nil, err
+	}
+	return e.buf.Bytes(), nil
+}
+
+func (e *encoder) uvarint(x uint64) {
+	n := binary.PutUvarint(e.scratch[:], x)
+	e.buf.Write(e.scratch[:n])
+}
+
+func (e *encoder) varint(x int64) {
+	n := binary.PutVarint(e.scratch[:], x)
+	e.buf.Write(e.scratch[:n])
+}
+
+func (e *encoder) encode(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			e.uvarint(1)
+		} else {
+			e.uvarint(0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.varint(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		e.uvarint(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		e.uvarint(math.Float64bits(v.Float()))
+	case reflect.String:
+		str := v.String()
+		if id, seen := e.strings[str]; seen {
+			e.uvarint(strRef + id)
+			return nil
+		}
+		e.strings[str] = uint64(len(e.strings))
+		e.uvarint(strNew)
+		e.uvarint(uint64(len(str)))
+		e.buf.WriteString(str)
+	case reflect.Slice:
+		// Nil and empty slices are distinguished.
+		if v.IsNil() {
+			e.uvarint(0)
+			return nil
+		}
+		e.uvarint(uint64(v.Len()) + 1)
+		for i := 0; i < v.Len(); i++ {
+			if err := e.encode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := e.encode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			if t.Field(i).PkgPath != "" {
+				return fmt.Errorf("cannot encode unexported field %s of %v", t.Field(i).Name, t)
+			}
+			if err := e.encode(v.Field(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			e.uvarint(ptrNil)
+			return nil
+		}
+		key := pointerKey{t: v.Type().Elem(), p: v.Pointer()}
+		if id, seen := e.pointers[key]; seen {
+			e.uvarint(ptrRef + id)
+			return nil
+		}
+		e.pointers[key] = uint64(len(e.pointers))
+		e.uvarint(ptrNew)
+		return e.encode(v.Elem())
+	case reflect.Interface:
+		if v.Type() != nodeInterface {
+			return fmt.Errorf("cannot encode interface %v", v.Type())
+		}
+		if v.IsNil() {
+			e.uvarint(0)
+			return nil
+		}
+		id, known := nodeTypeIDs[v.Elem().Type()]
+		if !known {
+			return fmt.Errorf("cannot encode node of type %v", v.Elem().Type())
+		}
+		e.uvarint(id + 1)
+		return e.encode(v.Elem())
+	default:
+		return fmt.Errorf("cannot encode value of type %v", v.Type())
+	}
+	return nil
+}
+
+type decoder struct {
+	r            *bytes.Reader
+	pointers     []unsafe.Pointer
+	pointerTypes []reflect.Type
+	strings      []string
+}
+
+// decodeFunc decodes a value of some type and stores it at p.
+//
+// The decoding is called for every file loaded from a cache, so it must be
+// much faster than parsing. That's why the decoding functions are prepared
+// upfront for every type and they write the values directly to memory,
+// instead of going through reflect.Value for every decoded value.
+type decodeFunc func(d *decoder, p unsafe.Pointer) error
+
+// decoders holds the decoding functions of all types reachable from the nodes.
+// They are stored indirectly, to handle recursive types.
+var decoders = make(map[reflect.Type]*decodeFunc)
+
+func init() {
+	for _, node := range nodeTypes {
+		decoderFor(reflect.TypeOf(node))
+	}
+}
+
+// Decode deserializes an AST encoded by Encode.
+func Decode(data []byte) (node ast.Node, err error) {
+	d := &decoder{r: bytes.NewReader(data)}
+	if err := d.decodeNode(unsafe.Pointer(&node)); err != nil {
+		return nil, err
+	}
+	if d.r.Len() != 0 {
+		return nil, fmt.Errorf("%d bytes of trailing data", d.r.Len())
+	}
+	return node, nil
+}
+
+func (d *decoder) uvarint() (uint64, error) {
+	return binary.ReadUvarint(d.r)
+}
+
+func (d *decoder) length() (int, error) {
+	n, err := d.uvarint()
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(d.r.Len()) {
+		return 0, fmt.Errorf("invalid length %d", n)
+	}
+	return int(n), nil
+}
+
+func (d *decoder) decodeString(p unsafe.Pointer) error {
+	tag, err := d.uvarint()
+	if err != nil {
+		return err
+	}
+	if tag != strNew {
+		id := tag - strRef
+		if id >= uint64(len(d.strings)) {
+			return fmt.Errorf("invalid string reference %d", id)
+		}
+		*(*string)(p) = d.strings[id]
+		return nil
+	}
+	n, err := d.length()
+	if err != nil {
+		return err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		return err
+	}
+	str := string(b)
+	d.strings = append(d.strings, str)
+	*(*string)(p) = str
+	return nil
+}
+
+// decodePointer decodes a pointer to a value of type elem and stores it at p.
+func (d *decoder) decodePointer(p unsafe.Pointer, elem reflect.Type, decodeElem *decodeFunc) error {
+	tag, err := d.uvarint()
+	if err != nil {
+		return err
+	}
+	switch tag {
+	case ptrNil:
+		*(*unsafe.Pointer)(p) = nil
+		return nil
+	case ptrNew:
+		ptr := reflect.New(elem).UnsafePointer()
+		d.pointers = append(d.pointers, ptr)
+		d.pointerTypes = append(d.pointerTypes, elem)
+		*(*unsafe.Pointer)(p) = ptr
+		return (*decodeElem)(d, ptr)
+	default:
+		id := tag - ptrRef
+		if id >= uint64(len(d.pointers)) || d.pointerTypes[id] != elem {
+			return fmt.Errorf("invalid pointer reference %d", id)
+		}
+		*(*unsafe.Pointer)(p) = d.pointers[id]
+		return nil
+	}
+}
+
+// decodeNode decodes an ast.Node and stores it at p.
+func (d *decoder) decodeNode(p unsafe.Pointer) error {
+	id, err := d.uvarint()
+	if err != nil {
+		return err
+	}
+	if id == 0 {
+		*(*ast.Node)(p) = nil
+		return nil
+	}
+	if id > uint64(len(nodeTypes)) {
+		return fmt.Errorf("invalid node type %d", id)
+	}
+	elem := reflect.TypeOf(nodeTypes[id-1]).Elem()
+	var ptr unsafe.Pointer
+	if err := d.decodePointer(unsafe.Pointer(&ptr), elem, decoders[elem]); err != nil {
+		return err
+	}
+	if ptr == nil {
+		*(*ast.Node)(p) = nil
+		return nil
+	}
+	*(*ast.Node)(p) = reflect.NewAt(elem, ptr).Interface().(ast.Node)
+	return nil
+}
+
+func decoderFor(t reflect.Type) *decodeFunc {
+	if f, exists := decoders[t]; exists {
+		return f
+	}
+	f := new(decodeFunc)
+	decoders[t] = f
+	switch t.Kind() {
+	case reflect.Bool:
+		*f = func(d *decoder, p unsafe.Pointer) error {
+			x, err := d.uvarint()
+			*(*bool)(p) = x != 0
+			return err
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		store := intStore(t.Kind())
+		*f = func(d *decoder, p unsafe.Pointer) error {
+			x, err := binary.ReadVarint(d.r)
+			store(p, x)
+			return err
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		store := uintStore(t.Kind())
+		*f = func(d *decoder, p unsafe.Pointer) error {
+			x, err := d.uvarint()
+			store(p, x)
+			return err
+		}
+	case reflect.Float32:
+		*f = func(d *decoder, p unsafe.Pointer) error {
+			x, err := d.uvarint()
+			*(*float32)(p) = float32(math.Float64frombits(x))
+			return err
+		}
+	case reflect.Float64:
+		*f = func(d *decoder, p unsafe.Pointer) error {
+			x, err := d.uvarint()
+			*(*float64)(p) = math.Float64frombits(x)
+			return err
+		}
+	case reflect.String:
+		*f = (*decoder).decodeString
+	case reflect.Slice:
+		elem := t.Elem()
+		decodeElem := decoderFor(elem)
+		*f = func(d *decoder, p unsafe.Pointer) error {
+			n, err := d.length()
+			if err != nil || n == 0 {
+				return err
+			}
+			s := reflect.MakeSlice(t, n-1, n-1)
+			base := s.UnsafePointer()
+			for i := 0; i < n-1; i++ {
+				if err := (*decodeElem)(d, unsafe.Add(base, uintptr(i)*elem.Size())); err != nil {
+					return err
+				}
+			}
+			*(*sliceHeader)(p) = sliceHeader{data: base, len: n - 1, cap: n - 1}
+			return nil
+		}
+	case reflect.Array:
+		elem := t.Elem()
+		decodeElem := decoderFor(elem)
+		*f = func(d *decoder, p unsafe.Pointer) error {
+			for i := 0; i < t.Len(); i++ {
+				if err := (*decodeElem)(d, unsafe.Add(p, uintptr(i)*elem.Size())); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case reflect.Struct:
+		offsets := make([]uintptr, t.NumField())
+		fields := make([]*decodeFunc, t.NumField())
+		for i := range fields {
+			offsets[i] = t.Field(i).Offset
+			fields[i] = decoderFor(t.Field(i).Type)
+		}
+		*f = func(d *decoder, p unsafe.Pointer) error {
+			for i, decodeField := range fields {
+				if err := (*decodeField)(d, unsafe.Add(p, offsets[i])); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case reflect.Ptr:
+		elem := t.Elem()
+		decodeElem := decoderFor(elem)
+		*f = func(d *decoder, p unsafe.Pointer) error {
+			return d.decodePointer(p, elem, decodeElem)
+		}
+	case reflect.Interface:
+		if t != nodeInterface {
+			panic(fmt.Sprintf("astcodec: cannot decode interface %v", t))
+		}
+		*f = (*decoder).decodeNode
+	default:
+		panic(fmt.Sprintf("astcodec: cannot decode value of type %v", t))
+	}
+	return f
+}
+
+// sliceHeader is the runtime representation of a slice.
+type sliceHeader struct {
+	data unsafe.Pointer
+	len  int
+	cap  int
+}
+
+func intStore(kind reflect.Kind) func(p unsafe.Pointer, x int64) {
+	switch kind {
+	case reflect.Int:
+		return func(p unsafe.Pointer, x int64) { *(*int)(p) = int(x) }
+	case reflect.Int8:
+		return func(p unsafe.Pointer, x int64) { *(*int8)(p) = int8(x) }
+	case reflect.Int16:
+		return func(p unsafe.Pointer, x int64) { *(*int16)(p) = int16(x) }
+	case reflect.Int32:
+		return func(p unsafe.Pointer, x int64) { *(*int32)(p) = int32(x) }
+	default:
+		return func(p unsafe.Pointer, x int64) { *(*int64)(p) = x }
+	}
+}
+
+func uintStore(kind reflect.Kind) func(p unsafe.Pointer, x uint64) {
+	switch kind {
+	case reflect.Uint:
+		return func(p unsafe.Pointer, x uint64) { *(*uint)(p) = uint(x) }
+	case reflect.Uint8:
+		return func(p unsafe.Pointer, x uint64) { *(*uint8)(p) = uint8(x) }
+	case reflect.Uint16:
+		return func(p unsafe.Pointer, x uint64) { *(*uint16)(p) = uint16(x) }
+	case reflect.Uint32:
+		return func(p unsafe.Pointer, x uint64) { *(*uint32)(p) = uint32(x) }
+	default:
+		return func(p unsafe.Pointer, x uint64) { *(*uint64)(p) = x }
+	}
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2026 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package astcodec
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/internal/program"
+)
+
+func TestRoundTrip(t *testing.T) {
+	files, err := filepath.Glob("../../testdata/*.jsonnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("No test files found")
+	}
+	for _, file := range files {
+		code, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		node, err := program.SnippetToAST(ast.DiagnosticFileName(file), file, string(code))
+		if err != nil {
+			// Some of the test files are supposed to have static errors.
+			continue
+		}
+		data, err := Encode(node)
+		if err != nil {
+			t.Errorf("%s: unexpected error when encoding: %v", file, err)
+			continue
+		}
+		decoded, err := Decode(data)
+		if err != nil {
+			t.Errorf("%s: unexpected error when decoding: %v", file, err)
+			continue
+		}
+		if !reflect.DeepEqual(node, decoded) {
+			t.Errorf("%s: the decoded AST is different from the original one", file)
+		}
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	node, err := program.SnippetToAST("test.jsonnet", "", `{ x: [1, 2, "foo"], local y = self.x, z: y }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := Encode(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n < len(data); n++ {
+		if _, err := Decode(data[:n]); err == nil {
+			t.Errorf("Expected an error when decoding the first %d of %d bytes", n, len(data))
+		}
+	}
+	if _, err := Decode(append(data, 0)); err == nil {
+		t.Errorf("Expected an error when decoding data with a trailing byte")
+	}
+}
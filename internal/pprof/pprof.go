@@ -0,0 +1,280 @@
+/*
+Copyright 2026 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package pprof writes profiles in the format read by the pprof tool,
+// i.e. gzip-compressed protocol buffers described by
+// https://github.com/google/pprof/blob/main/proto/profile.proto.
+//
+// Only the parts of the format needed to describe the stacks of
+// an interpreted language (no mappings or addresses) are supported.
+package pprof
+
+import (
+	"compress/gzip"
+	"io"
+)
+
+// ValueType describes a kind of the values of the samples, e.g. "cpu" in "nanoseconds".
+type ValueType struct {
+	Type string
+	Unit string
+}
+
+// Frame is a single entry of the stack of a sample.
+type Frame struct {
+	Function string
+	File     string
+	Line     int64
+	Column   int64
+}
+
+// Sample is a stack with the values measured for it.
+type Sample struct {
+	// Stack starts with the innermost frame.
+	Stack []Frame
+	// Values correspond to Profile.SampleTypes.
+	Values []int64
+}
+
+// Profile is a set of samples.
+type Profile struct {
+	SampleTypes []ValueType
+	// DefaultSampleType is the type of the values shown by default.
+	DefaultSampleType string
+	Samples           []Sample
+
+	PeriodType ValueType
+	Period     int64
+
+	TimeNanos     int64
+	DurationNanos int64
+}
+
+// Field numbers of profile.proto.
+const (
+	profileSampleType        = 1
+	profileSample            = 2
+	profileLocation          = 4
+	profileFunction          = 5
+	profileStringTable       = 6
+	profileTimeNanos         = 9
+	profileDurationNanos     = 10
+	profilePeriodType        = 11
+	profilePeriod            = 12
+	profileDefaultSampleType = 14
+
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	sampleLocationID = 1
+	sampleValue      = 2
+
+	locationID   = 1
+	locationLine = 4
+
+	lineFunctionID = 1
+	lineLine       = 2
+	lineColumn     = 3
+
+	functionID         = 1
+	functionName       = 2
+	functionSystemName = 3
+	functionFilename   = 4
+)
+
+// encoder builds the message of the profile. The functions, locations and
+// strings are deduplicated, as the format expects.
+type encoder struct {
+	buf       buffer
+	strings   map[string]int64
+	functions map[functionKey]uint64
+	locations map[Frame]uint64
+
+	stringTable []string
+	// The encoded messages of the functions and locations.
+	functionMsgs []buffer
+	locationMsgs []buffer
+}
+
+type functionKey struct {
+	name string
+	file string
+}
+
+func (e *encoder) str(s string) int64 {
+	if index, exists := e.strings[s]; exists {
+		return index
+	}
+	index := int64(len(e.stringTable))
+	e.strings[s] = index
+	e.stringTable = append(e.stringTable, s)
+	return index
+}
+
+func (e *encoder) function(frame Frame) uint64 {
+	key := functionKey{name: frame.Function, file: frame.File}
+	if id, exists := e.functions[key]; exists {
+		return id
+	}
+	id := uint64(len(e.functionMsgs) + 1)
+	e.functions[key] = id
+	var msg buffer
+	msg.uint64(functionID, id)
+	msg.int64(functionName, e.str(frame.Function))
+	msg.int64(functionSystemName, e.str(frame.Function))
+	msg.int64(functionFilename, e.str(frame.File))
+	e.functionMsgs = append(e.functionMsgs, msg)
+	return id
+}
+
+func (e *encoder) location(frame Frame) uint64 {
+	if id, exists := e.locations[frame]; exists {
+		return id
+	}
+	id := uint64(len(e.locationMsgs) + 1)
+	e.locations[frame] = id
+	var line buffer
+	line.uint64(lineFunctionID, e.function(frame))
+	line.int64(lineLine, frame.Line)
+	line.int64(lineColumn, frame.Column)
+	var msg buffer
+	msg.uint64(locationID, id)
+	msg.message(locationLine, line)
+	e.locationMsgs = append(e.locationMsgs, msg)
+	return id
+}
+
+func (e *encoder) valueType(field int, vt ValueType) {
+	var msg buffer
+	msg.int64(valueTypeType, e.str(vt.Type))
+	msg.int64(valueTypeUnit, e.str(vt.Unit))
+	e.buf.message(field, msg)
+}
+
+// Write writes the profile to w.
+func (p *Profile) Write(w io.Writer) error {
+	e := &encoder{
+		strings:   make(map[string]int64),
+		functions: make(map[functionKey]uint64),
+		locations: make(map[Frame]uint64),
+	}
+	// The first string must be empty.
+	e.str("")
+
+	for _, st := range p.SampleTypes {
+		e.valueType(profileSampleType, st)
+	}
+	for _, sample := range p.Samples {
+		ids := make([]uint64, len(sample.Stack))
+		for index, frame := range sample.Stack {
+			ids[index] = e.location(frame)
+		}
+		var msg buffer
+		msg.packedUint64(sampleLocationID, ids)
+		msg.packedInt64(sampleValue, sample.Values)
+		e.buf.message(profileSample, msg)
+	}
+	for _, msg := range e.locationMsgs {
+		e.buf.message(profileLocation, msg)
+	}
+	for _, msg := range e.functionMsgs {
+		e.buf.message(profileFunction, msg)
+	}
+	e.buf.int64(profileTimeNanos, p.TimeNanos)
+	e.buf.int64(profileDurationNanos, p.DurationNanos)
+	e.valueType(profilePeriodType, p.PeriodType)
+	e.buf.int64(profilePeriod, p.Period)
+	if p.DefaultSampleType != "" {
+		e.buf.int64(profileDefaultSampleType, e.str(p.DefaultSampleType))
+	}
+	// The string table goes last, when all the strings are known.
+	for _, s := range e.stringTable {
+		e.buf.string(profileStringTable, s)
+	}
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(e.buf); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// buffer is an encoded protocol buffer message.
+type buffer []byte
+
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+func (b *buffer) varint(x uint64) {
+	for x >= 0x80 {
+		*b = append(*b, byte(x)|0x80)
+		x >>= 7
+	}
+	*b = append(*b, byte(x))
+}
+
+func (b *buffer) key(field int, wireType int) {
+	b.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+// uint64 writes a field, omitting it if it has the default value.
+func (b *buffer) uint64(field int, x uint64) {
+	if x == 0 {
+		return
+	}
+	b.key(field, wireVarint)
+	b.varint(x)
+}
+
+// int64 writes a field, omitting it if it has the default value.
+func (b *buffer) int64(field int, x int64) {
+	b.uint64(field, uint64(x))
+}
+
+func (b *buffer) bytes(field int, data []byte) {
+	b.key(field, wireBytes)
+	b.varint(uint64(len(data)))
+	*b = append(*b, data...)
+}
+
+// string writes a field, also if it is empty (as the string table needs).
+func (b *buffer) string(field int, s string) {
+	b.key(field, wireBytes)
+	b.varint(uint64(len(s)))
+	*b = append(*b, s...)
+}
+
+func (b *buffer) message(field int, msg buffer) {
+	b.bytes(field, msg)
+}
+
+func (b *buffer) packedUint64(field int, xs []uint64) {
+	var packed buffer
+	for _, x := range xs {
+		packed.varint(x)
+	}
+	b.bytes(field, packed)
+}
+
+func (b *buffer) packedInt64(field int, xs []int64) {
+	var packed buffer
+	for _, x := range xs {
+		packed.varint(uint64(x))
+	}
+	b.bytes(field, packed)
+}
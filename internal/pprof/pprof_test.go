@@ -0,0 +1,140 @@
+/*
+Copyright 2026 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pprof
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// field is a decoded field of a protocol buffer message.
+type field struct {
+	number int
+	varint uint64
+	bytes  []byte
+}
+
+func decodeMessage(t *testing.T, data []byte) []field {
+	t.Helper()
+	var fields []field
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			t.Fatalf("invalid key")
+		}
+		data = data[n:]
+		f := field{number: int(key >> 3)}
+		switch key & 7 {
+		case wireVarint:
+			f.varint, n = binary.Uvarint(data)
+			if n <= 0 {
+				t.Fatalf("invalid varint")
+			}
+			data = data[n:]
+		case wireBytes:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				t.Fatalf("invalid length")
+			}
+			f.bytes = data[n : n+int(length)]
+			data = data[n+int(length):]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+func decodePacked(t *testing.T, data []byte) []uint64 {
+	t.Helper()
+	var result []uint64
+	for len(data) > 0 {
+		x, n := binary.Uvarint(data)
+		if n <= 0 {
+			t.Fatalf("invalid packed varint")
+		}
+		result = append(result, x)
+		data = data[n:]
+	}
+	return result
+}
+
+func TestWrite(t *testing.T) {
+	f := Frame{Function: "f", File: "a.jsonnet", Line: 3, Column: 5}
+	g := Frame{Function: "g", File: "a.jsonnet", Line: 7, Column: 1}
+	p := &Profile{
+		SampleTypes:       []ValueType{{Type: "time", Unit: "nanoseconds"}},
+		DefaultSampleType: "time",
+		Samples: []Sample{
+			{Stack: []Frame{f, g}, Values: []int64{10}},
+			{Stack: []Frame{g}, Values: []int64{20}},
+		},
+		PeriodType: ValueType{Type: "time", Unit: "nanoseconds"},
+		Period:     1000,
+	}
+	var buf bytes.Buffer
+	if err := p.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var strings []string
+	var samples, locations, functions [][]field
+	for _, f := range decodeMessage(t, data) {
+		switch f.number {
+		case profileStringTable:
+			strings = append(strings, string(f.bytes))
+		case profileSample:
+			samples = append(samples, decodeMessage(t, f.bytes))
+		case profileLocation:
+			locations = append(locations, decodeMessage(t, f.bytes))
+		case profileFunction:
+			functions = append(functions, decodeMessage(t, f.bytes))
+		}
+	}
+	if len(strings) == 0 || strings[0] != "" {
+		t.Fatalf("The string table must start with an empty string, got %q", strings)
+	}
+	// The frames are deduplicated.
+	if len(samples) != 2 || len(locations) != 2 || len(functions) != 2 {
+		t.Fatalf("Expected 2 samples, locations and functions, got %d, %d and %d", len(samples), len(locations), len(functions))
+	}
+	if ids := decodePacked(t, samples[0][0].bytes); !reflect.DeepEqual(ids, []uint64{1, 2}) {
+		t.Errorf("Expected locations [1 2], got %v", ids)
+	}
+	if ids := decodePacked(t, samples[1][0].bytes); !reflect.DeepEqual(ids, []uint64{2}) {
+		t.Errorf("Expected locations [2], got %v", ids)
+	}
+	if values := decodePacked(t, samples[1][1].bytes); !reflect.DeepEqual(values, []uint64{20}) {
+		t.Errorf("Expected values [20], got %v", values)
+	}
+	if name := strings[functions[1][1].varint]; name != "g" {
+		t.Errorf("Expected function g, got %q", name)
+	}
+}
@@ -18,12 +18,18 @@ package jsonnet
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"math"
 	"reflect"
+	"runtime/debug"
 	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/google/go-jsonnet/ast"
 	"github.com/google/go-jsonnet/astgen"
@@ -253,6 +259,8 @@ func makeCallStack(limit int) callStack {
 	}
 }
 
+// EvalHook is called before and after evaluating every node, either
+// function may be nil.
 type EvalHook struct {
 	pre  func(i *interpreter, n ast.Node)
 	post func(i *interpreter, n ast.Node, v value, err error)
@@ -263,17 +271,25 @@ type interpreter struct {
 	// Output stream for trace() for
 	traceOut io.Writer
 
+	// Set instead of writing the output of std.trace in the parallel
+	// multi mode, which then starts over sequentially. Nil otherwise.
+	traceCalled *int32
+
 	// External variables
 	extVars map[string]*cachedThunk
 
 	// Native functions
-	nativeFuncs map[string]*NativeFunction
+	nativeFuncs map[string]evalCallable
 
 	// A part of std object common to all files
 	baseStd *valueObject
 
 	// Keeps imports
-	importCache *importCache
+	importCache *ImportCache
+
+	// Values of the imported files, by foundAt. They are cached per
+	// evaluation, because they are mutated as they are evaluated.
+	codeCache map[string]potentialValue
 
 	// Current stack. It is used for:
 	// 1) Keeping environment (object we're in, variables)
@@ -281,8 +297,51 @@ type interpreter struct {
 	stack callStack
 
 	evalHook EvalHook
+
+	// Restrictions of the evaluated code, nil if there are none.
+	sandbox *sandbox
+
+	// Formats the errors returned by the methods of the Values of
+	// the evaluation, nil if they are not formatted.
+	errorFormatter ErrorFormatter
+
+	// Context of the evaluation. It is checked periodically, so that
+	// the evaluation can be aborted when the context is cancelled.
+	ctx context.Context
+
+	// Number of context checks skipped since the last actual one.
+	ctxSkippedChecks int
+
+	// Resource limits of the evaluation and the usage so far.
+	limits    evalLimits
+	steps     int
+	allocated int
+
+	// Profiler of the evaluation (or nil) and the state of the sampling:
+	// the time of the last time sample, the number of clock checks skipped
+	// since the last actual one and the bytes allocated since the last
+	// allocation sample.
+	profiler             *Profiler
+	profileLastSample    time.Time
+	profileSkippedChecks int
+	profileAllocated     int
+}
+
+// evalLimits are the resource limits of a single evaluation.
+// Zero means that there is no limit. See the corresponding fields of VM.
+type evalLimits struct {
+	maxSteps      int
+	maxMemory     int
+	maxOutputSize int
 }
 
+// Approximate sizes (in bytes) used for accounting against evalLimits.maxMemory.
+const (
+	stringCharSize     = 4  // a rune
+	arrayElementSize   = 8  // a pointer to a thunk
+	stringTreeNodeSize = 48 // a node of a concatenated string
+)
+
 // Map union, b takes precedence when keys collide.
 func addBindings(a, b bindingFrame) bindingFrame {
 	result := make(bindingFrame, len(a))
@@ -307,10 +366,135 @@ func (i *interpreter) newCall(env environment, trimmable bool) error {
 	return nil
 }
 
+// ctxCheckInterval is how often (in evaluation steps) the interpreter
+// looks at its context. Checking on every step would be needlessly expensive.
+const ctxCheckInterval = 1000
+
+// checkContext returns an error if the context of the evaluation is done.
+// Only every ctxCheckInterval-th call actually looks at the context.
+func (i *interpreter) checkContext() error {
+	i.ctxSkippedChecks++
+	if i.ctxSkippedChecks < ctxCheckInterval {
+		return nil
+	}
+	i.ctxSkippedChecks = 0
+	select {
+	case <-i.ctx.Done():
+		return makeCancellationError(i.ctx.Err(), i.getCurrentStackTrace())
+	default:
+		return nil
+	}
+}
+
+// countStep counts an evaluation step against the step limit.
+func (i *interpreter) countStep() error {
+	i.steps++
+	if i.limits.maxSteps > 0 && i.steps > i.limits.maxSteps {
+		return makeLimitError("MaxSteps", i.limits.maxSteps, i.getCurrentStackTrace())
+	}
+	return nil
+}
+
+// allocate counts size bytes against the memory limit.
+func (i *interpreter) allocate(size int) error {
+	i.allocated += size
+	if i.profiler != nil {
+		i.profiler.sampleAllocation(i, size)
+	}
+	if i.limits.maxMemory > 0 && i.allocated > i.limits.maxMemory {
+		return makeLimitError("MaxMemory", i.limits.maxMemory, i.getCurrentStackTrace())
+	}
+	return nil
+}
+
+// allocateString counts a new string of the given length against the memory limit.
+func (i *interpreter) allocateString(length int) error {
+	return i.allocate(length * stringCharSize)
+}
+
+// allocateArray counts a new array of the given length against the memory limit.
+func (i *interpreter) allocateArray(length int) error {
+	return i.allocate(length * arrayElementSize)
+}
+
+// checkAllocation checks that size more bytes can be allocated, without
+// counting them, before building a value which is counted later, e.g. by
+// allocateResult.
+func (i *interpreter) checkAllocation(size int) error {
+	if i.limits.maxMemory > 0 && i.allocated+size > i.limits.maxMemory {
+		return makeLimitError("MaxMemory", i.limits.maxMemory, i.getCurrentStackTrace())
+	}
+	return nil
+}
+
+// allocateResult counts the string or the array returned by a builtin or
+// a native function against the memory limit, unless it is one of the
+// arguments. Only the string or the array itself is counted, the values in
+// the array are counted when they are created.
+func (i *interpreter) allocateResult(result value, args ...value) (value, error) {
+	for _, arg := range args {
+		if arg == result {
+			return result, nil
+		}
+	}
+	var err error
+	switch result := result.(type) {
+	case *valueFlatString:
+		err = i.allocateString(result.length())
+	case *valueStringTree:
+		err = i.allocate(stringTreeNodeSize)
+	case *valueArray:
+		err = i.allocateArray(result.length())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// concatStrings concatenates the strings and counts what the concatenation
+// allocates against the memory limit: the short strings are copied, but
+// the long ones are only referenced by a new node, and copied only when
+// the characters are needed (see valueStringTree.flattenToLeft). A string
+// which could not be flattened within the limit is not created at all.
+func (i *interpreter) concatStrings(a, b valueString) (valueString, error) {
+	if err := i.checkAllocation((a.length() + b.length()) * stringCharSize); err != nil {
+		return nil, err
+	}
+	result := concatStrings(a, b)
+	switch result := result.(type) {
+	case *valueStringTree:
+		result.i = i
+		err := i.allocate(stringTreeNodeSize)
+		return result, err
+	case *valueFlatString:
+		if result != a && result != b {
+			err := i.allocateString(result.length())
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// checkOutputSize checks the size of the manifested output against the output limit.
+func (i *interpreter) checkOutputSize(size int) error {
+	if i.limits.maxOutputSize > 0 && size > i.limits.maxOutputSize {
+		return makeLimitError("MaxOutputSize", i.limits.maxOutputSize, i.getCurrentStackTrace())
+	}
+	return nil
+}
+
 func (i *interpreter) evaluate(a ast.Node, tc tailCallStatus) (value, error) {
-	i.evalHook.pre(i, a)
+	if i.profiler != nil {
+		i.profiler.sampleTime(i, a)
+	}
+	if i.evalHook.pre != nil {
+		i.evalHook.pre(i, a)
+	}
 	v, err := i.rawevaluate(a, tc)
-	i.evalHook.post(i, a, v, err)
+	if i.evalHook.post != nil {
+		i.evalHook.post(i, a, v, err)
+	}
 	return v, err
 }
 
@@ -324,8 +508,18 @@ func (i *interpreter) rawevaluate(a ast.Node, tc tailCallStatus) (value, error)
 	i.stack.setCurrentTrace(trace)
 	defer func() { i.stack.clearCurrentTrace(); i.stack.setCurrentTrace(oldTrace) }()
 
+	if err := i.checkContext(); err != nil {
+		return nil, err
+	}
+	if err := i.countStep(); err != nil {
+		return nil, err
+	}
+
 	switch node := a.(type) {
 	case *ast.Array:
+		if err := i.allocateArray(len(node.Elements)); err != nil {
+			return nil, err
+		}
 		sb := i.stack.getSelfBinding()
 		var elements []*cachedThunk
 		for _, el := range node.Elements {
@@ -418,6 +612,7 @@ func (i *interpreter) rawevaluate(a ast.Node, tc tailCallStatus) (value, error)
 	case *ast.DesugaredObject:
 		// Evaluate all the field names.  Check for null, dups, etc.
 		fields := make(simpleObjectFieldMap, len(node.Fields))
+		order := make([]string, 0, len(node.Fields))
 		for _, field := range node.Fields {
 			fieldNameValue, err := i.evaluate(field.Name, nonTailCall)
 			if err != nil {
@@ -426,6 +621,9 @@ func (i *interpreter) rawevaluate(a ast.Node, tc tailCallStatus) (value, error)
 			var fieldName string
 			switch fieldNameValue := fieldNameValue.(type) {
 			case valueString:
+				if err := flattenString(fieldNameValue); err != nil {
+					return nil, err
+				}
 				fieldName = fieldNameValue.getGoString()
 			case *valueNull:
 				// Omitted field.
@@ -442,6 +640,7 @@ func (i *interpreter) rawevaluate(a ast.Node, tc tailCallStatus) (value, error)
 				f = &plusSuperUnboundField{f}
 			}
 			fields[fieldName] = simpleObjectField{f, field.Hide}
+			order = append(order, fieldName)
 		}
 		var asserts []unboundField
 		for _, assert := range node.Asserts {
@@ -452,7 +651,7 @@ func (i *interpreter) rawevaluate(a ast.Node, tc tailCallStatus) (value, error)
 			locals = append(locals, objectLocal{name: local.Variable, node: local.Body})
 		}
 		upValues := i.stack.capture(node.FreeVariables())
-		return makeValueSimpleObject(upValues, fields, asserts, locals), nil
+		return makeValueSimpleObject(upValues, fields, order, asserts, locals), nil
 
 	case *ast.Error:
 		msgVal, err := i.evaluate(node.Expr, nonTailCall)
@@ -508,14 +707,23 @@ func (i *interpreter) rawevaluate(a ast.Node, tc tailCallStatus) (value, error)
 		return nil, i.Error(fmt.Sprintf("Value non indexable: %v", reflect.TypeOf(targetValue)))
 
 	case *ast.Import:
+		if err := i.sandbox.checkImportPath("import", node.File.Value); err != nil {
+			return nil, i.Error(err.Error())
+		}
 		codePath := node.Loc().FileName
 		return i.importCache.importCode(codePath, node.File.Value, i)
 
 	case *ast.ImportStr:
+		if err := i.sandbox.checkImportPath("importstr", node.File.Value); err != nil {
+			return nil, i.Error(err.Error())
+		}
 		codePath := node.Loc().FileName
 		return i.importCache.importString(codePath, node.File.Value, i)
 
 	case *ast.ImportBin:
+		if err := i.sandbox.checkImportPath("importbin", node.File.Value); err != nil {
+			return nil, i.Error(err.Error())
+		}
 		codePath := node.Loc().FileName
 		return i.importCache.importBinary(codePath, node.File.Value, i)
 
@@ -680,8 +888,22 @@ func unparseNumber(v float64) string {
 	return fmt.Sprintf("%.17g", v)
 }
 
+// orderedObject is a manifested object whose fields are serialized in
+// the order of their definitions instead of the sorted order
+// (see VM.PreserveOrder).
+type orderedObject struct {
+	fieldNames []string
+	fields     map[string]interface{}
+}
+
 // manifestJSON converts to standard JSON representation as in "encoding/json" package
 func (i *interpreter) manifestJSON(v value) (interface{}, error) {
+	return i.manifestJSONEx(v, false)
+}
+
+// manifestJSONEx is manifestJSON, which manifests the objects as
+// *orderedObject if preserveOrder is set.
+func (i *interpreter) manifestJSONEx(v value, preserveOrder bool) (interface{}, error) {
 	// TODO(sbarzowski) Add nice stack traces indicating the part of the code which
 	// evaluates to non-manifestable value (that might require passing context about
 	// the root value)
@@ -709,6 +931,9 @@ func (i *interpreter) manifestJSON(v value) (interface{}, error) {
 		return v.value, nil
 
 	case valueString:
+		if err := flattenString(v); err != nil {
+			return nil, err
+		}
 		return v.getGoString(), nil
 
 	case *valueNull:
@@ -726,7 +951,7 @@ func (i *interpreter) manifestJSON(v value) (interface{}, error) {
 				i.stack.clearCurrentTrace()
 				return nil, err
 			}
-			elem, err := i.manifestJSON(elVal)
+			elem, err := i.manifestJSONEx(elVal, preserveOrder)
 			if err != nil {
 				i.stack.clearCurrentTrace()
 				return nil, err
@@ -737,42 +962,26 @@ func (i *interpreter) manifestJSON(v value) (interface{}, error) {
 		return result, nil
 
 	case *valueObject:
-		fieldNames := objectFields(v, withoutHidden)
-		sort.Strings(fieldNames)
+		fieldNames := manifestedFields(v, preserveOrder)
 
-		msg := ast.MakeLocationRangeMessage("Checking object assertions")
-		i.stack.setCurrentTrace(traceElement{
-			loc: &msg,
-		})
-		err := checkAssertions(i, v)
+		err := i.checkAssertionsForManifestation(v)
 		if err != nil {
-			i.stack.clearCurrentTrace()
 			return nil, err
 		}
-		i.stack.clearCurrentTrace()
 
 		result := make(map[string]interface{}, len(fieldNames))
 
 		for _, fieldName := range fieldNames {
-			msg := ast.MakeLocationRangeMessage(fmt.Sprintf("Field %#v", fieldName))
-			i.stack.setCurrentTrace(traceElement{
-				loc: &msg,
-			})
-			fieldVal, err := v.index(i, fieldName)
-			if err != nil {
-				i.stack.clearCurrentTrace()
-				return nil, err
-			}
-
-			field, err := i.manifestJSON(fieldVal)
+			field, err := i.manifestField(v, fieldName, preserveOrder)
 			if err != nil {
-				i.stack.clearCurrentTrace()
 				return nil, err
 			}
 			result[fieldName] = field
-			i.stack.clearCurrentTrace()
 		}
 
+		if preserveOrder {
+			return &orderedObject{fieldNames: fieldNames, fields: result}, nil
+		}
 		return result, nil
 
 	default:
@@ -784,7 +993,32 @@ func (i *interpreter) manifestJSON(v value) (interface{}, error) {
 	}
 }
 
-func serializeJSON(v interface{}, multiline bool, indent string, buf *bytes.Buffer) {
+// checkAssertionsForManifestation checks the assertions of an object
+// which is being manifested.
+func (i *interpreter) checkAssertionsForManifestation(v *valueObject) error {
+	msg := ast.MakeLocationRangeMessage("Checking object assertions")
+	i.stack.setCurrentTrace(traceElement{
+		loc: &msg,
+	})
+	defer i.stack.clearCurrentTrace()
+	return checkAssertions(i, v)
+}
+
+// manifestField manifests a single field of an object.
+func (i *interpreter) manifestField(v *valueObject, fieldName string, preserveOrder bool) (interface{}, error) {
+	msg := ast.MakeLocationRangeMessage(fmt.Sprintf("Field %#v", fieldName))
+	i.stack.setCurrentTrace(traceElement{
+		loc: &msg,
+	})
+	defer i.stack.clearCurrentTrace()
+	fieldVal, err := v.index(i, fieldName)
+	if err != nil {
+		return nil, err
+	}
+	return i.manifestJSONEx(fieldVal, preserveOrder)
+}
+
+func serializeJSON(v interface{}, multiline bool, indent string, buf jsonWriter) {
 	switch v := v.(type) {
 	case nil:
 		buf.WriteString("null")
@@ -835,55 +1069,61 @@ func serializeJSON(v interface{}, multiline bool, indent string, buf *bytes.Buff
 			fieldNames = append(fieldNames, name)
 		}
 		sort.Strings(fieldNames)
+		serializeJSONObject(fieldNames, v, multiline, indent, buf)
 
-		if len(fieldNames) == 0 {
-			buf.WriteString("{ }")
-		} else {
-			var prefix string
-			var indent2 string
-			if multiline {
-				prefix = "{\n"
-				indent2 = indent + "   "
-			} else {
-				prefix = "{"
-				indent2 = indent
-			}
-			for _, fieldName := range fieldNames {
-				fieldVal := v[fieldName]
+	case *orderedObject:
+		serializeJSONObject(v.fieldNames, v.fields, multiline, indent, buf)
 
-				buf.WriteString(prefix)
-				buf.WriteString(indent2)
+	case string:
+		buf.WriteString(unparseString(v))
+
+	default:
+		panic(fmt.Sprintf("Unsupported value for serialization %#+v", v))
+	}
+}
 
-				buf.WriteString(unparseString(fieldName))
-				buf.WriteString(": ")
+func serializeJSONObject(fieldNames []string, v map[string]interface{}, multiline bool, indent string, buf jsonWriter) {
+	if len(fieldNames) == 0 {
+		buf.WriteString("{ }")
+	} else {
+		var prefix string
+		var indent2 string
+		if multiline {
+			prefix = "{\n"
+			indent2 = indent + "   "
+		} else {
+			prefix = "{"
+			indent2 = indent
+		}
+		for _, fieldName := range fieldNames {
+			fieldVal := v[fieldName]
 
-				serializeJSON(fieldVal, multiline, indent2, buf)
+			buf.WriteString(prefix)
+			buf.WriteString(indent2)
 
-				if multiline {
-					prefix = ",\n"
-				} else {
-					prefix = ", "
-				}
-			}
+			buf.WriteString(unparseString(fieldName))
+			buf.WriteString(": ")
+
+			serializeJSON(fieldVal, multiline, indent2, buf)
 
 			if multiline {
-				buf.WriteString("\n")
+				prefix = ",\n"
+			} else {
+				prefix = ", "
 			}
-			buf.WriteString(indent)
-			buf.WriteString("}")
 		}
 
-	case string:
-		buf.WriteString(unparseString(v))
-
-	default:
-		panic(fmt.Sprintf("Unsupported value for serialization %#+v", v))
+		if multiline {
+			buf.WriteString("\n")
+		}
+		buf.WriteString(indent)
+		buf.WriteString("}")
 	}
 }
 
 func (i *interpreter) manifestAndSerializeJSON(
-	buf *bytes.Buffer, v value, multiline bool, indent string) error {
-	manifested, err := i.manifestJSON(v)
+	buf jsonWriter, v value, multiline bool, indent string, preserveOrder bool) error {
+	manifested, err := i.manifestJSONEx(v, preserveOrder)
 	if err != nil {
 		return err
 	}
@@ -891,10 +1131,35 @@ func (i *interpreter) manifestAndSerializeJSON(
 	return nil
 }
 
+// manifestAndSerializeInFormat manifests the value in a format other than
+// JSON, terminated with a newline.
+func (i *interpreter) manifestAndSerializeInFormat(buf jsonWriter, v value, format OutputFormat, preserveOrder bool) error {
+	serialized, err := i.manifestInFormat(v, format, preserveOrder)
+	if err != nil {
+		return err
+	}
+	buf.WriteString(withFinalNewline(serialized))
+	return nil
+}
+
+// manifestAndSerializeCanonicalJSON manifests the value and serializes it
+// in the canonical form of JSON (see VM.CanonicalJSON).
+func (i *interpreter) manifestAndSerializeCanonicalJSON(buf jsonWriter, v value) error {
+	manifested, err := i.manifestJSON(v)
+	if err != nil {
+		return err
+	}
+	serializeCanonicalJSON(manifested, buf)
+	return nil
+}
+
 // manifestString expects the value to be a string and returns it.
-func (i *interpreter) manifestString(buf *bytes.Buffer, v value) error {
+func (i *interpreter) manifestString(buf jsonWriter, v value) error {
 	switch v := v.(type) {
 	case valueString:
+		if err := flattenString(v); err != nil {
+			return err
+		}
 		buf.WriteString(v.getGoString())
 		return nil
 	default:
@@ -902,63 +1167,174 @@ func (i *interpreter) manifestString(buf *bytes.Buffer, v value) error {
 	}
 }
 
-func (i *interpreter) manifestAndSerializeMulti(v value, stringOutputMode bool) (r map[string]string, err error) {
-	r = make(map[string]string)
-	json, err := i.manifestJSON(v)
+// manifestAndSerializeMultiFile manifests and serializes the field of
+// the top-level object with a single file in multi mode. used is the size of
+// the output before the file, for the output limit.
+func (i *interpreter) manifestAndSerializeMultiFile(obj *valueObject, filename string, stringOutputMode bool, format outputFormat, used int) (string, error) {
+	msg := ast.MakeLocationRangeMessage(fmt.Sprintf("Field %#v", filename))
+	i.stack.setCurrentTrace(traceElement{
+		loc: &msg,
+	})
+	defer i.stack.clearCurrentTrace()
+	fileVal, err := obj.index(i, filename)
 	if err != nil {
-		return r, err
+		return "", err
 	}
-	switch json := json.(type) {
-	case map[string]interface{}:
-		for filename, fileJSON := range json {
-			if stringOutputMode {
-				switch val := fileJSON.(type) {
-				case string:
-					r[filename] = val
-				default:
-					msg := fmt.Sprintf("multi mode: top-level object's key %s has a value of type %T, "+
-						"should be a string", filename, val)
-					return r, makeRuntimeError(msg, i.getCurrentStackTrace())
-				}
-			} else {
-				var buf bytes.Buffer
-				serializeJSON(fileJSON, true, "", &buf)
-				buf.WriteString("\n")
-				r[filename] = buf.String()
-			}
+	if stringOutputMode {
+		if str, isString := fileVal.(valueString); isString {
+			return str.getGoString(), nil
 		}
-	default:
+		fileJSON, err := i.manifestJSONEx(fileVal, format.preserveOrder)
+		if err != nil {
+			return "", err
+		}
+		msg := fmt.Sprintf("multi mode: top-level object's key %s has a value of type %T, "+
+			"should be a string", filename, fileJSON)
+		return "", makeRuntimeError(msg, i.getCurrentStackTrace())
+	}
+	if fileFormat := format.of(filename); fileFormat != OutputFormatJSON {
+		serialized, err := i.manifestInFormat(fileVal, fileFormat, format.preserveOrder)
+		return withFinalNewline(serialized), err
+	}
+	fileJSON, err := i.manifestJSONEx(fileVal, format.preserveOrder)
+	if err != nil {
+		return "", err
+	}
+	buf := limitedBuffer{limit: i.limits.maxOutputSize, used: used}
+	format.serializeJSON(fileJSON, &buf)
+	if err := i.checkOutputSize(used + buf.size); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// withFinalNewline terminates the serialized text with a newline, unless it
+// already ends with one, like the output of std.manifestIni.
+func withFinalNewline(serialized string) string {
+	if strings.HasSuffix(serialized, "\n") {
+		return serialized
+	}
+	return serialized + "\n"
+}
+
+func (i *interpreter) manifestAndSerializeMulti(v value, stringOutputMode bool, format outputFormat) (r map[string]string, err error) {
+	r = make(map[string]string)
+	obj, isObject := v.(*valueObject)
+	if !isObject {
 		msg := fmt.Sprintf("multi mode: top-level object was a %s, "+
 			"should be an object whose keys are filenames and values hold "+
 			"the JSON for that file.", v.getType().name)
 		return r, makeRuntimeError(msg, i.getCurrentStackTrace())
 	}
+	fieldNames, err := i.multiFileNames(obj)
+	if err != nil {
+		return r, err
+	}
+	stackSize := len(i.stack.stack)
+	defer i.stack.popIfExists(stackSize)
+
+	outputSize := 0
+	for _, filename := range fieldNames {
+		r[filename], err = i.manifestAndSerializeMultiFile(obj, filename, stringOutputMode, format, outputSize)
+		if err != nil {
+			return r, err
+		}
+		outputSize += len(r[filename])
+		if err := i.checkOutputSize(outputSize); err != nil {
+			return r, err
+		}
+	}
 	return
 }
 
-func (i *interpreter) manifestAndSerializeYAMLStream(v value) (r []string, err error) {
-	r = make([]string, 0)
-	json, err := i.manifestJSON(v)
+// multiFileNames returns the sorted names of the files of the top-level
+// object in multi mode, after checking its assertions in a fresh frame,
+// as in manifestJSON. The frame is left for the caller to pop.
+func (i *interpreter) multiFileNames(obj *valueObject) ([]string, error) {
+	err := i.newCall(environment{}, false)
 	if err != nil {
-		return r, err
+		return nil, err
 	}
-	switch json := json.(type) {
-	case []interface{}:
-		for _, doc := range json {
-			var buf bytes.Buffer
-			serializeJSON(doc, true, "", &buf)
-			buf.WriteString("\n")
-			r = append(r, buf.String())
-		}
-	default:
+	if err := i.checkAssertionsForManifestation(obj); err != nil {
+		return nil, err
+	}
+	fieldNames := objectFields(obj, withoutHidden)
+	sort.Strings(fieldNames)
+	return fieldNames, nil
+}
+
+// manifestAndSerializeStreamDoc manifests and serializes a document in
+// stream mode. used is the size of the output before the document, for
+// the output limit.
+func (i *interpreter) manifestAndSerializeStreamDoc(doc value, format outputFormat, used int) (string, error) {
+	if format.format != OutputFormatJSON {
+		serialized, err := i.manifestInFormat(doc, format.format, format.preserveOrder)
+		return withFinalNewline(serialized), err
+	}
+	docJSON, err := i.manifestJSONEx(doc, format.preserveOrder)
+	if err != nil {
+		return "", err
+	}
+	buf := limitedBuffer{limit: i.limits.maxOutputSize, used: used}
+	format.serializeJSON(docJSON, &buf)
+	if format.canonical {
+		// The documents are separated by lines.
+		buf.WriteString("\n")
+	}
+	if err := i.checkOutputSize(used + buf.size); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (i *interpreter) manifestAndSerializeYAMLStream(v value, format outputFormat) (r []string, err error) {
+	r = make([]string, 0)
+	arr, isArray := v.(*valueArray)
+	if !isArray {
 		msg := fmt.Sprintf("stream mode: top-level object was a %s, "+
 			"should be an array whose elements hold "+
 			"the JSON for each document in the stream.", v.getType().name)
 		return r, makeRuntimeError(msg, i.getCurrentStackTrace())
 	}
+	// Fresh frame, as in manifestJSON.
+	err = i.newCall(environment{}, false)
+	if err != nil {
+		return r, err
+	}
+	stackSize := len(i.stack.stack)
+	defer i.stack.popIfExists(stackSize)
+
+	outputSize := 0
+	for index, th := range arr.elements {
+		serialized, err := i.manifestAndSerializeStreamElement(index, th, format, outputSize)
+		if err != nil {
+			return r, err
+		}
+		r = append(r, serialized)
+		outputSize += len(serialized)
+		if err := i.checkOutputSize(outputSize); err != nil {
+			return r, err
+		}
+	}
 	return
 }
 
+func (i *interpreter) manifestAndSerializeStreamElement(index int, th *cachedThunk, format outputFormat, used int) (string, error) {
+	msg := ast.MakeLocationRangeMessage(fmt.Sprintf("Array element %d", index))
+	i.stack.setCurrentTrace(traceElement{
+		loc: &msg,
+	})
+	defer i.stack.clearCurrentTrace()
+	doc, err := i.evaluatePV(th)
+	if err != nil {
+		return "", err
+	}
+	return i.manifestAndSerializeStreamDoc(doc, format, used)
+}
+
+// jsonToValue converts JSON to a value. The strings and the arrays in it are
+// counted against the memory limit, but not the value itself, which is counted
+// by the caller, e.g. as the result of a builtin.
 func jsonToValue(i *interpreter, v interface{}) (value, error) {
 	switch v := v.(type) {
 	case nil:
@@ -967,7 +1343,7 @@ func jsonToValue(i *interpreter, v interface{}) (value, error) {
 	case []interface{}:
 		elems := make([]*cachedThunk, len(v))
 		for counter, elem := range v {
-			val, err := jsonToValue(i, elem)
+			val, err := jsonToAllocatedValue(i, elem)
 			if err != nil {
 				return nil, err
 			}
@@ -993,7 +1369,7 @@ func jsonToValue(i *interpreter, v interface{}) (value, error) {
 	case map[string]interface{}:
 		fieldMap := map[string]value{}
 		for name, f := range v {
-			val, err := jsonToValue(i, f)
+			val, err := jsonToAllocatedValue(i, f)
 			if err != nil {
 				return nil, err
 			}
@@ -1001,6 +1377,17 @@ func jsonToValue(i *interpreter, v interface{}) (value, error) {
 		}
 		return buildObject(ast.ObjectFieldInherit, fieldMap), nil
 
+	case *orderedObject:
+		fieldMap := simpleObjectFieldMap{}
+		for name, f := range v.fields {
+			val, err := jsonToAllocatedValue(i, f)
+			if err != nil {
+				return nil, err
+			}
+			fieldMap[name] = simpleObjectField{&readyValue{val}, ast.ObjectFieldInherit}
+		}
+		return makeValueSimpleObject(bindingFrame{}, fieldMap, v.fieldNames, nil, nil), nil
+
 	case string:
 		return makeValueString(v), nil
 
@@ -1009,6 +1396,16 @@ func jsonToValue(i *interpreter, v interface{}) (value, error) {
 	}
 }
 
+// jsonToAllocatedValue converts JSON to a value like jsonToValue, and counts
+// the value itself against the memory limit too.
+func jsonToAllocatedValue(i *interpreter, v interface{}) (value, error) {
+	val, err := jsonToValue(i, v)
+	if err != nil {
+		return nil, err
+	}
+	return i.allocateResult(val)
+}
+
 func (i *interpreter) EvalInCleanEnv(env *environment, ast ast.Node, trimmable bool) (value, error) {
 	err := i.newCall(*env, trimmable)
 	if err != nil {
@@ -1042,6 +1439,14 @@ func (i *interpreter) Error(s string) error {
 	return err
 }
 
+// importError is the error of a failed import. It wraps the error of the
+// importer or the parser, so that it can be inspected with errors.As.
+func (i *interpreter) importError(err error) error {
+	runtimeErr := makeRuntimeError(err.Error(), i.getCurrentStackTrace())
+	runtimeErr.cause = err
+	return runtimeErr
+}
+
 func (i *interpreter) typeErrorSpecific(bad value, good value) error {
 	return i.Error(
 		fmt.Sprintf("Unexpected type %v, expected %v", bad.getType().name, good.getType().name),
@@ -1119,6 +1524,9 @@ func (i *interpreter) evaluateInt64(pv potentialValue) (int64, error) {
 func (i *interpreter) getString(val value) (valueString, error) {
 	switch v := val.(type) {
 	case valueString:
+		if err := flattenString(v); err != nil {
+			return nil, err
+		}
 		return v, nil
 	default:
 		return nil, i.typeErrorSpecific(val, emptyString())
@@ -1270,16 +1678,48 @@ func buildObject(hide ast.ObjectFieldHide, fields map[string]value) *valueObject
 	for name, v := range fields {
 		fieldMap[name] = simpleObjectField{&readyValue{v}, hide}
 	}
-	return makeValueSimpleObject(bindingFrame{}, fieldMap, nil, nil)
+	return makeValueSimpleObject(bindingFrame{}, fieldMap, nil, nil, nil)
+}
+
+// evalConfig is the configuration of an evaluation, taken from the VM.
+type evalConfig struct {
+	ctx            context.Context
+	ext            vmExtMap
+	tla            vmExtMap
+	nativeFuncs    map[string]evalCallable
+	maxStack       int
+	limits         evalLimits
+	importCache    *ImportCache
+	traceOut       io.Writer
+	evalHook       EvalHook
+	profiler       *Profiler
+	sandbox        *sandbox
+	errorFormatter ErrorFormatter
+
+	// Manifestation of the result.
+	stringOutputMode bool
+	format           outputFormat
+	parallelism      int
 }
 
-func buildInterpreter(ext vmExtMap, nativeFuncs map[string]*NativeFunction, maxStack int, ic *importCache, traceOut io.Writer, evalHook EvalHook) (*interpreter, error) {
+func buildInterpreter(c *evalConfig) (*interpreter, error) {
 	i := interpreter{
-		stack:       makeCallStack(maxStack),
-		importCache: ic,
-		traceOut:    traceOut,
-		nativeFuncs: nativeFuncs,
-		evalHook:    evalHook,
+		stack:       makeCallStack(c.maxStack),
+		importCache: c.importCache,
+		codeCache:   make(map[string]potentialValue),
+		traceOut:    c.traceOut,
+		nativeFuncs: c.nativeFuncs,
+		evalHook:    c.evalHook,
+		ctx:         c.ctx,
+		// Make sure that the very first step looks at the context.
+		ctxSkippedChecks: ctxCheckInterval,
+		limits:           c.limits,
+		profiler:         c.profiler,
+		sandbox:          c.sandbox,
+		errorFormatter:   c.errorFormatter,
+	}
+	if c.profiler != nil {
+		i.profileLastSample = time.Now()
 	}
 
 	stdObj, err := buildStdObject(&i)
@@ -1289,7 +1729,7 @@ func buildInterpreter(ext vmExtMap, nativeFuncs map[string]*NativeFunction, maxS
 
 	i.baseStd = stdObj
 
-	i.extVars = prepareExtVars(&i, ext, "extvar")
+	i.extVars = prepareExtVars(&i, c.ext, "extvar")
 
 	return &i, nil
 }
@@ -1350,71 +1790,242 @@ func evaluateAux(i *interpreter, node ast.Node, tla vmExtMap) (value, error) {
 	return result, nil
 }
 
-// TODO(sbarzowski) this function takes far too many arguments - build interpreter in vm instead
-func evaluate(node ast.Node, ext vmExtMap, tla vmExtMap, nativeFuncs map[string]*NativeFunction,
-	maxStack int, ic *importCache, traceOut io.Writer, stringOutputMode bool, evalHook EvalHook) (string, error) {
-
-	i, err := buildInterpreter(ext, nativeFuncs, maxStack, ic, traceOut, evalHook)
+func evaluate(node ast.Node, c *evalConfig) (string, error) {
+	i, err := buildInterpreter(c)
 	if err != nil {
 		return "", err
 	}
 
-	result, err := evaluateAux(i, node, tla)
+	result, err := evaluateAux(i, node, c.tla)
 	if err != nil {
 		return "", err
 	}
 
-	var buf bytes.Buffer
+	stringOutputMode, format := c.stringOutputMode, c.format
+	buf := limitedBuffer{limit: c.limits.maxOutputSize}
+	// The canonical JSON has no trailing newline.
+	canonical := format.canonical && !stringOutputMode && format.format == OutputFormatJSON
+	// The other formats have their own.
+	inFormat := !stringOutputMode && format.format != OutputFormatJSON
 	i.stack.setCurrentTrace(manifestationTrace())
 	if stringOutputMode {
 		err = i.manifestString(&buf, result)
+	} else if inFormat {
+		err = i.manifestAndSerializeInFormat(&buf, result, format.format, format.preserveOrder)
+	} else if canonical {
+		err = i.manifestAndSerializeCanonicalJSON(&buf, result)
 	} else {
-		err = i.manifestAndSerializeJSON(&buf, result, true, "")
+		err = i.manifestAndSerializeJSON(&buf, result, true, "", format.preserveOrder)
+	}
+	if err == nil {
+		err = i.checkOutputSize(buf.size)
 	}
 	i.stack.clearCurrentTrace()
 	if err != nil {
 		return "", err
 	}
-	buf.WriteString("\n")
+	if !canonical && !inFormat {
+		buf.WriteString("\n")
+	}
 	return buf.String(), nil
 }
 
-// TODO(sbarzowski) this function takes far too many arguments - build interpreter in vm instead
-func evaluateMulti(node ast.Node, ext vmExtMap, tla vmExtMap, nativeFuncs map[string]*NativeFunction,
-	maxStack int, ic *importCache, traceOut io.Writer, stringOutputMode bool, evalHook EvalHook) (map[string]string, error) {
+func evaluateToValue(node ast.Node, c *evalConfig) (Value, error) {
+	i, err := buildInterpreter(c)
+	if err != nil {
+		return Value{}, err
+	}
+
+	result, err := evaluateAux(i, node, c.tla)
+	if err != nil {
+		return Value{}, err
+	}
+	return Value{i: i, thunk: readyThunk(result)}, nil
+}
 
-	i, err := buildInterpreter(ext, nativeFuncs, maxStack, ic, traceOut, evalHook)
+func evaluateMulti(node ast.Node, c *evalConfig) (map[string]string, error) {
+	i, err := buildInterpreter(c)
 	if err != nil {
 		return nil, err
 	}
 
-	result, err := evaluateAux(i, node, tla)
+	// The program is evaluated again by the workers, which is only invisible
+	// without side effects: native functions, eval hooks and profiling rule
+	// out the parallel mode, std.trace makes it start over sequentially.
+	var traceCalled int32
+	parallel := c.parallelism > 1 && len(c.nativeFuncs) == 0 && c.evalHook.pre == nil && c.evalHook.post == nil && c.profiler == nil
+	if parallel {
+		i.traceCalled = &traceCalled
+	}
+
+	result, err := evaluateAux(i, node, c.tla)
+	if err == nil {
+		if obj, isObject := result.(*valueObject); isObject && parallel {
+			var manifested map[string]string
+			manifested, err = evaluateMultiParallel(i, obj, node, c)
+			if atomic.LoadInt32(&traceCalled) == 0 {
+				return manifested, err
+			}
+		}
+	}
+	if atomic.LoadInt32(&traceCalled) != 0 {
+		i, err = buildInterpreter(c)
+		if err != nil {
+			return nil, err
+		}
+		result, err = evaluateAux(i, node, c.tla)
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	i.stack.setCurrentTrace(manifestationTrace())
-	manifested, err := i.manifestAndSerializeMulti(result, stringOutputMode)
+	manifested, err := i.manifestAndSerializeMulti(result, c.stringOutputMode, c.format)
 	i.stack.clearCurrentTrace()
 	return manifested, err
 }
 
-// TODO(sbarzowski) this function takes far too many arguments - build interpreter in vm instead
-func evaluateStream(node ast.Node, ext vmExtMap, tla vmExtMap, nativeFuncs map[string]*NativeFunction,
-	maxStack int, ic *importCache, traceOut io.Writer, evalHook EvalHook) ([]string, error) {
+// evaluateMultiParallel manifests the top-level object of the program, evaluated by i,
+// in parallel. The workers evaluate the program again, see evaluateMulti.
+func evaluateMultiParallel(i *interpreter, obj *valueObject, node ast.Node, c *evalConfig) (map[string]string, error) {
+	newWorker := func() (*interpreter, *valueObject, error) {
+		wi, err := buildInterpreter(c)
+		if err != nil {
+			return nil, nil, err
+		}
+		wi.traceCalled = i.traceCalled
+		wresult, err := evaluateAux(wi, node, c.tla)
+		if err != nil {
+			return nil, nil, err
+		}
+		wobj, isObject := wresult.(*valueObject)
+		if !isObject {
+			return nil, nil, fmt.Errorf("the top-level value is no longer an object when evaluated again")
+		}
+		return wi, wobj, nil
+	}
+	return i.manifestAndSerializeMultiParallel(obj, c.stringOutputMode, c.format, c.parallelism, newWorker)
+}
+
+// manifestAndSerializeMultiParallel is the parallel version of manifestAndSerializeMulti
+// for an object at the top level. The fields are manifested by the given number of workers.
+//
+// Values cannot be shared between goroutines (they are mutated when evaluated), so every
+// worker other than the first one uses its own interpreter, created by newWorker, which
+// evaluates the program again. It stops early when std.trace is called. The fields are handed out to the workers in the sorted
+// order, so the reported error is the same as the one from the sequential version.
+func (i *interpreter) manifestAndSerializeMultiParallel(obj *valueObject, stringOutputMode bool, format outputFormat, parallelism int,
+	newWorker func() (*interpreter, *valueObject, error)) (map[string]string, error) {
+
+	// The same frame as in manifestAndSerializeMulti, for the same stack traces.
+	i.stack.setCurrentTrace(manifestationTrace())
+	defer i.stack.clearCurrentTrace()
+	fieldNames, err := i.multiFileNames(obj)
+	stackSize := len(i.stack.stack)
+	defer i.stack.popIfExists(stackSize)
+	if err != nil {
+		return nil, err
+	}
+
+	outputs := make([]string, len(fieldNames))
+	// The sizes of the outputs are kept apart, as the outputs are dropped
+	// when their total size exceeds the output limit.
+	outputSizes := make([]int, len(fieldNames))
+	var totalOutputSize int64
+	// As in the sequential version, the error of the first field in
+	// the sorted order is reported. The fields before a failed one are
+	// always handed out, so their errors are known too.
+	errs := make([]error, len(fieldNames))
+	var next int64 = -1
+	var failed int32
+
+	work := func(wi *interpreter, wobj *valueObject) {
+		for atomic.LoadInt32(&failed) == 0 {
+			index := int(atomic.AddInt64(&next, 1))
+			if index >= len(fieldNames) {
+				return
+			}
+			output, err := wi.manifestAndSerializeMultiFile(wobj, fieldNames[index], stringOutputMode, format, 0)
+			if err != nil {
+				errs[index] = err
+				atomic.StoreInt32(&failed, 1)
+				return
+			}
+			outputSizes[index] = len(output)
+			total := atomic.AddInt64(&totalOutputSize, int64(len(output)))
+			if i.limits.maxOutputSize == 0 || total <= int64(i.limits.maxOutputSize) {
+				outputs[index] = output
+			}
+		}
+	}
+
+	// The errors of the workers which are not about a field, e.g. from
+	// the evaluation of the program, have a slot per worker.
+	workerErrs := make([]error, parallelism)
+	var wg sync.WaitGroup
+	for w := 1; w < parallelism && w < len(fieldNames); w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					workerErrs[w] = fmt.Errorf("(CRASH) %v\n%s", r, debug.Stack())
+					atomic.StoreInt32(&failed, 1)
+				}
+			}()
+			wi, wobj, err := newWorker()
+			if err != nil {
+				workerErrs[w] = err
+				atomic.StoreInt32(&failed, 1)
+				return
+			}
+			wi.stack.setCurrentTrace(manifestationTrace())
+			err = wi.newCall(environment{}, false)
+			if err != nil {
+				workerErrs[w] = err
+				atomic.StoreInt32(&failed, 1)
+				return
+			}
+			work(wi, wobj)
+		}(w)
+	}
+	work(i, obj)
+	wg.Wait()
+
+	r := make(map[string]string, len(fieldNames))
+	outputSize := 0
+	for index, fieldName := range fieldNames {
+		if errs[index] != nil {
+			return r, errs[index]
+		}
+		r[fieldName] = outputs[index]
+		outputSize += outputSizes[index]
+		if err := i.checkOutputSize(outputSize); err != nil {
+			return r, err
+		}
+	}
+	// The fields left by a failed worker have no outputs.
+	for _, err := range workerErrs {
+		if err != nil {
+			return r, err
+		}
+	}
+	return r, nil
+}
 
-	i, err := buildInterpreter(ext, nativeFuncs, maxStack, ic, traceOut, evalHook)
+func evaluateStream(node ast.Node, c *evalConfig) ([]string, error) {
+	i, err := buildInterpreter(c)
 	if err != nil {
 		return nil, err
 	}
 
-	result, err := evaluateAux(i, node, tla)
+	result, err := evaluateAux(i, node, c.tla)
 	if err != nil {
 		return nil, err
 	}
 
 	i.stack.setCurrentTrace(manifestationTrace())
-	manifested, err := i.manifestAndSerializeYAMLStream(result)
+	manifested, err := i.manifestAndSerializeYAMLStream(result, c.format)
 	i.stack.clearCurrentTrace()
 	return manifested, err
 }
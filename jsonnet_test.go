@@ -1,15 +1,30 @@
 package jsonnet
 
 import (
+	"archive/tar"
+	"archive/zip"
 	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/fstest"
+	"time"
 	"unicode/utf8"
 
 	"github.com/google/go-jsonnet/ast"
+	internalerrors "github.com/google/go-jsonnet/internal/errors"
 )
 
 type errorFormattingTest struct {
@@ -21,7 +36,7 @@ type errorFormattingTest struct {
 func genericTestErrorMessage(t *testing.T, tests []errorFormattingTest, format func(RuntimeError) string) {
 	for _, test := range tests {
 		vm := MakeVM()
-		rawOutput, err := vm.evaluateSnippet(ast.DiagnosticFileName(test.name), "", test.input, evalKindRegular)
+		rawOutput, err := vm.evaluateSnippet(context.Background(), ast.DiagnosticFileName(test.name), "", test.input, evalKindRegular)
 		var errString string
 		if err != nil {
 			switch typedErr := err.(type) {
@@ -350,3 +365,1914 @@ func TestSetTraceOut(t *testing.T) {
 		t.Errorf("Expected %q, but got %q", expected, actual)
 	}
 }
+
+func TestEvaluateContext(t *testing.T) {
+	vm := MakeVM()
+	actual, err := vm.EvaluateAnonymousSnippetContext(context.Background(), "test.jsonnet", `{ x: 2 + 2 }`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	actual = removeExcessiveWhitespace(actual)
+	if expected := `{ "x": 4 }`; actual != expected {
+		t.Errorf("Expected %q, but got %q", expected, actual)
+	}
+}
+
+func TestEvaluateContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	vm := MakeVM()
+	_, err := vm.EvaluateAnonymousSnippetContext(ctx, "test.jsonnet", `2 + 2`)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	var rtErr RuntimeError
+	if !errors.As(err, &rtErr) {
+		t.Fatalf("Expected a RuntimeError, got %#v", err)
+	}
+}
+
+func TestEvaluateContextDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	vm := MakeVM()
+	start := time.Now()
+	_, err := vm.EvaluateAnonymousSnippetContext(ctx, "test.jsonnet", `std.length(std.makeArray(1e9, function(i) i))`)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "evaluation aborted") {
+		t.Errorf("Unexpected error message %q", err.Error())
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Evaluation was not aborted promptly, took %v", elapsed)
+	}
+}
+
+func TestEvaluateFileContextStackTrace(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	vm := MakeVM()
+	vm.Importer(&MemoryImporter{
+		map[string]Contents{
+			"loop.jsonnet": MakeContents("local f(n) = f(n + 1) tailstrict;\nf(0)\n"),
+		},
+	})
+	_, err := vm.EvaluateFileContext(ctx, "loop.jsonnet")
+	var rtErr RuntimeError
+	if !errors.As(err, &rtErr) {
+		t.Fatalf("Expected a RuntimeError, got %#v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if len(rtErr.StackTrace) == 0 || rtErr.StackTrace[len(rtErr.StackTrace)-1].Loc.FileName != "loop.jsonnet" {
+		t.Errorf("Expected stack trace pointing into loop.jsonnet, got %v", rtErr.StackTrace)
+	}
+}
+
+func TestResourceLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(vm *VM)
+		input  string
+		limit  string
+		inFile bool
+	}{
+		{"steps", func(vm *VM) { vm.MaxSteps = 1000 }, `local f(n) = if n == 0 then 0 else f(n - 1); f(10000)`, "MaxSteps", true},
+		{"memory_array", func(vm *VM) { vm.MaxMemory = 1 << 20 }, `std.length(std.makeArray(1e8, function(i) i))`, "MaxMemory", true},
+		{"memory_string", func(vm *VM) { vm.MaxMemory = 1 << 20 }, `local f(s, n) = if n == 0 then s else f(s + s, n - 1); std.length(f("x", 64))`, "MaxMemory", true},
+		{"output", func(vm *VM) { vm.MaxOutputSize = 100 }, `std.makeArray(100, function(i) i)`, "MaxOutputSize", false},
+		{"memory_builtin", func(vm *VM) { vm.MaxMemory = 1000 }, `local r(s, n) = if n == 0 then s else r(std.strReplace(s, "a", "aa"), n - 1); std.length(r("a", 10))`, "MaxMemory", true},
+		{"memory_map", func(vm *VM) { vm.MaxMemory = 4000 }, `std.length(std.map(function(x) x, std.range(1, 300)))`, "MaxMemory", true},
+		{"memory_flatten", func(vm *VM) { vm.MaxMemory = 1 << 20 }, `local s = std.foldl(function(acc, i) acc + "abcdefghij", std.range(1, 1000), ""); [(s + i)[0] for i in std.range(1, 100)]`, "MaxMemory", true},
+		{"memory_native", func(vm *VM) {
+			vm.MaxMemory = 1000
+			vm.NativeFunction(&NativeFunction{Name: "big", Func: func(args []interface{}) (interface{}, error) {
+				return strings.Repeat("x", 1000), nil
+			}})
+		}, `std.length(std.native("big")())`, "MaxMemory", true},
+	}
+	for _, test := range tests {
+		vm := MakeVM()
+		test.setup(vm)
+		_, err := vm.EvaluateAnonymousSnippet("limits.jsonnet", test.input)
+		var limitErr *LimitExceededError
+		if !errors.As(err, &limitErr) {
+			t.Errorf("%s: expected LimitExceededError, got %v", test.name, err)
+			continue
+		}
+		if limitErr.Limit != test.limit {
+			t.Errorf("%s: expected limit %s, got %s", test.name, test.limit, limitErr.Limit)
+		}
+		var rtErr RuntimeError
+		if !errors.As(err, &rtErr) {
+			t.Fatalf("%s: expected a RuntimeError, got %#v", test.name, err)
+		}
+		if test.inFile && !strings.Contains(err.Error(), "limits.jsonnet:1") {
+			t.Errorf("%s: expected the location in the error, got %q", test.name, err.Error())
+		}
+	}
+}
+
+func TestResourceLimitsNotExceeded(t *testing.T) {
+	vm := MakeVM()
+	vm.MaxSteps = 100000
+	vm.MaxMemory = 1 << 20
+	vm.MaxOutputSize = 1 << 20
+	actual, err := vm.EvaluateAnonymousSnippet("limits.jsonnet", `{ a: std.makeArray(3, function(i) "x" + i) }`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	actual = removeExcessiveWhitespace(actual)
+	if expected := `{ "a": [ "x0", "x1", "x2" ] }`; actual != expected {
+		t.Errorf("Expected %q, but got %q", expected, actual)
+	}
+
+	// The concatenations only count the new strings, not the ones they
+	// are made of, and the strings which are not output don't count
+	// against the output limit.
+	vm.MaxSteps = 0
+	vm.MaxMemory = 10 << 20
+	vm.MaxOutputSize = 100
+	actual, err = vm.EvaluateAnonymousSnippet("limits.jsonnet", `
+		local s = std.foldl(function(acc, i) acc + "abcdefghij", std.range(1, 6000), "");
+		[std.length(s), std.length(std.toString(std.range(1, 100)))]`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	actual = removeExcessiveWhitespace(actual)
+	if expected := `[ 60000, 392 ]`; actual != expected {
+		t.Errorf("Expected %q, but got %q", expected, actual)
+	}
+}
+
+func TestConcurrentEvaluation(t *testing.T) {
+	importer := &importerWithHistory{
+		i: MemoryImporter{
+			map[string]Contents{
+				"lib.libsonnet": MakeContents(`{ double(x):: 2 * x, arr: std.makeArray(10, function(i) i) }`),
+				"main.jsonnet":  MakeContents(`local lib = import 'lib.libsonnet'; function(n) { n: lib.double(n), len: std.length(lib.arr) }`),
+			},
+		},
+	}
+	cache := MakeImportCache(importer)
+	const workers = 8
+	const iterations = 20
+	sharedVM := MakeVM()
+	sharedVM.SetImportCache(cache)
+	sharedVM.TLACode("n", "21")
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*workers*iterations)
+	check := func(vm *VM) {
+		defer wg.Done()
+		for j := 0; j < iterations; j++ {
+			actual, err := vm.EvaluateFile("main.jsonnet")
+			if err != nil {
+				errs <- err
+				return
+			}
+			actual = removeExcessiveWhitespace(actual)
+			if expected := `{ "len": 10, "n": 42 }`; actual != expected {
+				errs <- fmt.Errorf("expected %q, but got %q", expected, actual)
+				return
+			}
+		}
+	}
+	for w := 0; w < workers; w++ {
+		// Half of the evaluations run on one VM, the other half on separate VMs sharing the cache.
+		vm := MakeVM()
+		vm.SetImportCache(cache)
+		vm.TLACode("n", "21")
+		wg.Add(2)
+		go check(sharedVM)
+		go check(vm)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if len(cache.astCache) != 2 {
+		t.Errorf("Expected two cached ASTs, got %v", cache.astCache)
+	}
+}
+
+func TestConcurrentFileImporter(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "lib.libsonnet"), []byte(`{ x: 42 }`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	importer := &FileImporter{JPaths: []string{dir}}
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Separate VMs and caches, but a shared importer.
+			vm := MakeVM()
+			vm.Importer(importer)
+			actual, err := vm.EvaluateAnonymousSnippet("test.jsonnet", `(import 'lib.libsonnet').x`)
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+				return
+			}
+			if actual != "42\n" {
+				t.Errorf("Expected %q, but got %q", "42\n", actual)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// countingFS counts the opened files.
+type countingFS struct {
+	fs.FS
+	opened map[string]int
+}
+
+func (c *countingFS) Open(name string) (fs.File, error) {
+	c.opened[name]++
+	return c.FS.Open(name)
+}
+
+func TestFSImporter(t *testing.T) {
+	fsys := &countingFS{
+		FS: fstest.MapFS{
+			"main.jsonnet":            {Data: []byte(`[(import "lib/a.libsonnet"), (import "c.libsonnet"), importstr "/data.txt"]`)},
+			"lib/a.libsonnet":         {Data: []byte(`{ a: (import "b.libsonnet").b, file: std.thisFile }`)},
+			"lib/b.libsonnet":         {Data: []byte(`{ b: importstr "../data.txt" }`)},
+			"vendor/c.libsonnet":      {Data: []byte(`"vendored"`)},
+			"override/c.libsonnet":    {Data: []byte(`"override"`)},
+			"data.txt":                {Data: []byte(`data`)},
+			"escape/escape.libsonnet": {Data: []byte(`import "../../main.jsonnet"`)},
+		},
+		opened: make(map[string]int),
+	}
+	importer := &FSImporter{FS: fsys, JPaths: []string{"vendor", "override"}}
+	vm := MakeVM()
+	vm.Importer(importer)
+	actual, err := vm.EvaluateFile("main.jsonnet")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := `[{"a":"data","file":"lib/a.libsonnet"},"override","data"]`
+	if strings.Join(strings.Fields(actual), "") != expected {
+		t.Errorf("Expected %s, but got %s", expected, actual)
+	}
+
+	_, err = vm.EvaluateFile("escape/escape.libsonnet")
+	if err == nil || !strings.Contains(err.Error(), `couldn't open import "../../main.jsonnet"`) {
+		t.Errorf("Expected an error for an import outside of the FS, got: %v", err)
+	}
+
+	// Both the contents and the nonexistence of the files are cached.
+	for i := 0; i < 2; i++ {
+		vm = MakeVM()
+		vm.Importer(importer)
+		if _, err := vm.EvaluateAnonymousSnippet("snippet", `import "lib/missing.libsonnet"`); err == nil {
+			t.Errorf("Expected an error for a missing file")
+		}
+	}
+	if fsys.opened["lib/missing.libsonnet"] != 1 || fsys.opened["main.jsonnet"] != 1 || fsys.opened["data.txt"] != 1 {
+		t.Errorf("Expected every file to be opened once, got %v", fsys.opened)
+	}
+}
+
+func TestArchiveImporter(t *testing.T) {
+	dir := t.TempDir()
+	var zipBuf bytes.Buffer
+	zw := zip.NewWriter(&zipBuf)
+	for name, content := range map[string]string{
+		"k/k.libsonnet":         `{ name: "zip", util: import "util/util.libsonnet", data: importstr "../data.txt" }`,
+		"k/util/util.libsonnet": `{ x: 1 }`,
+		"k/escape.libsonnet":    `import "../../lib/dir.libsonnet"`,
+		"data.txt":              `zip data`,
+		"shadowed.libsonnet":    `"zip"`,
+	} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var tarBuf bytes.Buffer
+	gw := gzip.NewWriter(&tarBuf)
+	tw := tar.NewWriter(gw)
+	for name, content := range map[string]string{
+		"./t.libsonnet":        `{ name: "tar", k: (import "k/k.libsonnet").name }`,
+		"./shadowed.libsonnet": `"tar"`,
+	} {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	libDir := filepath.Join(dir, "lib")
+	if err := os.Mkdir(libDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string][]byte{
+		"bundle.zip":             zipBuf.Bytes(),
+		"bundle.tar.gz":          tarBuf.Bytes(),
+		"lib/dir.libsonnet":      []byte(`"dir"`),
+		"lib/shadowed.libsonnet": []byte(`"dir"`),
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	vm := MakeVM()
+	vm.Importer(&ArchiveImporter{JPaths: []string{
+		filepath.Join(dir, "bundle.zip"),
+		filepath.Join(dir, "bundle.tar.gz") + "/",
+		libDir,
+	}})
+	actual, err := vm.EvaluateAnonymousSnippet("main.jsonnet", `[import "k/k.libsonnet", import "t.libsonnet", import "dir.libsonnet", import "shadowed.libsonnet"]`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := `[{"data":"zipdata","name":"zip","util":{"x":1}},{"k":"zip","name":"tar"},"dir","dir"]`
+	if strings.Join(strings.Fields(actual), "") != expected {
+		t.Errorf("Expected %s, but got %s", expected, actual)
+	}
+
+	foundAt, err := vm.ResolveImport("", "k/util/util.libsonnet")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := filepath.Join(dir, "bundle.zip", "k", "util", "util.libsonnet"); foundAt != expected {
+		t.Errorf("Expected %s, but got %s", expected, foundAt)
+	}
+
+	// The relative imports don't leave the archive.
+	_, err = vm.EvaluateAnonymousSnippet("main.jsonnet", `import "k/escape.libsonnet"`)
+	if err == nil {
+		t.Errorf("Expected an error for an import outside of the archive")
+	}
+}
+
+func TestJsonnetBundle(t *testing.T) {
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		"jsonnetfile.json": `{"version": 1, "dependencies": []}`,
+		"jsonnetfile.lock.json": `{
+			"version": 1,
+			"dependencies": [
+				{"source": {"git": {"remote": "https://github.com/grafana/jsonnet-libs.git", "subdir": "grafana-builder"}}, "version": "master"},
+				{"source": {"git": {"remote": "git@github.com:jsonnet-libs/k8s-libsonnet.git", "subdir": "1.29"}}, "version": "main", "name": "k8s"},
+				{"source": {"local": {"directory": "shared/utils"}}, "version": ""}
+			],
+			"legacyImports": true
+		}`,
+		"vendor/github.com/grafana/jsonnet-libs/grafana-builder/grafana.libsonnet": `"grafana"`,
+		"vendor/github.com/jsonnet-libs/k8s-libsonnet/1.29/main.libsonnet":         `"k8s"`,
+		"shared/utils/utils.libsonnet":                                             `"utils"`,
+		"lib/lib.libsonnet":                                                        `"lib"`,
+		"environments/prod/main.jsonnet": `[
+			import "github.com/grafana/jsonnet-libs/grafana-builder/grafana.libsonnet",
+			import "grafana-builder/grafana.libsonnet",
+			import "k8s/main.libsonnet",
+			import "utils/utils.libsonnet",
+			import "lib.libsonnet",
+		]`,
+	} {
+		filename := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bundle, err := FindJsonnetBundle(filepath.Join(root, "environments", "prod"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if bundle == nil || bundle.Root != root {
+		t.Fatalf("Expected the project in %s, got %#v", root, bundle)
+	}
+	expectedPackages := map[string]string{
+		"grafana-builder": filepath.Join(root, "vendor", "github.com", "grafana", "jsonnet-libs", "grafana-builder"),
+		"k8s":             filepath.Join(root, "vendor", "github.com", "jsonnet-libs", "k8s-libsonnet", "1.29"),
+		"utils":           filepath.Join(root, "shared", "utils"),
+	}
+	if !reflect.DeepEqual(bundle.Packages, expectedPackages) {
+		t.Errorf("Expected packages %v, got %v", expectedPackages, bundle.Packages)
+	}
+
+	vm := MakeVM()
+	vm.Importer(&JsonnetBundleImporter{Bundle: bundle})
+	actual, err := vm.EvaluateFile(filepath.Join(root, "environments", "prod", "main.jsonnet"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := `["grafana","grafana","k8s","utils","lib"]`
+	if strings.Join(strings.Fields(actual), "") != expected {
+		t.Errorf("Expected %s, but got %s", expected, actual)
+	}
+
+	if bundle, err := FindJsonnetBundle(t.TempDir()); bundle != nil || err != nil {
+		t.Errorf("Expected no project, got %#v, %v", bundle, err)
+	}
+}
+
+func TestImporterChain(t *testing.T) {
+	corp := fstest.MapFS{
+		"foo.libsonnet":      {Data: []byte(`{ foo: (import "bar.libsonnet").bar, file: std.thisFile }`)},
+		"bar.libsonnet":      {Data: []byte(`{ bar: "corp bar" }`)},
+		"sub/baz.libsonnet":  {Data: []byte(`"corp baz"`)},
+		"escape.libsonnet":   {Data: []byte(`import "../main.jsonnet"`)},
+		"override.libsonnet": {Data: []byte(`"corp"`)},
+	}
+	corpSub := fstest.MapFS{
+		"baz.libsonnet": {Data: []byte(`"corp/sub baz"`)},
+	}
+	importer := &ImporterChain{Importers: []Importer{
+		&PrefixImporter{Prefixes: map[string]Importer{
+			"corp":     &FSImporter{FS: corp},
+			"corp/sub": &FSImporter{FS: corpSub},
+		}},
+		&MemoryImporter{Data: map[string]Contents{
+			"main.jsonnet":            MakeContents(`[import "corp/foo.libsonnet", import "corp/sub/baz.libsonnet", import "local.libsonnet", import "corp/override.libsonnet"]`),
+			"local.libsonnet":         MakeContents(`"local"`),
+			"corp/override.libsonnet": MakeContents(`"local override"`),
+		}},
+	}}
+	vm := MakeVM()
+	vm.Importer(importer)
+	actual, err := vm.EvaluateFile("main.jsonnet")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := `[{"file":"corp/foo.libsonnet","foo":"corpbar"},"corp/subbaz","local","corp"]`
+	if strings.Join(strings.Fields(actual), "") != expected {
+		t.Errorf("Expected %s, but got %s", expected, actual)
+	}
+
+	_, err = vm.EvaluateAnonymousSnippet("snippet", `import "corp/missing.libsonnet"`)
+	var notFound *ImportNotFoundError
+	if !errors.As(err, &notFound) || !reflect.DeepEqual(notFound.Probed, []string{"corp/missing.libsonnet", "corp/missing.libsonnet"}) {
+		t.Errorf("Expected the locations probed by all the importers, got: %#v", notFound)
+	}
+	_, err = vm.EvaluateAnonymousSnippet("snippet", `import "corp/escape.libsonnet"`)
+	if err == nil {
+		t.Errorf("Expected an error for an import outside of the prefix")
+	}
+}
+
+// countingImporter counts the imports and blocks until its context is
+// cancelled, if block is set.
+type countingImporter struct {
+	MemoryImporter
+	imports int
+	block   bool
+}
+
+func (importer *countingImporter) ImportContext(ctx context.Context, importedFrom, importedPath string) (Contents, string, error) {
+	importer.imports++
+	if importer.block {
+		<-ctx.Done()
+		return Contents{}, "", ctx.Err()
+	}
+	return importer.Import(importedFrom, importedPath)
+}
+
+func TestImportErrors(t *testing.T) {
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "lib")
+	if err := os.Mkdir(lib, 0755); err != nil {
+		t.Fatal(err)
+	}
+	vm := MakeVM()
+	vm.Importer(&FileImporter{JPaths: []string{lib}})
+	_, err := vm.EvaluateAnonymousSnippet("snippet", `import "missing.libsonnet"`)
+	if !errors.Is(err, ErrImportNotFound) {
+		t.Errorf("Expected ErrImportNotFound, got: %v", err)
+	}
+	var notFound *ImportNotFoundError
+	if !errors.As(err, &notFound) || !reflect.DeepEqual(notFound.Probed, []string{"missing.libsonnet", filepath.Join(lib, "missing.libsonnet")}) {
+		t.Errorf("Expected the probed locations, got: %#v", notFound)
+	}
+	if !strings.Contains(err.Error(), `couldn't open import "missing.libsonnet": no match locally or in the Jsonnet library paths`) {
+		t.Errorf("Unexpected message: %v", err)
+	}
+
+	// MemoryImporter has no library paths.
+	vm.Importer(&MemoryImporter{})
+	_, err = vm.EvaluateAnonymousSnippet("snippet", `import "missing.libsonnet"`)
+	if !errors.Is(err, ErrImportNotFound) || !strings.HasPrefix(err.Error(), "RUNTIME ERROR: import not available missing.libsonnet\n") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	vm.Importer(&FileImporter{JPaths: []string{lib}})
+
+	// The parse errors of the imported files are available too.
+	if err := os.WriteFile(filepath.Join(lib, "broken.libsonnet"), []byte(`{`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = vm.EvaluateAnonymousSnippet("snippet", `import "broken.libsonnet"`)
+	var staticErr internalerrors.StaticError
+	if !errors.As(err, &staticErr) || errors.Is(err, ErrImportNotFound) {
+		t.Errorf("Expected a StaticError, got: %v", err)
+	}
+
+	// The imports which are not found are cached.
+	importer := &countingImporter{MemoryImporter: MemoryImporter{Data: map[string]Contents{"a": MakeContents("1")}}}
+	vm = MakeVM()
+	vm.Importer(importer)
+	for i := 0; i < 3; i++ {
+		if _, err := vm.EvaluateAnonymousSnippet("snippet", `[importstr "a", importstr "b"]`); !errors.Is(err, ErrImportNotFound) {
+			t.Errorf("Expected ErrImportNotFound, got: %v", err)
+		}
+	}
+	if importer.imports != 4 {
+		t.Errorf("Expected 4 imports (a in every evaluation, b once), got %d", importer.imports)
+	}
+
+	// The context of the evaluation is passed to the importer.
+	importer.block = true
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = vm.EvaluateAnonymousSnippetContext(ctx, "snippet", `importstr "c"`)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got: %v", err)
+	}
+}
+
+func TestInvalidateImports(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("main.jsonnet", `[import "a.libsonnet", import "b.libsonnet", importstr "c.txt"]`)
+	write("a.libsonnet", `1`)
+	write("b.libsonnet", `2`)
+	write("c.txt", `x`)
+	main := filepath.Join(dir, "main.jsonnet")
+	vm := MakeVM()
+	vm.Importer(&ArchiveImporter{})
+	evaluate := func(expected string) {
+		t.Helper()
+		out, err := vm.EvaluateFile(main)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(strings.Fields(out), ""); got != expected {
+			t.Errorf("Expected %s, got %s", expected, got)
+		}
+	}
+	evaluate(`[1,2,"x"]`)
+	b, _, err := vm.ImportAST(main, "b.libsonnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The files are read again only when invalidated.
+	write("a.libsonnet", `10`)
+	write("c.txt", `y`)
+	evaluate(`[1,2,"x"]`)
+	vm.InvalidateImports(filepath.Join(dir, "a.libsonnet"), filepath.Join(dir, "c.txt"))
+	evaluate(`[10,2,"y"]`)
+	// The AST of the unchanged file is reused.
+	if b2, _, err := vm.ImportAST(main, "b.libsonnet"); err != nil || b2 != b {
+		t.Errorf("Expected the cached AST of b.libsonnet, got a new one (err: %v)", err)
+	}
+
+	// The files which didn't exist are found after any invalidation.
+	write("main.jsonnet", `import "d.libsonnet"`)
+	vm.InvalidateImports(main)
+	if _, err := vm.EvaluateFile(main); !errors.Is(err, ErrImportNotFound) {
+		t.Errorf("Expected ErrImportNotFound, got: %v", err)
+	}
+	write("d.libsonnet", `4`)
+	vm.InvalidateImports()
+	evaluate(`4`)
+}
+
+func TestParallelMulti(t *testing.T) {
+	tests := []struct {
+		snippet      string
+		stringOutput bool
+	}{
+		{snippet: `{ ["f" + i]: { i: i, sq: std.range(0, i) } for i in std.range(1, 50) }`},
+		{snippet: `{ a: 1, b: "x", local o = self, c: { d: o.a } }`},
+		{snippet: `{}`},
+		{snippet: `{ a: 1, b: error "b failed", c: error "c failed" }`},
+		{snippet: `{ assert self.a == 2 : "bad a", a: 1 }`},
+		{snippet: `[1, 2]`},
+		{snippet: `{ ["f" + i]: std.toString(i) for i in std.range(1, 50) }`, stringOutput: true},
+		{snippet: `{ a: "x", b: 1 }`, stringOutput: true},
+		{snippet: `{ a: 1, b: error "b failed" }`, stringOutput: true},
+	}
+	for _, test := range tests {
+		vm := MakeVM()
+		vm.StringOutput = test.stringOutput
+		expected, expectedErr := vm.EvaluateAnonymousSnippetMulti("test.jsonnet", test.snippet)
+		for _, parallelism := range []int{2, 4, 100} {
+			vm.Parallelism = parallelism
+			actual, err := vm.EvaluateAnonymousSnippetMulti("test.jsonnet", test.snippet)
+			if fmt.Sprint(err) != fmt.Sprint(expectedErr) {
+				t.Errorf("%s (parallelism %d): expected error %v, but got %v", test.snippet, parallelism, expectedErr, err)
+				continue
+			}
+			if !reflect.DeepEqual(actual, expected) {
+				t.Errorf("%s (parallelism %d): expected %v, but got %v", test.snippet, parallelism, expected, actual)
+			}
+		}
+	}
+}
+
+// The native functions are not called again by workers.
+func TestParallelMultiNative(t *testing.T) {
+	var calls int32
+	vm := MakeVM()
+	vm.NativeFunction(&NativeFunction{Name: "calls", Params: ast.Identifiers{}, Func: func(args []interface{}) (interface{}, error) {
+		return float64(atomic.AddInt32(&calls, 1)), nil
+	}})
+	vm.Parallelism = 8
+	_, err := vm.EvaluateAnonymousSnippetMulti("test.jsonnet", `
+		if std.native("calls")() > 1 then error "evaluated again"
+		else { ["f" + i]: std.range(0, 1000) for i in std.range(1, 50) }`)
+	if err != nil || calls != 1 {
+		t.Errorf("expected a single call, got %d calls: %v", calls, err)
+	}
+}
+
+// The output of std.trace is written once, as in the sequential mode.
+func TestParallelMultiTrace(t *testing.T) {
+	snippets := []string{
+		`std.trace("top", { ["f" + i]: std.range(0, i) for i in std.range(1, 50) })`,
+		`{ ["f" + i]: if i == 30 then std.trace("f30", i) else i for i in std.range(1, 50) }`,
+	}
+	for _, snippet := range snippets {
+		var expected bytes.Buffer
+		vm := MakeVM()
+		vm.SetTraceOut(&expected)
+		expectedFiles, err := vm.EvaluateAnonymousSnippetMulti("test.jsonnet", snippet)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		var actual bytes.Buffer
+		vm.SetTraceOut(&actual)
+		vm.Parallelism = 8
+		files, err := vm.EvaluateAnonymousSnippetMulti("test.jsonnet", snippet)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(files, expectedFiles) {
+			t.Errorf("%s: expected %v, but got %v", snippet, expectedFiles, files)
+		}
+		if actual.String() != expected.String() {
+			t.Errorf("%s: expected the trace %q, but got %q", snippet, expected.String(), actual.String())
+		}
+	}
+}
+
+func TestProgram(t *testing.T) {
+	vm := MakeVM()
+	p, err := vm.CompileAnonymousSnippet("test.jsonnet", `function(x) { x: x, y: std.extVar("y") }`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, x := range []string{"1", "2", "3"} {
+		vm.TLACode("x", x)
+		vm.ExtVar("y", x)
+		actual, err := vm.EvaluateProgram(p)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		expected := fmt.Sprintf("{\n   \"x\": %s,\n   \"y\": \"%s\"\n}\n", x, x)
+		if actual != expected {
+			t.Errorf("Expected %q, but got %q", expected, actual)
+		}
+	}
+
+	// The same program evaluated concurrently by different VMs.
+	var wg sync.WaitGroup
+	for n := 0; n < 10; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			vm := MakeVM()
+			vm.TLACode("x", fmt.Sprint(n))
+			vm.ExtVar("y", "y")
+			files, err := vm.EvaluateProgramMulti(p)
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+				return
+			}
+			if files["x"] != fmt.Sprintf("%d\n", n) {
+				t.Errorf("Expected %q, but got %q", fmt.Sprintf("%d\n", n), files["x"])
+			}
+		}(n)
+	}
+	wg.Wait()
+}
+
+func TestProgramCompileError(t *testing.T) {
+	vm := MakeVM()
+	_, err := vm.CompileAnonymousSnippet("test.jsonnet", `{ x: }`)
+	if err == nil {
+		t.Fatalf("Expected an error")
+	}
+	expected := "test.jsonnet:1:6-7 Unexpected: \"}\" while parsing terminal\n\n{ x: }\n\n"
+	if err.Error() != expected {
+		t.Errorf("Expected %q, but got %q", expected, err.Error())
+	}
+	_, err = vm.CompileAnonymousSnippet("test.jsonnet", `x`)
+	if err == nil || !strings.Contains(err.Error(), "Unknown variable: x") {
+		t.Errorf("Expected a static error, but got %v", err)
+	}
+}
+
+func TestProgramFile(t *testing.T) {
+	vm := MakeVM()
+	p, err := vm.CompileFile("testdata/import.jsonnet")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected, err := vm.EvaluateFile("testdata/import.jsonnet")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	actual, err := vm.EvaluateProgram(p)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if actual != expected {
+		t.Errorf("Expected %q, but got %q", expected, actual)
+	}
+}
+
+type memoryASTCache struct {
+	mu     sync.Mutex
+	data   map[string][]byte
+	loads  int
+	stores int
+}
+
+func (cache *memoryASTCache) Load(key string) ([]byte, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.loads++
+	return cache.data[key], nil
+}
+
+func (cache *memoryASTCache) Store(key string, data []byte) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.stores++
+	cache.data[key] = data
+	return nil
+}
+
+func TestASTCache(t *testing.T) {
+	importer := &MemoryImporter{Data: map[string]Contents{
+		"main.jsonnet":  MakeContents(`local lib = import "lib.libsonnet"; { x: lib.x, y: lib.f(2) }`),
+		"lib.libsonnet": MakeContents(`{ x: [1, "a"], f(n):: if n > 1 then error "too big" else n }`),
+	}}
+	cache := &memoryASTCache{data: make(map[string][]byte)}
+	evaluate := func() (string, error) {
+		vm := MakeVM()
+		vm.Importer(importer)
+		vm.SetASTCache(cache)
+		return vm.EvaluateFile("main.jsonnet")
+	}
+	_, expectedErr := evaluate()
+	if expectedErr == nil {
+		t.Fatalf("Expected an error")
+	}
+	if cache.stores != 2 {
+		t.Errorf("Expected 2 stores, but got %d", cache.stores)
+	}
+	// The ASTs loaded from the cache result in the same error, with the same stack trace.
+	_, err := evaluate()
+	if err == nil || err.Error() != expectedErr.Error() {
+		t.Errorf("Expected error %v, but got %v", expectedErr, err)
+	}
+	if cache.stores != 2 || cache.loads != 4 {
+		t.Errorf("Expected 2 stores and 4 loads, but got %d and %d", cache.stores, cache.loads)
+	}
+
+	// Corrupted entries are ignored and replaced.
+	for key := range cache.data {
+		cache.data[key] = []byte("garbage")
+	}
+	_, err = evaluate()
+	if err == nil || err.Error() != expectedErr.Error() {
+		t.Errorf("Expected error %v, but got %v", expectedErr, err)
+	}
+	if cache.stores != 4 {
+		t.Errorf("Expected 4 stores, but got %d", cache.stores)
+	}
+}
+
+func TestDirASTCache(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "cache")
+	cache := &DirASTCache{Dir: dir}
+	data, err := cache.Load("foo")
+	if data != nil || err != nil {
+		t.Errorf("Expected no data and no error, but got %v and %v", data, err)
+	}
+	if err := cache.Store("foo", []byte("bar")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	data, err = cache.Load("foo")
+	if string(data) != "bar" || err != nil {
+		t.Errorf("Expected %q and no error, but got %q and %v", "bar", data, err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected 1 file in the cache directory, but got %d", len(entries))
+	}
+
+	vm := MakeVM()
+	vm.SetASTCache(cache)
+	for n := 0; n < 2; n++ {
+		actual, err := vm.EvaluateFile("testdata/import.jsonnet")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if actual != "true\n" {
+			t.Errorf("Expected %q, but got %q", "true\n", actual)
+		}
+		vm.Importer(&FileImporter{})
+	}
+}
+
+func TestTypedNativeFunction(t *testing.T) {
+	vm := MakeVM()
+	vm.TypedNativeFunction(&TypedNativeFunction{
+		Name: "choose",
+		Params: []NativeParam{
+			{Name: "cond"},
+			{Name: "a", Kind: NativeParamLazy},
+			{Name: "b", Kind: NativeParamLazy},
+		},
+		Func: func(args []Value) (Value, error) {
+			cond, err := args[0].Manifest()
+			if err != nil {
+				return Value{}, err
+			}
+			if cond == true {
+				return args[1], nil
+			}
+			return args[2], nil
+		},
+	})
+	vm.TypedNativeFunction(&TypedNativeFunction{
+		Name: "applyTwice",
+		Params: []NativeParam{
+			{Name: "f", Kind: NativeParamFunction},
+			{Name: "x", Kind: NativeParamLazy},
+		},
+		Func: func(args []Value) (Value, error) {
+			once, err := args[0].Call(args[1])
+			if err != nil {
+				return Value{}, err
+			}
+			return args[0].Call(once)
+		},
+	})
+	vm.TypedNativeFunction(&TypedNativeFunction{
+		Name:   "fieldNames",
+		Params: []NativeParam{{Name: "obj"}},
+		Func: func(args []Value) (Value, error) {
+			names, err := args[0].FieldNames(true)
+			if err != nil {
+				return Value{}, err
+			}
+			hidden, err := args[0].Field(names[0])
+			if err != nil {
+				return Value{}, err
+			}
+			hiddenJSON, err := hidden.Manifest()
+			if err != nil {
+				return Value{}, err
+			}
+			return JSONValue([]interface{}{names[0], names[1], hiddenJSON}), nil
+		},
+	})
+	vm.TypedNativeFunction(&TypedNativeFunction{
+		Name:   "makeAdder",
+		Params: []NativeParam{{Name: "n"}},
+		Func: func(args []Value) (Value, error) {
+			n := args[0]
+			adder := &TypedNativeFunction{
+				Name:   "adder",
+				Params: []NativeParam{{Name: "x"}},
+				Func: func(args []Value) (Value, error) {
+					x, err := args[0].Manifest()
+					if err != nil {
+						return Value{}, err
+					}
+					return ObjectValue([]ObjectFieldValue{
+						{Name: "n", Value: n, Hidden: true},
+						{Name: "x", Value: JSONValue(x)},
+						{Name: "both", Value: ArrayValue([]Value{n, args[0]})},
+					}), nil
+				},
+			}
+			return FunctionValue(adder), nil
+		},
+	})
+	vm.TypedNativeFunction(&TypedNativeFunction{
+		Name: "duplicate",
+		Func: func(args []Value) (Value, error) {
+			return ObjectValue([]ObjectFieldValue{
+				{Name: "a", Value: JSONValue(1.0)},
+				{Name: "a", Value: JSONValue(2.0)},
+			}), nil
+		},
+	})
+	vm.TypedNativeFunction(&TypedNativeFunction{
+		Name:   "fail",
+		Params: []NativeParam{{Name: "msg"}},
+		Func: func(args []Value) (Value, error) {
+			return Value{}, errors.New("failed")
+		},
+	})
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+		errMsg   string
+	}{
+		{"lazy", `std.native("choose")(true, 42, error "not evaluated")`, "42\n", ""},
+		{"lazy_error", `std.native("choose")(false, 42, error "evaluated")`, "", "RUNTIME ERROR: evaluated"},
+		{"callback", `std.native("applyTwice")(function(x) x * 3, 2)`, "18\n", ""},
+		{"callback_error", `std.native("applyTwice")(function(x) error "in callback", 2)`, "", "RUNTIME ERROR: in callback"},
+		{"not_function", `std.native("applyTwice")(1, 2)`, "", "RUNTIME ERROR: native function \"applyTwice\" expects a function as parameter f, got number"},
+		{"hidden", `std.native("fieldNames")({ a:: 1, b: 2 })`, "[\n   \"a\",\n   \"b\",\n   1\n]\n", ""},
+		{"return_function", `local r = std.native("makeAdder")(1)(2); [r, r.n]`,
+			"[\n   {\n      \"both\": [\n         1,\n         2\n      ],\n      \"x\": 2\n   },\n   1\n]\n", ""},
+		{"error", `std.native("fail")(1)`, "", "RUNTIME ERROR: failed"},
+		{"duplicate", `std.native("duplicate")()`, "", "RUNTIME ERROR: Duplicate field name: \"a\""},
+	}
+	for _, test := range tests {
+		actual, err := vm.EvaluateAnonymousSnippet(test.name, test.input)
+		if test.errMsg != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), test.errMsg+"\n") {
+				t.Errorf("%s: expected error %q, but got %v", test.name, test.errMsg, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if actual != test.expected {
+			t.Errorf("%s: expected %q, but got %q", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestEvaluateToValue(t *testing.T) {
+	vm := MakeVM()
+	vm.TLAVar("name", "test")
+	v, err := vm.EvaluateAnonymousSnippetToValue("test.jsonnet", `
+		function(name) {
+			name: name,
+			hidden:: true,
+			arr: [1, error "lazy"],
+			broken: error "not evaluated",
+			f(x): x + 1,
+			nested: { n: 42 },
+		}`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	mustValue := func(v Value, err error) Value {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return v
+	}
+
+	if typ, err := v.Type(); typ != "object" || err != nil {
+		t.Errorf("Expected object, but got %q (%v)", typ, err)
+	}
+	names, err := v.FieldNames(false)
+	if err != nil || !reflect.DeepEqual(names, []string{"arr", "broken", "f", "name", "nested"}) {
+		t.Errorf("Unexpected field names %v (%v)", names, err)
+	}
+	names, err = v.FieldNames(true)
+	if err != nil || len(names) != 6 {
+		t.Errorf("Unexpected field names %v (%v)", names, err)
+	}
+	if hidden, err := v.FieldHidden("hidden"); !hidden || err != nil {
+		t.Errorf("Expected the field to be hidden, but got %v (%v)", hidden, err)
+	}
+	if b, err := mustValue(v.Field("hidden")).AsBool(); !b || err != nil {
+		t.Errorf("Expected true, but got %v (%v)", b, err)
+	}
+	if s, err := mustValue(v.Field("name")).AsString(); s != "test" || err != nil {
+		t.Errorf("Expected %q, but got %q (%v)", "test", s, err)
+	}
+
+	arr := mustValue(v.Field("arr"))
+	if n, err := arr.Len(); n != 2 || err != nil {
+		t.Errorf("Expected 2, but got %v (%v)", n, err)
+	}
+	if x, err := mustValue(arr.Index(0)).AsNumber(); x != 1 || err != nil {
+		t.Errorf("Expected 1, but got %v (%v)", x, err)
+	}
+	if _, err := mustValue(arr.Index(1)).AsNumber(); err == nil || !strings.HasPrefix(err.Error(), "RUNTIME ERROR: lazy\n\ttest.jsonnet:5:") {
+		t.Errorf("Expected an error, but got %v", err)
+	}
+	if _, err := arr.Index(2); err == nil {
+		t.Errorf("Expected an error")
+	}
+
+	if _, err := v.Field("broken"); err == nil || !strings.HasPrefix(err.Error(), "RUNTIME ERROR: not evaluated\n\ttest.jsonnet:6:") {
+		t.Errorf("Expected an error, but got %v", err)
+	}
+	if _, err := v.Field("missing"); err == nil {
+		t.Errorf("Expected an error")
+	}
+
+	result := mustValue(mustValue(v.Field("f")).Call(JSONValue(1.0)))
+	if x, err := result.AsNumber(); x != 2 || err != nil {
+		t.Errorf("Expected 2, but got %v (%v)", x, err)
+	}
+
+	nested, err := mustValue(v.Field("nested")).Manifest()
+	if err != nil || !reflect.DeepEqual(nested, map[string]interface{}{"n": 42.0}) {
+		t.Errorf("Unexpected manifested value %v (%v)", nested, err)
+	}
+	if _, err := v.Manifest(); err == nil {
+		t.Errorf("Expected an error")
+	}
+
+	if _, err := JSONValue(1.0).AsNumber(); err == nil {
+		t.Errorf("Expected an error for a value created in Go")
+	}
+}
+
+type decodeInner struct {
+	N     int      `json:"n"`
+	Names []string `json:"names"`
+}
+
+type DecodeEmbedded struct {
+	Promoted string
+	Shadowed string
+}
+
+type decodeOuter struct {
+	*DecodeEmbedded
+	Shadowed  bool              `json:"shadowed"`
+	Renamed   string            `json:"other_name"`
+	CaseMatch float64           // matched case-insensitively with "casematch"
+	Skipped   string            `json:"-"`
+	Inner     decodeInner       `json:"inner"`
+	Ptr       *decodeInner      `json:"ptr"`
+	Map       map[string]uint8  `json:"map"`
+	IntKeys   map[int]bool      `json:"int_keys"`
+	Any       interface{}       `json:"any"`
+	Raw       json.RawMessage   `json:"raw"`
+	Bytes     []byte            `json:"bytes"`
+	Array     [2]int            `json:"array"`
+	Dur       time.Duration     `json:"dur"`
+	Nullable  *int              `json:"nullable"`
+	Extra     map[string]string `json:"extra,omitempty"`
+	Port      int               `json:"port,string"`
+	Flag      *bool             `json:"flag,omitempty,string"`
+	Quoted    string            `json:"quoted,string"`
+	Null      decodeNull        `json:"null"`
+	TextKeys  map[decodeKey]int `json:"text_keys"`
+}
+
+// decodeKey is a map key decoded with UnmarshalText.
+type decodeKey struct {
+	Upper string
+}
+
+func (k *decodeKey) UnmarshalText(text []byte) error {
+	k.Upper = strings.ToUpper(string(text))
+	return nil
+}
+
+// decodeNull records whether null was passed to UnmarshalJSON.
+type decodeNull struct {
+	IsNull bool
+}
+
+func (n *decodeNull) UnmarshalJSON(data []byte) error {
+	n.IsNull = string(data) == "null"
+	return nil
+}
+
+func TestEvaluateInto(t *testing.T) {
+	snippet := `{
+		promoted: "p",
+		shadowed: true,
+		other_name: "renamed",
+		casematch: 1.5,
+		Skipped: "not stored",
+		inner: { n: 3, names: ["a", "b"] },
+		ptr: { n: 4, names: [] },
+		map: { a: 1, b: 255 },
+		int_keys: { "1": true, "-2": false },
+		any: { x: [1, "y", null] },
+		raw: { z: [true] },
+		bytes: std.base64("hello"),
+		array: [7, 8, 9],
+		dur: 1000,
+		nullable: null,
+		port: "8080",
+		flag: "true",
+		quoted: std.manifestJson("q"),
+		"null": null,
+		text_keys: { a: 1, b: 2 },
+		unknown: 42,
+		hidden:: error "not evaluated",
+	}`
+	vm := MakeVM()
+	var got decodeOuter
+	if err := vm.EvaluateAnonymousSnippetInto("test.jsonnet", snippet, &got); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The result must be the same as from json.Unmarshal.
+	output, err := vm.EvaluateAnonymousSnippet("test.jsonnet", snippet)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var expected decodeOuter
+	if err := json.Unmarshal([]byte(output), &expected); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// json.RawMessage keeps the formatting, which is compact here.
+	expected.Raw = json.RawMessage(`{"z": [true]}`)
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected\n%#v\nbut got\n%#v", expected, got)
+	}
+	if got.DecodeEmbedded == nil || got.Promoted != "p" || got.Shadowed != true || got.Skipped != "" ||
+		got.Port != 8080 || got.Flag == nil || !*got.Flag || got.Quoted != "q" || !got.Null.IsNull ||
+		got.TextKeys[decodeKey{Upper: "A"}] != 1 {
+		t.Errorf("Unexpected result %#v", got)
+	}
+
+	// A non-nil pointer in an interface is decoded into, a nil one is replaced.
+	var target decodeInner
+	var ptrs [3]interface{}
+	ptrs[0] = &target
+	ptrs[2] = new(*int)
+	if err := vm.EvaluateAnonymousSnippetInto("test.jsonnet", `[{ n: 5 }, { n: 6 }, null]`, &ptrs); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ptrs[0] != &target || target.N != 5 || !reflect.DeepEqual(ptrs[1], map[string]interface{}{"n": 6.0}) || ptrs[2] == nil || *ptrs[2].(**int) != nil {
+		t.Errorf("Unexpected result %#v", ptrs)
+	}
+
+	var v Value
+	if v, err = vm.EvaluateAnonymousSnippetToValue("test.jsonnet", snippet); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	inner, err := v.Field("inner")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var decoded decodeInner
+	if err := inner.Decode(&decoded); err != nil || !reflect.DeepEqual(decoded, expected.Inner) {
+		t.Errorf("Expected %#v, but got %#v (%v)", expected.Inner, decoded, err)
+	}
+}
+
+func TestEvaluateIntoErrors(t *testing.T) {
+	tests := []struct {
+		snippet string
+		out     interface{}
+		err     string
+	}{
+		{`{ n: "3" }`, &decodeInner{}, "RUNTIME ERROR: cannot store string in Go value of type int\n" +
+			"\tField \"n\"\t\n" +
+			"\tDuring manifestation\t\n"},
+		{`{ names: [1] }`, &decodeInner{}, "RUNTIME ERROR: cannot store number in Go value of type string\n" +
+			"\tArray element 0\t\n" +
+			"\tField \"names\"\t\n" +
+			"\tDuring manifestation\t\n"},
+		{`1.5`, new(int), "RUNTIME ERROR: cannot store number 1.5 in Go value of type int\n" +
+			"\tDuring manifestation\t\n"},
+		{`300`, new(uint8), "RUNTIME ERROR: cannot store number 300 in Go value of type uint8\n" +
+			"\tDuring manifestation\t\n"},
+		{`{ "x": 1 }`, new(map[int]int), "RUNTIME ERROR: cannot store field name \"x\" in Go value of type int\n" +
+			"\tDuring manifestation\t\n"},
+		{`{ unknown: error "evaluated" }`, &decodeInner{}, "RUNTIME ERROR: evaluated\n" +
+			"\ttest.jsonnet:1:12-29\tobject <anonymous>\n" +
+			"\tField \"unknown\"\t\n" +
+			"\tDuring manifestation\t\n"},
+		{`{ f(x): x }`, new(map[string]interface{}), "RUNTIME ERROR: couldn't manifest function as JSON\n" +
+			"\tField \"f\"\t\n" +
+			"\tDuring manifestation\t\n"},
+		{`{ port: 8080 }`, &decodeOuter{}, "RUNTIME ERROR: cannot store number in Go value of type int with the ,string option\n" +
+			"\tField \"port\"\t\n" +
+			"\tDuring manifestation\t\n"},
+		{`{ port: "x" }`, &decodeOuter{}, "RUNTIME ERROR: cannot store \"x\" in Go value of type int with the ,string option\n" +
+			"\tField \"port\"\t\n" +
+			"\tDuring manifestation\t\n"},
+		{`1`, decodeInner{}, "the result can only be stored through a non-nil pointer, got jsonnet.decodeInner"},
+	}
+	for _, test := range tests {
+		vm := MakeVM()
+		err := vm.EvaluateAnonymousSnippetInto("test.jsonnet", test.snippet, test.out)
+		if err == nil {
+			t.Errorf("%s: expected an error", test.snippet)
+			continue
+		}
+		if err.Error() != test.err {
+			t.Errorf("%s: expected error\n%q\nbut got\n%q", test.snippet, test.err, err.Error())
+		}
+	}
+}
+
+type failingWriter struct {
+	written int
+}
+
+var errWriteFailed = errors.New("write failed")
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.written += len(p)
+	return 0, errWriteFailed
+}
+
+func TestEvaluateTo(t *testing.T) {
+	vm := MakeVM()
+	snippet := `{ a: [1, "x", null], b: { c: true } }`
+	expected, err := vm.EvaluateAnonymousSnippet("test.jsonnet", snippet)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := vm.EvaluateAnonymousSnippetTo(&buf, "test.jsonnet", snippet); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if buf.String() != expected {
+		t.Errorf("Expected %q, but got %q", expected, buf.String())
+	}
+
+	// The errors of the writer are returned as they are.
+	big := `std.makeArray(100000, function(i) i)`
+	if err := vm.EvaluateAnonymousSnippetTo(&failingWriter{}, "test.jsonnet", big); err != errWriteFailed {
+		t.Errorf("Expected %v, but got %v", errWriteFailed, err)
+	}
+
+	// The output is limited while it is written.
+	vm.MaxOutputSize = 1000
+	w := &failingWriter{}
+	err = vm.EvaluateAnonymousSnippetTo(w, "test.jsonnet", big)
+	var limitErr *LimitExceededError
+	if !errors.As(err, &limitErr) || limitErr.Limit != "MaxOutputSize" {
+		t.Errorf("Expected LimitExceededError, got %v", err)
+	}
+	if w.written != 0 {
+		t.Errorf("Expected no output, but %d bytes were written", w.written)
+	}
+
+	vm.MaxOutputSize = 0
+	buf.Reset()
+	err = vm.EvaluateAnonymousSnippetTo(&buf, "test.jsonnet", `[1, error "broken"]`)
+	if err == nil || !strings.HasPrefix(err.Error(), "RUNTIME ERROR: broken") {
+		t.Errorf("Expected an error, but got %v", err)
+	}
+}
+
+func TestOutputFormat(t *testing.T) {
+	vm := MakeVM()
+	vm.OutputFormat = OutputFormatYAML
+	snippet := `{ b: [1, { c: "x" }], a: "s" }`
+	expected := "\"a\": \"s\"\n\"b\":\n- 1\n- \"c\": \"x\"\n"
+	out, err := vm.EvaluateAnonymousSnippet("snippet", snippet)
+	if err != nil || out != expected {
+		t.Errorf("Expected %q, got %q (%v)", expected, out, err)
+	}
+	var buf bytes.Buffer
+	if err := vm.EvaluateAnonymousSnippetTo(&buf, "snippet", snippet); err != nil || buf.String() != expected {
+		t.Errorf("Expected %q, got %q (%v)", expected, buf.String(), err)
+	}
+
+	// The values are manifested directly by the builtins of the format.
+	_, err = vm.EvaluateAnonymousSnippet("snippet", `{ a: { b: error "x" } }`)
+	if err == nil || !strings.HasPrefix(err.Error(), "RUNTIME ERROR: x\n") {
+		t.Errorf("Expected the error of the field, got: %v", err)
+	}
+	_, err = vm.EvaluateAnonymousSnippet("snippet", `{ a: { b(x): x } }`)
+	if err == nil || !strings.HasPrefix(err.Error(), "RUNTIME ERROR: tried to manifest function\n") {
+		t.Errorf("Expected an error for a function, got: %v", err)
+	}
+
+	vm.OutputFormat = OutputFormatTOML
+	vm.OutputFormatByExtension = true
+	files, err := vm.EvaluateAnonymousSnippetMulti("snippet", `{
+		"a.yml": { x: 1 },
+		"b.json": { x: 1 },
+		"c.ini": { main: { x: 1 }, sections: { s: { y: [1, "z"] } } },
+		"d": { x: 1 },
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedFiles := map[string]string{
+		"a.yml":  "\"x\": 1\n",
+		"b.json": "{\n   \"x\": 1\n}\n",
+		"c.ini":  "x = 1\n[s]\ny = 1\ny = z\n",
+		"d":      "x = 1\n",
+	}
+	if !reflect.DeepEqual(files, expectedFiles) {
+		t.Errorf("Expected %q, got %q", expectedFiles, files)
+	}
+
+	// The output of std.manifestIni already ends with a newline.
+	vm.OutputFormat = OutputFormatINI
+	vm.OutputFormatByExtension = false
+	ini := `{ main: { x: 1 }, sections: {} }`
+	out, err = vm.EvaluateAnonymousSnippet("snippet", ini)
+	if err != nil || out != "x = 1\n" {
+		t.Errorf("Expected %q, got %q (%v)", "x = 1\n", out, err)
+	}
+	buf.Reset()
+	if err := vm.EvaluateAnonymousSnippetTo(&buf, "snippet", ini); err != nil || buf.String() != "x = 1\n" {
+		t.Errorf("Expected %q, got %q (%v)", "x = 1\n", buf.String(), err)
+	}
+	docs, err := vm.EvaluateAnonymousSnippetStream("snippet", "["+ini+"]")
+	if err != nil || !reflect.DeepEqual(docs, []string{"x = 1\n"}) {
+		t.Errorf("Expected %q, got %q (%v)", []string{"x = 1\n"}, docs, err)
+	}
+
+	if _, err := ParseOutputFormat("xml"); err == nil {
+		t.Errorf("Expected an error for an unknown format")
+	}
+	if f, err := ParseOutputFormat("ini"); f != OutputFormatINI || err != nil {
+		t.Errorf("Expected ini, got %v (%v)", f, err)
+	}
+}
+
+func TestPreserveOrder(t *testing.T) {
+	vm := MakeVM()
+	vm.PreserveOrder = true
+	snippet := `
+		local base = { kind: "Service", apiVersion: "v1", metadata: { name: "x" } };
+		base + { spec: { z: 1, y: 2 }, apiVersion: "v2", hidden:: 1 } + { [k]: 1 for k in ["q", "p"] }`
+	expected := `{"kind":"Service","apiVersion":"v2","metadata":{"name":"x"},"spec":{"z":1,"y":2},"q":1,"p":1}`
+	out, err := vm.EvaluateAnonymousSnippet("snippet", snippet)
+	if got := strings.Join(strings.Fields(out), ""); err != nil || got != expected {
+		t.Errorf("Expected %s, got %s (%v)", expected, got, err)
+	}
+	var buf bytes.Buffer
+	err = vm.EvaluateAnonymousSnippetTo(&buf, "snippet", snippet)
+	if got := strings.Join(strings.Fields(buf.String()), ""); err != nil || got != expected {
+		t.Errorf("Expected %s, got %s (%v)", expected, got, err)
+	}
+	files, err := vm.EvaluateAnonymousSnippetMulti("snippet", `{ "f.json": { b: 1, a: 2 } }`)
+	if got := strings.Join(strings.Fields(files["f.json"]), ""); err != nil || got != `{"b":1,"a":2}` {
+		t.Errorf("Expected the fields in order, got %s (%v)", got, err)
+	}
+	vm.OutputFormat = OutputFormatYAML
+	out, err = vm.EvaluateAnonymousSnippet("snippet", `{ b: 1, a: { d: 1, c: 2 } }`)
+	if err != nil || out != "\"b\": 1\n\"a\":\n  \"d\": 1\n  \"c\": 2\n" {
+		t.Errorf("Expected the fields in order, got %q (%v)", out, err)
+	}
+
+	// The fields are sorted by default, also by the builtins.
+	vm = MakeVM()
+	out, err = vm.EvaluateAnonymousSnippet("snippet", `[
+		std.manifestJsonEx({ b: 1, a: 2 }, "", "", ":"),
+		std.manifestJsonEx({ b: 1, a: 2 }, "", "", ":", preserve_order=true),
+		std.manifestYamlDoc({ b: 1, a: 2 }, preserve_order=true),
+	]`)
+	expected = `["{\"a\":2,\"b\":1}","{\"b\":1,\"a\":2}","\"b\": 1\n\"a\": 2"]`
+	if got := strings.Join(strings.Fields(out), ""); err != nil || got != strings.Join(strings.Fields(expected), "") {
+		t.Errorf("Expected %s, got %s (%v)", expected, got, err)
+	}
+}
+
+func TestCanonicalJSON(t *testing.T) {
+	// The examples from RFC 8785.
+	vm := MakeVM()
+	vm.CanonicalJSON = true
+	vm.PreserveOrder = true
+	out, err := vm.EvaluateAnonymousSnippet("snippet", `{
+		numbers: [333333333.33333329, 1E30, 4.50, 2e-3, 0.000000000000000000000000001],
+		string: "\u20ac$\u000F\u000aA'\u0042\u0022\u005c\\\"/",
+		literals: [null, true, false],
+	}`)
+	expected := `{"literals":[null,true,false],"numbers":[333333333.3333333,1e+30,4.5,0.002,1e-27],"string":"€$\u000f\nA'B\"\\\\\"/"}`
+	if err != nil || out != expected {
+		t.Errorf("Expected %s, got %s (%v)", expected, out, err)
+	}
+	var buf bytes.Buffer
+	if err := vm.EvaluateAnonymousSnippetTo(&buf, "snippet", `{ b: [], a: {} }`); err != nil || buf.String() != `{"a":{},"b":[]}` {
+		t.Errorf("Expected the canonical JSON, got %s (%v)", buf.String(), err)
+	}
+	// The fields are sorted by UTF-16 code units.
+	out, err = vm.EvaluateAnonymousSnippet("snippet", `{ "\ud83d\ude00": 1, "\ufb33": 2, "a": 3 }`)
+	if err != nil || out != "{\"a\":3,\"\U0001f600\":1,\"\ufb33\":2}" {
+		t.Errorf("Expected the fields sorted by UTF-16 code units, got %s (%v)", out, err)
+	}
+
+	numbers := map[uint64]string{
+		0x0000000000000000: "0",
+		0x8000000000000000: "0",
+		0x0000000000000001: "5e-324",
+		0x8000000000000001: "-5e-324",
+		0x7fefffffffffffff: "1.7976931348623157e+308",
+		0x4340000000000000: "9007199254740992",
+		0xc340000000000000: "-9007199254740992",
+		0x4430000000000000: "295147905179352830000",
+		0x44b52d02c7e14af5: "9.999999999999997e+22",
+		0x44b52d02c7e14af6: "1e+23",
+		0x444b1ae4d6e2ef4e: "999999999999999700000",
+		0x444b1ae4d6e2ef4f: "999999999999999900000",
+		0x444b1ae4d6e2ef50: "1e+21",
+		0x3eb0c6f7a0b5ed8c: "9.999999999999997e-7",
+		0x3eb0c6f7a0b5ed8d: "0.000001",
+		0x41b3de4355555553: "333333333.3333332",
+	}
+	for bits, expected := range numbers {
+		if got := canonicalNumber(math.Float64frombits(bits)); got != expected {
+			t.Errorf("Expected %s for %016x, got %s", expected, bits, got)
+		}
+	}
+}
+
+func TestProfiler(t *testing.T) {
+	vm := MakeVM()
+	p := NewProfiler()
+	vm.SetProfiler(p)
+	_, err := vm.EvaluateAnonymousSnippet("profile.jsonnet", `
+		local slow(n) = std.foldl(function(acc, i) acc + i, std.range(1, n), 0);
+		local concat(n) = std.join("", [std.toString(i) for i in std.range(1, n)]);
+		{ slow: [slow(1000) for _ in std.range(1, 100)], concat: concat(20000) }`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	p.Stop()
+
+	var timeTotal, allocTotal int64
+	var sawSlow bool
+	for _, sample := range p.inserted {
+		timeTotal += sample.values[1]
+		allocTotal += sample.values[2]
+		for _, frame := range sample.stack {
+			if frame.Name == "function <slow>" && sample.values[1] > 0 {
+				sawSlow = true
+			}
+		}
+	}
+	if timeTotal == 0 || allocTotal == 0 || !sawSlow {
+		t.Errorf("Expected samples of time and allocations in function <slow>, got time %d, allocations %d", timeTotal, allocTotal)
+	}
+
+	var buf bytes.Buffer
+	if err := p.WriteProfile(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if buf.Len() == 0 {
+		t.Errorf("Expected a profile")
+	}
+
+	// The evaluations after Stop are not sampled.
+	samples := len(p.inserted)
+	if _, err := vm.EvaluateAnonymousSnippet("profile.jsonnet", `std.length(std.makeArray(100000, function(i) "x" + i))`); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(p.inserted) != samples {
+		t.Errorf("Expected no new samples after Stop")
+	}
+}
+
+func TestCoverage(t *testing.T) {
+	files := map[string]Contents{
+		"lib.libsonnet": MakeContents(`{
+  pick(c)::
+    if c then
+      'yes'
+    else
+      'no',
+  unused: error 'never forced',
+  nested: {
+    a: 1,
+  },
+}
+`),
+		"main.jsonnet": MakeContents(`local lib = import 'lib.libsonnet';
+{
+  y: lib.pick(true),
+  z: lib.nested.a,
+}
+`),
+	}
+	c := NewCoverage()
+	// The coverage of separate VMs (with separate import caches) is merged.
+	for range []int{1, 2} {
+		vm := MakeVM()
+		vm.Importer(&MemoryImporter{Data: files})
+		vm.EvalHook = c.EvalHook()
+		if _, err := vm.EvaluateFile("main.jsonnet"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	var lcov bytes.Buffer
+	if err := c.WriteLCOV(&lcov); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := `TN:
+SF:lib.libsonnet
+FN:2,pick
+FN:7,unused
+FN:8,nested
+FN:9,nested.a
+FNDA:2,pick
+FNDA:0,unused
+FNDA:2,nested
+FNDA:2,nested.a
+FNF:4
+FNH:3
+BRDA:3,0,0,2
+BRDA:3,0,1,0
+BRF:2
+BRH:1
+DA:1,2
+DA:3,2
+DA:4,2
+DA:6,0
+DA:7,0
+DA:8,2
+DA:9,2
+LF:7
+LH:5
+end_of_record
+SF:main.jsonnet
+FN:3,y
+FN:4,z
+FNDA:2,y
+FNDA:2,z
+FNF:2
+FNH:2
+BRF:0
+BRH:0
+DA:1,2
+DA:2,2
+DA:3,2
+DA:4,2
+LF:4
+LH:4
+end_of_record
+`
+	if lcov.String() != expected {
+		t.Errorf("Expected LCOV report:\n%s\nbut got:\n%s", expected, lcov.String())
+	}
+
+	var cobertura bytes.Buffer
+	if err := c.WriteCobertura(&cobertura); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, s := range []string{
+		`lines-covered="9" lines-valid="11" branches-covered="1" branches-valid="2"`,
+		`<class name="lib.libsonnet" filename="lib.libsonnet" line-rate="0.7143" branch-rate="0.5" complexity="0">`,
+		`<method name="unused" signature="" line-rate="0" branch-rate="0" complexity="0">`,
+		`<line number="3" hits="2" branch="true" condition-coverage="50% (1/2)"></line>`,
+	} {
+		if !strings.Contains(cobertura.String(), s) {
+			t.Errorf("Expected %q in the Cobertura report:\n%s", s, cobertura.String())
+		}
+	}
+}
+
+func TestSandboxPolicy(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	outside := filepath.Join(dir, "outside")
+	for _, d := range []string{root, filepath.Join(root, "lib"), outside} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, content := range map[string]string{
+		filepath.Join(root, "lib", "a.libsonnet"):  "{ a: 1 }",
+		filepath.Join(root, "lib", "b.libsonnet"):  "import 'a.libsonnet'",
+		filepath.Join(root, "lib", "up.libsonnet"): "import '../data.txt'",
+		filepath.Join(root, "data.txt"):            "data",
+		filepath.Join(outside, "secret.txt"):       "secret",
+	} {
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		t.Skipf("Symbolic links not supported: %v", err)
+	}
+
+	policy := &SandboxPolicy{
+		DisableImportBin:        true,
+		ImportRoots:             []string{root},
+		DisallowParentImports:   true,
+		DisabledNativeFunctions: []string{"exec"},
+		RestrictExtVars:         true,
+		AllowedExtVars:          []string{"env"},
+	}
+	tests := []struct {
+		name   string
+		policy *SandboxPolicy
+		code   string
+		result string
+		err    string
+	}{
+		{"allowed", policy, `[(import 'lib/b.libsonnet').a, importstr 'data.txt', std.extVar('env'), std.native('upper')('x')]`, `[1,"data","prod","X"]`, ""},
+		{"importbin", policy, `importbin 'data.txt'`, "", "importbin is disabled by the sandbox policy"},
+		{"outside", policy, `importstr '` + filepath.Join(outside, "secret.txt") + `'`, "", "is outside of the import roots"},
+		{"symlink", policy, `importstr 'link/secret.txt'`, "", "is outside of the import roots"},
+		{"parent", policy, `importstr 'lib/../../outside/secret.txt'`, "", "paths leading to parent directories are forbidden"},
+		{"nested parent", policy, `import 'lib/up.libsonnet'`, "", "paths leading to parent directories are forbidden"},
+		{"absolute", &SandboxPolicy{DisallowAbsoluteImports: true}, `importstr '` + filepath.Join(root, "data.txt") + `'`, "", "absolute paths are forbidden"},
+		{"native", policy, `std.native('exec')`, "", `native function "exec" is disabled by the sandbox policy`},
+		{"ext var", policy, `std.extVar('secret')`, "", `external variable "secret" is not allowed by the sandbox policy`},
+		{"no policy", nil, `[importbin 'data.txt', std.extVar('secret'), importstr 'link/secret.txt']`, `[[100,97,116,97],"s","secret"]`, ""},
+	}
+	for _, test := range tests {
+		vm := MakeVM()
+		vm.SetSandboxPolicy(test.policy)
+		vm.ExtVar("env", "prod")
+		vm.ExtVar("secret", "s")
+		vm.NativeFunction(&NativeFunction{Name: "upper", Params: ast.Identifiers{"s"}, Func: func(args []interface{}) (interface{}, error) {
+			return strings.ToUpper(args[0].(string)), nil
+		}})
+		vm.NativeFunction(&NativeFunction{Name: "exec", Params: ast.Identifiers{}, Func: func(args []interface{}) (interface{}, error) {
+			return nil, errors.New("should not be called")
+		}})
+		filename := filepath.Join(root, "main.jsonnet")
+		if err := os.WriteFile(filename, []byte(test.code), 0644); err != nil {
+			t.Fatal(err)
+		}
+		out, err := vm.EvaluateFile(filename)
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.name, err)
+			} else if strings.Join(strings.Fields(out), "") != test.result {
+				t.Errorf("%s: expected %s, got %s", test.name, test.result, out)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: expected an error, got %s", test.name, out)
+			continue
+		}
+		// The errors are reported at the offending expressions.
+		if !strings.Contains(err.Error(), test.err) || !strings.Contains(err.Error(), ".jsonnet:1:") && !strings.Contains(err.Error(), ".libsonnet:1:") {
+			t.Errorf("%s: expected an error %q at the offending expression, got: %v", test.name, test.err, err)
+		}
+	}
+}
+
+// The files outside of the import roots are never read, so that e.g. a FIFO
+// cannot block the evaluation, and nothing is cached for them.
+func TestSandboxPolicyNoRead(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	secret := filepath.Join(outside, "secret.txt")
+	if err := os.WriteFile(secret, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	importer := &FileImporter{}
+	vm := MakeVM()
+	vm.Importer(importer)
+	vm.SetSandboxPolicy(&SandboxPolicy{ImportRoots: []string{root}})
+	_, err := vm.EvaluateAnonymousSnippet(filepath.Join(root, "main.jsonnet"), `importstr '`+secret+`'`)
+	if err == nil || !strings.Contains(err.Error(), "is outside of the import roots") {
+		t.Fatalf("expected an error about the import roots, got: %v", err)
+	}
+	if _, cached := importer.fsCache[secret]; cached {
+		t.Errorf("the rejected file %s was cached", secret)
+	}
+
+	// The rejection is not cached either.
+	vm.SetSandboxPolicy(nil)
+	out, err := vm.EvaluateAnonymousSnippet(filepath.Join(root, "main.jsonnet"), `importstr '`+secret+`'`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "\"secret\"\n" {
+		t.Errorf("expected \"secret\", got %s", out)
+	}
+}
+
+func TestSourceMap(t *testing.T) {
+	vm := MakeVM()
+	vm.Importer(&MemoryImporter{
+		Data: map[string]Contents{
+			"lib.libsonnet": MakeContents("{\n  deployment: { replicas: 1, image: 'nginx', labels: ['a'] },\n}"),
+		},
+	})
+	snippet := `(import "lib.libsonnet") + {
+		deployment+: { replicas: 3, "a/b": std.split("x,y", ",") },
+	}`
+	out, sourceMap, err := vm.EvaluateAnonymousSnippetWithSourceMap("main.jsonnet", snippet)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// The output is the same as without the source map.
+	if expected, _ := vm.EvaluateAnonymousSnippet("main.jsonnet", snippet); out != expected {
+		t.Errorf("Expected %q, got %q", expected, out)
+	}
+
+	type entry struct {
+		loc         string
+		definitions []string
+	}
+	got := make(map[string]entry)
+	var paths []string
+	for _, e := range sourceMap {
+		var definitions []string
+		for _, d := range e.Definitions {
+			definitions = append(definitions, fmt.Sprintf("%s %v %v", d.Loc.String(), d.Hide, d.PlusSuper))
+		}
+		got[e.Path] = entry{loc: e.Loc.String(), definitions: definitions}
+		paths = append(paths, e.Path)
+	}
+	expectedPaths := []string{"", "/deployment", "/deployment/a~1b", "/deployment/a~1b/0", "/deployment/a~1b/1",
+		"/deployment/image", "/deployment/labels", "/deployment/labels/0", "/deployment/replicas"}
+	if !reflect.DeepEqual(paths, expectedPaths) {
+		t.Errorf("Expected paths %v, got %v", expectedPaths, paths)
+	}
+	expected := map[string]entry{
+		"": {loc: "main.jsonnet:(1:1)-(3:3)"},
+		"/deployment": {loc: "main.jsonnet:2:16-61", definitions: []string{
+			"main.jsonnet:2:16-61 1 true",
+			"lib.libsonnet:2:15-61 1 false",
+		}},
+		// The elements produced by a builtin are attributed to the array.
+		"/deployment/a~1b/1":   {loc: "main.jsonnet:2:38-59"},
+		"/deployment/labels/0": {loc: "lib.libsonnet:2:55-58"},
+		"/deployment/replicas": {loc: "main.jsonnet:2:28-29", definitions: []string{
+			"main.jsonnet:2:28-29 1 false",
+			"lib.libsonnet:2:27-28 1 false",
+		}},
+	}
+	for path, e := range expected {
+		if !reflect.DeepEqual(got[path], e) {
+			t.Errorf("%q: expected %#v, got %#v", path, e, got[path])
+		}
+	}
+
+	data, err := json.Marshal(sourceMap[:2])
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expectedJSON := `{"":{"location":{"file":"main.jsonnet","begin":{"line":1,"column":1},"end":{"line":3,"column":3}}},` +
+		`"/deployment":{"location":{"file":"main.jsonnet","begin":{"line":2,"column":16},"end":{"line":2,"column":61}},"definitions":[` +
+		`{"location":{"file":"main.jsonnet","begin":{"line":2,"column":16},"end":{"line":2,"column":61}},"visibility":":","plus_super":true,"uses_super":false},` +
+		`{"location":{"file":"lib.libsonnet","begin":{"line":2,"column":15},"end":{"line":2,"column":61}},"visibility":":","plus_super":false,"uses_super":false}]}}`
+	if string(data) != expectedJSON {
+		t.Errorf("Expected %s, got %s", expectedJSON, data)
+	}
+}
+
+func TestParseValuePath(t *testing.T) {
+	tests := []struct {
+		path     string
+		elements []pathElement
+		err      string
+	}{
+		{"", nil, ""},
+		{"a", []pathElement{{field: "a"}}, ""},
+		{"a.b-c[3].d", []pathElement{{field: "a"}, {field: "b-c"}, {index: 3, isIndex: true}, {field: "d"}}, ""},
+		{"[0][1]", []pathElement{{index: 0, isIndex: true}, {index: 1, isIndex: true}}, ""},
+		{`a["b.c]"]['d[e']`, []pathElement{{field: "a"}, {field: "b.c]"}, {field: "d[e"}}, ""},
+		{"a..b", nil, `invalid path "a..b": empty field name`},
+		{"a.", nil, `invalid path "a.": empty field name`},
+		{"a[1]b", nil, `invalid path "a[1]b": expected . or [ after ]`},
+		{"a[-1]", nil, `invalid path "a[-1]": invalid array index "-1"`},
+		{"a[1", nil, `invalid path "a[1": missing ]`},
+		{`a["b"`, nil, `invalid path "a[\"b\"": expected ] after "b"`},
+	}
+	for _, test := range tests {
+		elements, err := parseValuePath(test.path)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%q: expected error %q, got %v", test.path, test.err, err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(elements, test.elements) {
+			t.Errorf("%q: expected %v, got %v (%v)", test.path, test.elements, elements, err)
+		}
+	}
+}
+
+func TestFieldDefinitions(t *testing.T) {
+	vm := MakeVM()
+	v, err := vm.EvaluateAnonymousSnippetToValue("test.jsonnet", `
+		local base = { d: { replicas: 1, image:: "a" }, arr: [{ x: 1 }] };
+		base + { d+: { replicas: super.replicas + 1 } } + { d+: { image::: "b" }, broken: error "not evaluated" }`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	describe := func(definitions []FieldDefinition) []string {
+		var r []string
+		for _, d := range definitions {
+			r = append(r, fmt.Sprintf("%s %v %v %v", d.Loc.String(), d.Hide, d.PlusSuper, d.UsesSuper))
+		}
+		return r
+	}
+	tests := []struct {
+		path     string
+		expected []string
+	}{
+		{"d", []string{"test.jsonnet:3:59-75 1 true false", "test.jsonnet:3:16-48 1 true false", "test.jsonnet:2:21-49 1 false false"}},
+		{"d.replicas", []string{"test.jsonnet:3:28-46 1 false true", "test.jsonnet:2:33-34 1 false false"}},
+		{"d.image", []string{"test.jsonnet:3:70-73 2 false false", "test.jsonnet:2:44-47 0 false false"}},
+		{"arr[0].x", []string{"test.jsonnet:2:62-63 1 false false"}},
+	}
+	for _, test := range tests {
+		definitions, err := v.FieldDefinitions(test.path)
+		if err != nil || !reflect.DeepEqual(describe(definitions), test.expected) {
+			t.Errorf("%s: expected %v, got %v (%v)", test.path, test.expected, describe(definitions), err)
+		}
+	}
+	for _, path := range []string{"d.missing", "arr[0]", "arr[1].x", "d..x"} {
+		if _, err := v.FieldDefinitions(path); err == nil {
+			t.Errorf("%s: expected an error", path)
+		}
+	}
+}
+
+func TestEvaluatePath(t *testing.T) {
+	vm := MakeVM()
+	snippet := `{
+		assert self.ok : "root assertion",
+		ok: true,
+		a: { b: [0, 1, 2, { c: "tag:1.2", d: error "sibling" }] },
+		other: { assert false : "off the path" },
+		slow: error "not evaluated",
+	}`
+	tests := []struct {
+		path     string
+		expected string
+		err      string
+	}{
+		{"a.b[3].c", "\"tag:1.2\"\n", ""},
+		{`["a"].b[1]`, "1\n", ""},
+		{"ok", "true\n", ""},
+		{"a.b[3].d", "", "RUNTIME ERROR: sibling"},
+		{"other", "", "RUNTIME ERROR: off the path"},
+		{"a.b[4]", "", "RUNTIME ERROR: Index 4 out of bounds, not within [0, 4)"},
+		{"a.x", "", "RUNTIME ERROR: Field does not exist: x"},
+		{"a[0]", "", "RUNTIME ERROR: Unexpected type object, expected array"},
+		{"a[", "", `invalid path "a[": missing ]`},
+	}
+	for _, test := range tests {
+		out, err := vm.EvaluateAnonymousSnippetPath("test.jsonnet", snippet, test.path)
+		if test.err != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+				t.Errorf("%s: expected error %q, got %v", test.path, test.err, err)
+			}
+			continue
+		}
+		if err != nil || out != test.expected {
+			t.Errorf("%s: expected %q, got %q (%v)", test.path, test.expected, out, err)
+		}
+	}
+
+	// The assertions of the objects on the path are checked.
+	_, err := vm.EvaluateAnonymousSnippetPath("test.jsonnet", `{ ok: false, assert self.ok : "root assertion", a: 1 }`, "a")
+	if err == nil || !strings.HasPrefix(err.Error(), "RUNTIME ERROR: root assertion") {
+		t.Errorf("Expected the root assertion to fail, got %v", err)
+	}
+
+	// The value is manifested in the output format of the VM.
+	vm.OutputFormat = OutputFormatYAML
+	out, err := vm.EvaluateAnonymousSnippetPath("test.jsonnet", snippet, "a.b[3]")
+	if err == nil || !strings.Contains(err.Error(), "sibling") {
+		t.Errorf("Expected the field d to be manifested, got %q (%v)", out, err)
+	}
+	out, err = vm.EvaluateAnonymousSnippetPath("test.jsonnet", `{ a: { x: [1], y: "z" }, b: error "b" }`, "a")
+	if expected := "\"x\":\n- 1\n\"y\": \"z\"\n"; err != nil || out != expected {
+		t.Errorf("Expected %q, got %q (%v)", expected, out, err)
+	}
+}
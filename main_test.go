@@ -18,6 +18,7 @@ package jsonnet
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -164,7 +165,7 @@ func runInternalJsonnet(i jsonnetInput) jsonnetResult {
 	testChildren(desugaredAST)
 
 	// TODO(sbarzowski) We should treat the tests as anonymous snippets or import them with an importer.
-	rawOutput, err := vm.evaluateSnippet(ast.DiagnosticFileName(i.name), i.name, string(i.input), i.eKind)
+	rawOutput, err := vm.evaluateSnippet(context.Background(), ast.DiagnosticFileName(i.name), i.name, string(i.input), i.eKind)
 	switch {
 	case err != nil:
 		// TODO(sbarzowski) perhaps somehow mark that we are processing
@@ -184,6 +185,37 @@ func runInternalJsonnet(i jsonnetInput) jsonnetResult {
 	}
 }
 
+// runStreamedJsonnet is like runInternalJsonnet, but the output is streamed
+// to a writer, as with EvaluateFileTo.
+func runStreamedJsonnet(i jsonnetInput) jsonnetResult {
+	vm := MakeVM()
+	vm.ErrorFormatter = &termErrorFormatter{pretty: true, maxStackTraceSize: 9}
+
+	vm.StringOutput = i.stringOutputMode
+	for name, value := range i.extVars {
+		vm.ExtVar(name, value)
+	}
+	for name, value := range i.extCode {
+		vm.ExtCode(name, value)
+	}
+
+	vm.NativeFunction(jsonToString)
+	vm.NativeFunction(nativeError)
+	vm.NativeFunction(nativePanic)
+
+	var output bytes.Buffer
+	rawOutput, err := vm.evaluateSnippet(context.Background(), ast.DiagnosticFileName(i.name), i.name, string(i.input), evalKindValue)
+	if err := vm.writeResult(&output, rawOutput, err); err != nil {
+		return jsonnetResult{
+			output:  err.Error() + "\n",
+			isError: true,
+		}
+	}
+	return jsonnetResult{
+		output: output.String(),
+	}
+}
+
 // TODO(lukegb) CLI test support is presently completely broken: fix?
 func runJsonnetCommand(i jsonnetInput) jsonnetResult {
 	// TODO(sbarzowski) Special handling of errors (which may differ between versions)
@@ -340,6 +372,21 @@ func runTest(t *testing.T, test *mainTest) {
 		extCode:          test.meta.extCode,
 	})
 
+	if eKind == evalKindRegular && (jsonnetCmd == nil || *jsonnetCmd == "") {
+		streamed := runStreamedJsonnet(jsonnetInput{
+			name:             test.name,
+			input:            input,
+			stringOutputMode: strings.HasSuffix(test.golden, "_string_output.golden"),
+			extVars:          test.meta.extVars,
+			extCode:          test.meta.extCode,
+		})
+		// Static errors are reported in a different way, so only the results
+		// of successful evaluations are compared in full.
+		if streamed.isError != result.isError || (!result.isError && streamed.output != result.output) {
+			t.Errorf("streamed output differs:\n%v\nexpected:\n%v", streamed.output, result.output)
+		}
+	}
+
 	if eKind == evalKindMulti && result.isError {
 		// If it's an error, then result.output is populated instead.
 		// Since we use the golden file being a directory to determine if we
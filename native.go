@@ -0,0 +1,172 @@
+/*
+Copyright 2026 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonnet
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/go-jsonnet/ast"
+)
+
+// TypedNativeFunction represents a function implemented in Go, which operates
+// on Jsonnet values rather than on JSON. Unlike NativeFunction, it can accept
+// functions (and call them), access hidden fields of objects, evaluate only
+// the parts of its arguments which it needs, and return any Jsonnet value.
+// It is registered with VM.TypedNativeFunction.
+type TypedNativeFunction struct {
+	Name   string
+	Params []NativeParam
+	Func   func(args []Value) (Value, error)
+}
+
+// NativeParam is a parameter of a TypedNativeFunction.
+type NativeParam struct {
+	Name ast.Identifier
+	Kind NativeParamKind
+}
+
+// NativeParamKind determines how an argument is passed to a TypedNativeFunction.
+type NativeParamKind int
+
+const (
+	// NativeParamEager means that the argument is evaluated before the call.
+	// It is not manifested, though, so e.g. the fields of an object
+	// are evaluated only when accessed.
+	NativeParamEager NativeParamKind = iota
+	// NativeParamLazy means that the argument is passed unevaluated.
+	// It is evaluated when the native function needs it, if at all.
+	NativeParamLazy
+	// NativeParamFunction means that the argument is evaluated before
+	// the call and it must be a function.
+	NativeParamFunction
+)
+
+// evalCall evaluates a call to a TypedNativeFunction and returns the result.
+func (native *TypedNativeFunction) evalCall(arguments callArguments, i *interpreter) (value, error) {
+	flatArgs := flattenArgs(arguments, native.parameters(), []value{})
+	nativeArgs := make([]Value, 0, len(flatArgs))
+	for index, arg := range flatArgs {
+		param := native.Params[index]
+		if param.Kind != NativeParamLazy {
+			v, err := i.evaluatePV(arg)
+			if err != nil {
+				return nil, err
+			}
+			if _, isFunction := v.(*valueFunction); param.Kind == NativeParamFunction && !isFunction {
+				return nil, i.Error(fmt.Sprintf("native function %#v expects a function as parameter %v, got %v",
+					native.Name, param.Name, v.getType().name))
+			}
+		}
+		nativeArgs = append(nativeArgs, Value{i: i, thunk: arg})
+	}
+	call := func() (result Value, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("native function %#v panicked: %v", native.Name, r)
+			}
+		}()
+		return native.Func(nativeArgs)
+	}
+	result, err := call()
+	if err != nil {
+		// Errors of the evaluation of the arguments are passed through.
+		var runtimeErr RuntimeError
+		if errors.As(err, &runtimeErr) {
+			return nil, err
+		}
+		return nil, i.Error(err.Error())
+	}
+	return result.force(i)
+}
+
+func (native *TypedNativeFunction) parameters() []namedParameter {
+	ret := make([]namedParameter, len(native.Params))
+	for i := range ret {
+		ret[i].name = native.Params[i].Name
+	}
+	return ret
+}
+
+// JSONValue creates a Value from JSON, represented in the same way as
+// the result of a NativeFunction.
+func JSONValue(json interface{}) Value {
+	return Value{build: func(i *interpreter) (value, error) {
+		return jsonToAllocatedValue(i, json)
+	}}
+}
+
+// ArrayValue creates an array. The elements which came from the evaluation
+// are not evaluated until they are needed.
+func ArrayValue(elements []Value) Value {
+	return Value{build: func(i *interpreter) (value, error) {
+		thunks := make([]*cachedThunk, len(elements))
+		for index, element := range elements {
+			thunks[index] = element.toThunk(i)
+		}
+		return i.allocateResult(makeValueArray(thunks))
+	}}
+}
+
+// ObjectFieldValue is a field of an object created by ObjectValue.
+type ObjectFieldValue struct {
+	Name   string
+	Value  Value
+	Hidden bool
+}
+
+// valueUnboundField is an object field with a value created in Go.
+type valueUnboundField struct {
+	v Value
+}
+
+func (f *valueUnboundField) evaluate(i *interpreter, sb selfBinding, origBinding bindingFrame, fieldName string) (value, error) {
+	return f.v.force(i)
+}
+
+func (f *valueUnboundField) loc() *ast.LocationRange {
+	return &ast.LocationRange{}
+}
+
+// ObjectValue creates an object. The values of the fields are not evaluated
+// (or created, for the Values created in Go) until the fields are accessed.
+// The field names must be unique, the evaluation fails otherwise.
+func ObjectValue(fields []ObjectFieldValue) Value {
+	return Value{build: func(i *interpreter) (value, error) {
+		fieldMap := simpleObjectFieldMap{}
+		var order []string
+		for _, field := range fields {
+			hide := ast.ObjectFieldInherit
+			if field.Hidden {
+				hide = ast.ObjectFieldHidden
+			}
+			if _, exists := fieldMap[field.Name]; exists {
+				return nil, i.Error(duplicateFieldNameErrMsg(field.Name))
+			}
+			order = append(order, field.Name)
+			fieldMap[field.Name] = simpleObjectField{&valueUnboundField{field.Value}, hide}
+		}
+		return makeValueSimpleObject(bindingFrame{}, fieldMap, order, nil, nil), nil
+	}}
+}
+
+// FunctionValue creates a function implemented in Go.
+func FunctionValue(f *TypedNativeFunction) Value {
+	return Value{build: func(i *interpreter) (value, error) {
+		return &valueFunction{ec: f}, nil
+	}}
+}
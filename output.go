@@ -0,0 +1,491 @@
+/*
+Copyright 2026 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonnet
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"runtime/debug"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/go-jsonnet/ast"
+)
+
+// EvaluateFileTo evaluates Jsonnet code in a file and writes the resulting
+// JSON to w. The output is the same as from EvaluateFile, but it is written
+// as the result is manifested, so it is never held in memory as a whole.
+//
+// If the evaluation fails, a part of the output may have been written already.
+// The errors of w are returned as they are, without any formatting.
+//
+// The importer is used to fetch the contents of the file.
+func (vm *VM) EvaluateFileTo(w io.Writer, filename string) error {
+	return vm.EvaluateFileToContext(context.Background(), w, filename)
+}
+
+// EvaluateFileToContext is like EvaluateFileTo, but the evaluation is aborted
+// when ctx is cancelled or its deadline passes. In that case the returned
+// error wraps ctx.Err().
+func (vm *VM) EvaluateFileToContext(ctx context.Context, w io.Writer, filename string) error {
+	output, err := vm.evaluateFile(ctx, filename, evalKindValue)
+	return vm.writeResult(w, output, err)
+}
+
+// EvaluateAnonymousSnippetTo evaluates a string containing Jsonnet code and
+// writes the resulting JSON to w, like EvaluateFileTo.
+//
+// The filename parameter is only used for error messages.
+func (vm *VM) EvaluateAnonymousSnippetTo(w io.Writer, filename string, snippet string) error {
+	return vm.EvaluateAnonymousSnippetToContext(context.Background(), w, filename, snippet)
+}
+
+// EvaluateAnonymousSnippetToContext is like EvaluateAnonymousSnippetTo, but
+// the evaluation is aborted when ctx is cancelled or its deadline passes.
+// In that case the returned error wraps ctx.Err().
+func (vm *VM) EvaluateAnonymousSnippetToContext(ctx context.Context, w io.Writer, filename string, snippet string) error {
+	output, err := vm.evaluateSnippet(ctx, ast.DiagnosticFileName(filename), "", snippet, evalKindValue)
+	return vm.writeResult(w, output, err)
+}
+
+// EvaluateProgramTo evaluates a compiled program and writes the resulting
+// JSON to w, like EvaluateFileTo.
+func (vm *VM) EvaluateProgramTo(w io.Writer, p *Program) error {
+	output, err := vm.evaluateNode(context.Background(), p.node, evalKindValue)
+	return vm.writeResult(w, output, err)
+}
+
+// outputWriteError is an error of the writer to which the output is streamed.
+type outputWriteError struct {
+	err error
+}
+
+func (err *outputWriteError) Error() string {
+	return err.err.Error()
+}
+
+func (err *outputWriteError) Unwrap() error {
+	return err.err
+}
+
+// jsonWriter is where JSON is serialized: a bytes.Buffer, or a limitedBuffer
+// for the output.
+type jsonWriter interface {
+	io.Writer
+	io.ByteWriter
+	io.StringWriter
+	WriteRune(r rune) (int, error)
+}
+
+// limitedBuffer is a buffer for the serialized output, which drops what would
+// exceed the output limit (if any), so that an output too big is never held in
+// memory as a whole. size is the size of the whole output, including what was
+// dropped, and used is the size of the output written before the buffer,
+// which counts towards the limit too.
+type limitedBuffer struct {
+	buf   bytes.Buffer
+	limit int
+	used  int
+	size  int
+}
+
+// fits counts n more bytes of the output and tells whether they fit in
+// the limit.
+func (b *limitedBuffer) fits(n int) bool {
+	b.size += n
+	return b.limit == 0 || b.used+b.size <= b.limit
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.fits(len(p)) {
+		b.buf.Write(p)
+	}
+	return len(p), nil
+}
+
+func (b *limitedBuffer) WriteByte(c byte) error {
+	if b.fits(1) {
+		b.buf.WriteByte(c)
+	}
+	return nil
+}
+
+func (b *limitedBuffer) WriteString(s string) (int, error) {
+	if b.fits(len(s)) {
+		b.buf.WriteString(s)
+	}
+	return len(s), nil
+}
+
+func (b *limitedBuffer) WriteRune(r rune) (int, error) {
+	n := utf8.RuneLen(r)
+	if n < 0 {
+		n = utf8.RuneLen(utf8.RuneError)
+	}
+	if b.fits(n) {
+		b.buf.WriteRune(r)
+	}
+	return n, nil
+}
+
+// String returns the output, which is incomplete if it exceeds the limit.
+func (b *limitedBuffer) String() string {
+	return b.buf.String()
+}
+
+// outputWriter writes the manifested output, keeping track of its size.
+type outputWriter struct {
+	w    *bufio.Writer
+	size int
+	// preserveOrder makes the fields of the objects be written in the order
+	// of their definitions.
+	preserveOrder bool
+}
+
+func (i *interpreter) writeOutput(out *outputWriter, s string) error {
+	out.size += len(s)
+	if err := i.checkOutputSize(out.size); err != nil {
+		return err
+	}
+	if _, err := out.w.WriteString(s); err != nil {
+		return &outputWriteError{err: err}
+	}
+	return nil
+}
+
+func (vm *VM) writeResult(w io.Writer, output interface{}, err error) error {
+	if err != nil {
+		return vm.formatError(err)
+	}
+	v := output.(Value)
+	out := &outputWriter{w: bufio.NewWriter(w), preserveOrder: vm.PreserveOrder}
+	err = func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("(CRASH) %v\n%s", r, debug.Stack())
+			}
+		}()
+		v.i.stack.setCurrentTrace(manifestationTrace())
+		defer v.i.stack.clearCurrentTrace()
+		val, err := v.force(v.i)
+		if err != nil {
+			return err
+		}
+		if vm.StringOutput {
+			str, isString := val.(valueString)
+			if !isString {
+				return makeRuntimeError(fmt.Sprintf("expected string result, got: %s", val.getType().name), v.i.getCurrentStackTrace())
+			}
+			err = v.i.writeOutput(out, str.getGoString())
+		} else if vm.OutputFormat != OutputFormatJSON {
+			// The other formats are not streamed, and they have their own
+			// trailing newline.
+			buf := limitedBuffer{limit: v.i.limits.maxOutputSize}
+			if err := v.i.manifestAndSerializeInFormat(&buf, val, vm.OutputFormat, vm.PreserveOrder); err != nil {
+				return err
+			}
+			if err := v.i.checkOutputSize(buf.size); err != nil {
+				return err
+			}
+			return v.i.writeOutput(out, buf.String())
+		} else if vm.CanonicalJSON {
+			// The canonical form has no trailing newline.
+			buf := limitedBuffer{limit: v.i.limits.maxOutputSize}
+			if err := v.i.manifestAndSerializeCanonicalJSON(&buf, val); err != nil {
+				return err
+			}
+			if err := v.i.checkOutputSize(buf.size); err != nil {
+				return err
+			}
+			return v.i.writeOutput(out, buf.String())
+		} else {
+			err = v.i.manifestAndWriteJSON(out, val, true, "")
+		}
+		if err != nil {
+			return err
+		}
+		return v.i.writeOutput(out, "\n")
+	}()
+	if err == nil {
+		// The output which is still buffered is dropped on errors, so that
+		// as little as possible of the incomplete output is written.
+		if flushErr := out.w.Flush(); flushErr != nil {
+			err = &outputWriteError{err: flushErr}
+		}
+	}
+	var writeErr *outputWriteError
+	if errors.As(err, &writeErr) {
+		return writeErr.err
+	}
+	if err != nil {
+		return vm.formatError(err)
+	}
+	return nil
+}
+
+// manifestAndWriteJSON is like manifestAndSerializeJSON, but the output
+// is written as the value is manifested. The output and the errors are the
+// same as from manifestJSON followed by serializeJSON.
+func (i *interpreter) manifestAndWriteJSON(out *outputWriter, v value, multiline bool, indent string) error {
+	if i.stack.currentTrace == (traceElement{}) {
+		panic("manifesting JSON with empty traceElement")
+	}
+
+	// Fresh frame for better stack traces
+	err := i.newCall(environment{}, false)
+	if err != nil {
+		return err
+	}
+	stackSize := len(i.stack.stack)
+	defer i.stack.popIfExists(stackSize)
+
+	switch v := v.(type) {
+
+	case *valueBoolean:
+		if v.value {
+			return i.writeOutput(out, "true")
+		}
+		return i.writeOutput(out, "false")
+
+	case *valueFunction:
+		return makeRuntimeError("couldn't manifest function as JSON", i.getCurrentStackTrace())
+
+	case *valueNumber:
+		return i.writeOutput(out, unparseNumber(v.value))
+
+	case valueString:
+		if err := flattenString(v); err != nil {
+			return err
+		}
+		return i.writeOutput(out, unparseString(v.getGoString()))
+
+	case *valueNull:
+		return i.writeOutput(out, "null")
+
+	case *valueArray:
+		if len(v.elements) == 0 {
+			return i.writeOutput(out, "[ ]")
+		}
+		prefix, indent2 := "[", indent
+		if multiline {
+			prefix, indent2 = "[\n", indent+"   "
+		}
+		for index, th := range v.elements {
+			if err := i.writeOutput(out, prefix+indent2); err != nil {
+				return err
+			}
+			if err := i.writeElement(out, index, th, multiline, indent2); err != nil {
+				return err
+			}
+			if multiline {
+				prefix = ",\n"
+			} else {
+				prefix = ", "
+			}
+		}
+		if multiline {
+			return i.writeOutput(out, "\n"+indent+"]")
+		}
+		return i.writeOutput(out, indent+"]")
+
+	case *valueObject:
+		fieldNames := manifestedFields(v, out.preserveOrder)
+
+		err := i.checkAssertionsForManifestation(v)
+		if err != nil {
+			return err
+		}
+
+		if len(fieldNames) == 0 {
+			return i.writeOutput(out, "{ }")
+		}
+		prefix, indent2 := "{", indent
+		if multiline {
+			prefix, indent2 = "{\n", indent+"   "
+		}
+		for _, fieldName := range fieldNames {
+			if err := i.writeOutput(out, prefix+indent2+unparseString(fieldName)+": "); err != nil {
+				return err
+			}
+			if err := i.writeField(out, v, fieldName, multiline, indent2); err != nil {
+				return err
+			}
+			if multiline {
+				prefix = ",\n"
+			} else {
+				prefix = ", "
+			}
+		}
+		if multiline {
+			return i.writeOutput(out, "\n"+indent+"}")
+		}
+		return i.writeOutput(out, indent+"}")
+
+	default:
+		return makeRuntimeError(
+			fmt.Sprintf("manifesting this value not implemented yet: %s", reflect.TypeOf(v)),
+			i.getCurrentStackTrace(),
+		)
+	}
+}
+
+func (i *interpreter) writeElement(out *outputWriter, index int, th *cachedThunk, multiline bool, indent string) error {
+	msg := ast.MakeLocationRangeMessage(fmt.Sprintf("Array element %d", index))
+	i.stack.setCurrentTrace(traceElement{
+		loc: &msg,
+	})
+	defer i.stack.clearCurrentTrace()
+	elVal, err := i.evaluatePV(th)
+	if err != nil {
+		return err
+	}
+	return i.manifestAndWriteJSON(out, elVal, multiline, indent)
+}
+
+func (i *interpreter) writeField(out *outputWriter, obj *valueObject, fieldName string, multiline bool, indent string) error {
+	msg := ast.MakeLocationRangeMessage(fmt.Sprintf("Field %#v", fieldName))
+	i.stack.setCurrentTrace(traceElement{
+		loc: &msg,
+	})
+	defer i.stack.clearCurrentTrace()
+	fieldVal, err := obj.index(i, fieldName)
+	if err != nil {
+		return err
+	}
+	return i.manifestAndWriteJSON(out, fieldVal, multiline, indent)
+}
+
+// OutputFormat is the format in which the result of an evaluation is
+// manifested (see VM.OutputFormat).
+type OutputFormat int
+
+const (
+	// OutputFormatJSON is the default format.
+	OutputFormatJSON OutputFormat = iota
+	// OutputFormatYAML manifests the result like std.manifestYamlDoc.
+	OutputFormatYAML
+	// OutputFormatTOML manifests the result like std.manifestToml.
+	OutputFormatTOML
+	// OutputFormatINI manifests the result like std.manifestIni.
+	OutputFormatINI
+)
+
+var outputFormatNames = []string{"json", "yaml", "toml", "ini"}
+
+func (f OutputFormat) String() string {
+	if f < 0 || int(f) >= len(outputFormatNames) {
+		return fmt.Sprintf("OutputFormat(%d)", int(f))
+	}
+	return outputFormatNames[f]
+}
+
+// ParseOutputFormat returns the output format with the given name: json,
+// yaml, toml or ini.
+func ParseOutputFormat(name string) (OutputFormat, error) {
+	for f, formatName := range outputFormatNames {
+		if name == formatName {
+			return OutputFormat(f), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown output format %#v, expected one of: %s", name, strings.Join(outputFormatNames, ", "))
+}
+
+// OutputFormatOfFile returns the output format of a file with the given name,
+// based on its extension: .json, .yaml or .yml, .toml and .ini. The second
+// result is false for the other extensions.
+func OutputFormatOfFile(filename string) (OutputFormat, bool) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		return OutputFormatJSON, true
+	case ".yaml", ".yml":
+		return OutputFormatYAML, true
+	case ".toml":
+		return OutputFormatTOML, true
+	case ".ini":
+		return OutputFormatINI, true
+	}
+	return 0, false
+}
+
+// outputFormat is how the results of an evaluation are serialized.
+type outputFormat struct {
+	format OutputFormat
+	// byExtension makes the files in multi mode use the formats of their
+	// extensions.
+	byExtension   bool
+	preserveOrder bool
+	canonical     bool
+}
+
+func (vm *VM) outputFormat() outputFormat {
+	return outputFormat{
+		format:        vm.OutputFormat,
+		byExtension:   vm.OutputFormatByExtension,
+		preserveOrder: vm.PreserveOrder,
+		canonical:     vm.CanonicalJSON,
+	}
+}
+
+// serializeJSON serializes a manifested value of the JSON output, followed
+// by a newline unless it is in the canonical form.
+func (f outputFormat) serializeJSON(v interface{}, buf jsonWriter) {
+	if f.canonical {
+		serializeCanonicalJSON(v, buf)
+		return
+	}
+	serializeJSON(v, true, "", buf)
+	buf.WriteString("\n")
+}
+
+// of returns the format of a file in multi mode.
+func (f outputFormat) of(filename string) OutputFormat {
+	if f.byExtension {
+		if format, known := OutputFormatOfFile(filename); known {
+			return format
+		}
+	}
+	return f.format
+}
+
+// manifestInFormat manifests a value in a format other than JSON, with
+// the builtins manifesting the same formats. The fields of the objects are in
+// the order of their definitions if preserveOrder is set and the format
+// supports it.
+func (i *interpreter) manifestInFormat(v value, format OutputFormat, preserveOrder bool) (string, error) {
+	var result value
+	var err error
+	switch format {
+	case OutputFormatYAML:
+		result, err = builtinManifestYamlDoc(i, []value{v, makeValueBoolean(false), makeValueBoolean(true), makeValueBoolean(preserveOrder)})
+	case OutputFormatTOML:
+		result, err = builtinManifestTomlEx(i, []value{v, makeValueString("  ")})
+	case OutputFormatINI:
+		result, err = builtinManifestIni(i, v)
+	default:
+		panic(fmt.Sprintf("unsupported output format %v", format))
+	}
+	if err != nil {
+		return "", err
+	}
+	return result.(valueString).getGoString(), nil
+}
@@ -0,0 +1,215 @@
+/*
+Copyright 2026 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonnet
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/internal/pprof"
+)
+
+// profilePeriod is how often the profiler takes a sample of the evaluation time.
+const profilePeriod = time.Millisecond
+
+// profileCheckInterval is how often (in evaluation steps) the interpreter looks
+// at the clock to find out whether it is time for a sample.
+const profileCheckInterval = 64
+
+// profileAllocationRate is the number of allocated bytes (as counted for
+// VM.MaxMemory) between the samples of the allocations.
+const profileAllocationRate = 64 * 1024
+
+// Profiler collects a profile of the evaluations of the VMs using it
+// (see VM.SetProfiler), which attributes the evaluation time and allocations
+// to Jsonnet stacks. The profile is written in the pprof format, so it can
+// be inspected with `go tool pprof`, e.g. as a flame graph.
+//
+// The stacks consist of the Jsonnet functions and objects being evaluated.
+// Their locations in the profile are the places where they are used
+// (e.g. the calls of the functions). The manifestation of the output
+// shows up as the paths of the fields being manifested.
+//
+// The evaluation time is sampled about every millisecond. The allocations
+// are the approximate sizes of strings and arrays, as counted for
+// VM.MaxMemory, sampled every 64 KiB. Evaluations of many VMs, also
+// concurrent ones, may be profiled by one Profiler.
+type Profiler struct {
+	start   time.Time
+	stopped atomic.Bool
+
+	mu       sync.Mutex
+	end      time.Time
+	samples  map[string]*profileSample
+	keyBuf   strings.Builder
+	inserted []*profileSample
+}
+
+type profileSample struct {
+	stack []TraceFrame
+	// The number of samples, the evaluation time and the allocated bytes.
+	values [3]int64
+}
+
+// NewProfiler creates a Profiler. Stop should be called when the profiled
+// evaluations are finished.
+func NewProfiler() *Profiler {
+	return &Profiler{
+		start:   time.Now(),
+		samples: make(map[string]*profileSample),
+	}
+}
+
+// Stop stops the profiling. The evaluations still in progress are not
+// sampled anymore.
+func (p *Profiler) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped.Load() {
+		return
+	}
+	p.end = time.Now()
+	p.stopped.Store(true)
+}
+
+// sampleTime records the evaluation time since the last sample of this
+// interpreter, if at least one period has passed. Only every
+// profileCheckInterval-th call actually looks at the clock.
+func (p *Profiler) sampleTime(i *interpreter, a ast.Node) {
+	i.profileSkippedChecks++
+	if i.profileSkippedChecks < profileCheckInterval {
+		return
+	}
+	i.profileSkippedChecks = 0
+	now := time.Now()
+	elapsed := now.Sub(i.profileLastSample)
+	if elapsed < profilePeriod || p.stopped.Load() {
+		return
+	}
+	i.profileLastSample = now
+	p.record(i.profileStack(a), [3]int64{int64(elapsed / profilePeriod), int64(elapsed), 0})
+}
+
+// sampleAllocation counts an allocation and records the allocated bytes
+// when they reach profileAllocationRate.
+func (p *Profiler) sampleAllocation(i *interpreter, size int) {
+	i.profileAllocated += size
+	if i.profileAllocated < profileAllocationRate || p.stopped.Load() {
+		return
+	}
+	p.record(i.profileStack(nil), [3]int64{0, 0, int64(i.profileAllocated)})
+	i.profileAllocated = 0
+}
+
+// profileStack returns the current stack of the interpreter. If a is not nil,
+// it is the node being evaluated, which is the innermost frame.
+func (i *interpreter) profileStack(a ast.Node) []TraceFrame {
+	stack := i.getCurrentStackTrace()
+	if a != nil && a.Loc() != nil && a.Loc().IsSet() {
+		stack = append(stack, traceElementToTraceFrame(traceElement{loc: a.Loc(), context: a.Context()}))
+	}
+	return stack
+}
+
+func (p *Profiler) record(stack []TraceFrame, values [3]int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keyBuf.Reset()
+	for _, frame := range stack {
+		fmt.Fprintf(&p.keyBuf, "%s\x00%d:%d\x00%s\x00", frame.Loc.FileName, frame.Loc.Begin.Line, frame.Loc.Begin.Column, frame.Name)
+	}
+	key := p.keyBuf.String()
+	sample, exists := p.samples[key]
+	if !exists {
+		sample = &profileSample{stack: stack}
+		p.samples[key] = sample
+		p.inserted = append(p.inserted, sample)
+	}
+	for index, v := range values {
+		sample.values[index] += v
+	}
+}
+
+// profileNameReplacer replaces the angle brackets in the names of the Jsonnet
+// functions and objects (e.g. "function <f>"), which pprof would otherwise
+// strip as C++ template arguments.
+var profileNameReplacer = strings.NewReplacer("<", "[", ">", "]")
+
+// profileFrame converts a frame of a Jsonnet stack to a frame of the profile.
+// The functions are named after the files and the Jsonnet functions or
+// objects, because the names of the latter are often not unique.
+func profileFrame(frame TraceFrame) pprof.Frame {
+	if !frame.Loc.IsSet() {
+		// A message, e.g. "During manifestation", or a builtin.
+		function := frame.Loc.FileName
+		if function == "" {
+			function = strings.TrimSpace("builtin " + profileNameReplacer.Replace(frame.Name))
+		}
+		return pprof.Frame{Function: function}
+	}
+	file := frame.Loc.FileName
+	if frame.Loc.File != nil && frame.Loc.File.DiagnosticFileName != "" {
+		file = string(frame.Loc.File.DiagnosticFileName)
+	}
+	function := file
+	if frame.Name != "" {
+		function = file + " " + profileNameReplacer.Replace(frame.Name)
+	}
+	return pprof.Frame{
+		Function: function,
+		File:     file,
+		Line:     int64(frame.Loc.Begin.Line),
+		Column:   int64(frame.Loc.Begin.Column),
+	}
+}
+
+// WriteProfile writes the profile collected so far to w in the pprof format.
+func (p *Profiler) WriteProfile(w io.Writer) error {
+	p.mu.Lock()
+	end := p.end
+	if end.IsZero() {
+		end = time.Now()
+	}
+	profile := &pprof.Profile{
+		SampleTypes: []pprof.ValueType{
+			{Type: "samples", Unit: "count"},
+			{Type: "time", Unit: "nanoseconds"},
+			{Type: "alloc_space", Unit: "bytes"},
+		},
+		DefaultSampleType: "time",
+		PeriodType:        pprof.ValueType{Type: "time", Unit: "nanoseconds"},
+		Period:            int64(profilePeriod),
+		TimeNanos:         p.start.UnixNano(),
+		DurationNanos:     int64(end.Sub(p.start)),
+	}
+	for _, sample := range p.inserted {
+		stack := make([]pprof.Frame, len(sample.stack))
+		for index, frame := range sample.stack {
+			// The innermost frame goes first.
+			stack[len(stack)-1-index] = profileFrame(frame)
+		}
+		values := sample.values
+		profile.Samples = append(profile.Samples, pprof.Sample{Stack: stack, Values: values[:]})
+	}
+	p.mu.Unlock()
+	return profile.Write(w)
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2026 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonnet
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/internal/program"
+)
+
+// Program is a compiled Jsonnet program, i.e. a program which was already
+// parsed, desugared and statically analyzed. It can be evaluated many times,
+// for example with different external variables or top-level arguments,
+// without repeating that work.
+//
+// A Program is immutable. It may be evaluated by multiple VMs, also
+// concurrently.
+type Program struct {
+	node ast.Node
+}
+
+// CompileAnonymousSnippet compiles a string containing Jsonnet code.
+// The result is evaluated as if it was passed to EvaluateAnonymousSnippet.
+//
+// The filename parameter is only used for error messages.
+func (vm *VM) CompileAnonymousSnippet(filename string, snippet string) (p *Program, formattedErr error) {
+	node, err := compileSnippet(ast.DiagnosticFileName(filename), "", snippet)
+	if err != nil {
+		return nil, vm.formatError(err)
+	}
+	return &Program{node: node}, nil
+}
+
+// CompileFile compiles Jsonnet code in a file.
+// The result is evaluated as if it was passed to EvaluateFile.
+//
+// The importer is used to fetch the contents of the file. Note that the files
+// imported by the program are not compiled until they are imported during
+// an evaluation (the import cache of the VM avoids compiling them again).
+func (vm *VM) CompileFile(filename string) (p *Program, formattedErr error) {
+	node, _, err := vm.ImportAST("", filename)
+	if err != nil {
+		return nil, vm.formatError(err)
+	}
+	return &Program{node: node}, nil
+}
+
+func compileSnippet(diagnosticFileName ast.DiagnosticFileName, filename string, snippet string) (node ast.Node, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("(CRASH) %v\n%s", r, debug.Stack())
+		}
+	}()
+	return program.SnippetToAST(diagnosticFileName, filename, snippet)
+}
+
+// EvaluateProgram evaluates a compiled program and returns a JSON string.
+func (vm *VM) EvaluateProgram(p *Program) (json string, formattedErr error) {
+	return vm.EvaluateProgramContext(context.Background(), p)
+}
+
+// EvaluateProgramContext is like EvaluateProgram, but the evaluation is aborted
+// when ctx is cancelled or its deadline passes. In that case the returned
+// error wraps ctx.Err().
+func (vm *VM) EvaluateProgramContext(ctx context.Context, p *Program) (json string, formattedErr error) {
+	output, err := vm.evaluateNode(ctx, p.node, evalKindRegular)
+	if err != nil {
+		return "", vm.formatError(err)
+	}
+	return output.(string), nil
+}
+
+// EvaluateProgramStream evaluates a compiled program to an array.
+// The array is returned as an array of JSON strings.
+func (vm *VM) EvaluateProgramStream(p *Program) (docs []string, formattedErr error) {
+	return vm.EvaluateProgramStreamContext(context.Background(), p)
+}
+
+// EvaluateProgramStreamContext is like EvaluateProgramStream, but the evaluation
+// is aborted when ctx is cancelled or its deadline passes. In that case the
+// returned error wraps ctx.Err().
+func (vm *VM) EvaluateProgramStreamContext(ctx context.Context, p *Program) (docs []string, formattedErr error) {
+	output, err := vm.evaluateNode(ctx, p.node, evalKindStream)
+	if err != nil {
+		return nil, vm.formatError(err)
+	}
+	return output.([]string), nil
+}
+
+// EvaluateProgramMulti evaluates a compiled program to key-value pairs.
+// The keys are field name strings and the values are JSON strings.
+func (vm *VM) EvaluateProgramMulti(p *Program) (files map[string]string, formattedErr error) {
+	return vm.EvaluateProgramMultiContext(context.Background(), p)
+}
+
+// EvaluateProgramMultiContext is like EvaluateProgramMulti, but the evaluation
+// is aborted when ctx is cancelled or its deadline passes. In that case the
+// returned error wraps ctx.Err().
+func (vm *VM) EvaluateProgramMultiContext(ctx context.Context, p *Program) (files map[string]string, formattedErr error) {
+	output, err := vm.evaluateNode(ctx, p.node, evalKindMulti)
+	if err != nil {
+		return nil, vm.formatError(err)
+	}
+	return output.(map[string]string), nil
+}
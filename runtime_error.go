@@ -16,12 +16,20 @@ limitations under the License.
 
 package jsonnet
 
-import "github.com/google/go-jsonnet/ast"
+import (
+	"fmt"
+
+	"github.com/google/go-jsonnet/ast"
+)
 
 // RuntimeError is an error discovered during evaluation of the program
 type RuntimeError struct {
 	Msg        string
 	StackTrace []TraceFrame
+
+	// cause is the underlying Go error, if there is one. For example, when
+	// the evaluation is cancelled it is the error of the context.
+	cause error
 }
 
 func makeRuntimeError(msg string, stackTrace []TraceFrame) RuntimeError {
@@ -31,10 +39,51 @@ func makeRuntimeError(msg string, stackTrace []TraceFrame) RuntimeError {
 	}
 }
 
+// makeCancellationError creates the error returned when the evaluation was
+// aborted, because its context was cancelled or its deadline has passed.
+func makeCancellationError(ctxErr error, stackTrace []TraceFrame) RuntimeError {
+	return RuntimeError{
+		Msg:        fmt.Sprintf("evaluation aborted: %v", ctxErr),
+		StackTrace: stackTrace,
+		cause:      ctxErr,
+	}
+}
+
+// LimitExceededError is the cause of the RuntimeError returned when
+// the evaluation exceeds one of the resource limits configured on the VM.
+// The location is available in the stack trace of the RuntimeError.
+type LimitExceededError struct {
+	// Limit is the name of the VM field configuring the exceeded limit,
+	// e.g. "MaxSteps".
+	Limit string
+	// Max is the configured value of the limit.
+	Max int
+}
+
+func (err *LimitExceededError) Error() string {
+	return fmt.Sprintf("%s limit of %d exceeded", err.Limit, err.Max)
+}
+
+func makeLimitError(limit string, max int, stackTrace []TraceFrame) RuntimeError {
+	cause := &LimitExceededError{Limit: limit, Max: max}
+	return RuntimeError{
+		Msg:        cause.Error(),
+		StackTrace: stackTrace,
+		cause:      cause,
+	}
+}
+
 func (err RuntimeError) Error() string {
 	return "RUNTIME ERROR: " + err.Msg
 }
 
+// Unwrap returns the underlying cause of the error, if any. In particular,
+// errors.Is(err, context.Canceled) and errors.Is(err, context.DeadlineExceeded)
+// report whether the evaluation was aborted by its context.
+func (err RuntimeError) Unwrap() error {
+	return err.cause
+}
+
 // The stack
 
 // TraceFrame is tracing information about a single frame of the call stack.
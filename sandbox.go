@@ -0,0 +1,177 @@
+/*
+Copyright 2026 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonnet
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// SandboxPolicy restricts what the evaluated code may access, so that
+// untrusted code can be evaluated (see VM.SetSandboxPolicy). The violations
+// are reported as runtime errors at the offending expressions.
+//
+// The zero value allows everything, each field adds a restriction.
+type SandboxPolicy struct {
+	// DisableImportStr forbids importstr.
+	DisableImportStr bool
+	// DisableImportBin forbids importbin.
+	DisableImportBin bool
+
+	// ImportRoots, if not empty, are the only directories from which files
+	// may be imported (with import, importstr or importbin). The location
+	// of an imported file, as returned by the importer, must be inside one
+	// of them, after the symbolic links are resolved. This is meant for
+	// FileImporter, other importers must return file paths as the locations
+	// for the imports to be allowed. FileImporter, ArchiveImporter and
+	// JsonnetBundleImporter check the locations before opening the files,
+	// so nothing is read from the files outside of the roots, and an archive
+	// outside of the roots fails all the imports which get to it.
+	ImportRoots []string
+	// DisallowAbsoluteImports forbids absolute import paths.
+	DisallowAbsoluteImports bool
+	// DisallowParentImports forbids import paths which go up from
+	// the directory they are resolved against with "..", e.g. "../x.libsonnet".
+	// Paths like "a/../b.libsonnet" are allowed.
+	DisallowParentImports bool
+
+	// DisabledNativeFunctions are the names of the native functions which
+	// std.native refuses to return.
+	DisabledNativeFunctions []string
+
+	// RestrictExtVars makes std.extVar fail for the external variables which
+	// are not listed in AllowedExtVars.
+	RestrictExtVars bool
+	AllowedExtVars  []string
+}
+
+// sandbox is a SandboxPolicy prepared for the checks during evaluation.
+// A nil *sandbox allows everything.
+type sandbox struct {
+	policy          SandboxPolicy
+	importRoots     []string
+	disabledNatives map[string]bool
+	allowedExtVars  map[string]bool
+}
+
+func makeSandbox(policy *SandboxPolicy) *sandbox {
+	s := &sandbox{
+		policy:          *policy,
+		disabledNatives: make(map[string]bool),
+		allowedExtVars:  make(map[string]bool),
+	}
+	for _, root := range policy.ImportRoots {
+		s.importRoots = append(s.importRoots, resolveSandboxPath(root))
+	}
+	for _, name := range policy.DisabledNativeFunctions {
+		s.disabledNatives[name] = true
+	}
+	for _, name := range policy.AllowedExtVars {
+		s.allowedExtVars[name] = true
+	}
+	return s
+}
+
+// resolveSandboxPath makes a path absolute and resolves the symbolic links
+// in it, as far as possible.
+func resolveSandboxPath(p string) string {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		abs = filepath.Clean(p)
+	}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		return resolved
+	}
+	return abs
+}
+
+// checkImportPath checks an import path before it is imported.
+func (s *sandbox) checkImportPath(kind string, importedPath string) error {
+	if s == nil {
+		return nil
+	}
+	if kind == "importstr" && s.policy.DisableImportStr || kind == "importbin" && s.policy.DisableImportBin {
+		return fmt.Errorf("%s is disabled by the sandbox policy", kind)
+	}
+	if s.policy.DisallowAbsoluteImports && (filepath.IsAbs(importedPath) || path.IsAbs(filepath.ToSlash(importedPath))) {
+		return fmt.Errorf("%s of %#v is not allowed by the sandbox policy: absolute paths are forbidden", kind, importedPath)
+	}
+	if s.policy.DisallowParentImports {
+		cleaned := path.Clean(filepath.ToSlash(importedPath))
+		if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+			return fmt.Errorf("%s of %#v is not allowed by the sandbox policy: paths leading to parent directories are forbidden", kind, importedPath)
+		}
+	}
+	return nil
+}
+
+// importLocationCheckKey is the key of the context value with the check of
+// the locations of the imported files (see checkImportLocation).
+type importLocationCheckKey struct{}
+
+// importContext returns the context for an import, which makes the importers
+// check the locations against the import roots before opening the files.
+func (s *sandbox) importContext(ctx context.Context, kind string, importedPath string) context.Context {
+	if s == nil || len(s.importRoots) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, importLocationCheckKey{}, func(foundAt string) error {
+		return s.checkImported(kind, importedPath, foundAt)
+	})
+}
+
+// checkImportLocation checks a location of an imported file before
+// the importer opens it. The error must not be cached by the importer,
+// as it depends on the evaluation.
+func checkImportLocation(ctx context.Context, foundAt string) error {
+	check, _ := ctx.Value(importLocationCheckKey{}).(func(string) error)
+	if check == nil {
+		return nil
+	}
+	return check(foundAt)
+}
+
+// checkImported checks the location of an imported file.
+func (s *sandbox) checkImported(kind string, importedPath string, foundAt string) error {
+	if s == nil || len(s.importRoots) == 0 {
+		return nil
+	}
+	resolved := resolveSandboxPath(foundAt)
+	for _, root := range s.importRoots {
+		if rel, err := filepath.Rel(root, resolved); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s of %#v is not allowed by the sandbox policy: %#v is outside of the import roots", kind, importedPath, foundAt)
+}
+
+func (s *sandbox) checkNative(name string) error {
+	if s == nil || !s.disabledNatives[name] {
+		return nil
+	}
+	return fmt.Errorf("native function %#v is disabled by the sandbox policy", name)
+}
+
+func (s *sandbox) checkExtVar(name string) error {
+	if s == nil || !s.policy.RestrictExtVars || s.allowedExtVars[name] {
+		return nil
+	}
+	return fmt.Errorf("external variable %#v is not allowed by the sandbox policy", name)
+}
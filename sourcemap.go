@@ -0,0 +1,310 @@
+/*
+Copyright 2026 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonnet
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/internal/program"
+	"github.com/google/go-jsonnet/toolutils"
+)
+
+// SourceMap tells where the values in the output of an evaluation come from.
+// It has an entry for every value in the output (the whole output, the fields
+// of the objects and the elements of the arrays), in the order of the output.
+type SourceMap []SourceMapEntry
+
+// SourceMapEntry is the provenance of a value in the output.
+type SourceMapEntry struct {
+	// Path is the place of the value in the output as a JSON Pointer
+	// (RFC 6901), e.g. "/spec/containers/0/image". It is "" for the whole
+	// output.
+	Path string
+	// Loc is the location of the expression which produced the value: the body
+	// of the field, the element of the array or the whole program. It is not
+	// set if the value was not produced by code, e.g. for the fields of the
+	// objects returned by std.parseJson.
+	Loc ast.LocationRange
+	// Definitions are the definitions of the field in the objects combined
+	// with + (the mixins), from the rightmost one, which overrides the others,
+	// to the leftmost one. They are empty for the array elements and the whole
+	// output.
+	Definitions []FieldDefinition
+}
+
+// FieldDefinition is a definition of a field in one of the objects combined
+// with +.
+type FieldDefinition struct {
+	// Loc is the location of the body of the field.
+	Loc ast.LocationRange
+	// Hide is the visibility of the field (:, :: or :::).
+	Hide ast.ObjectFieldHide
+	// PlusSuper is set if the field is defined with +:, so that its value
+	// is added to the one of the field in the objects on the left.
+	PlusSuper bool
+	// UsesSuper is set if the body of the field refers to super, e.g. with
+	// super.f or "f" in super.
+	UsesSuper bool
+}
+
+// objectFieldDefinitions returns the definitions of a field of an object,
+// from the one which overrides the others.
+func objectFieldDefinitions(obj *valueObject, fieldName string) []FieldDefinition {
+	var definitions []FieldDefinition
+	for _, definition := range fieldDefinitions(obj.uncached, fieldName) {
+		body := fieldBody(definition.field)
+		definitions = append(definitions, FieldDefinition{
+			Loc:       *definition.field.loc(),
+			Hide:      definition.hide,
+			PlusSuper: isPlusSuper(definition.field),
+			UsesSuper: body != nil && usesSuper(body),
+		})
+	}
+	return definitions
+}
+
+// usesSuper tells whether code refers to super, not counting the nested
+// objects, which have their own super.
+func usesSuper(node ast.Node) bool {
+	switch node.(type) {
+	case *ast.SuperIndex, *ast.InSuper:
+		return true
+	case *ast.DesugaredObject:
+		return false
+	}
+	for _, child := range toolutils.Children(node) {
+		if usesSuper(child) {
+			return true
+		}
+	}
+	return false
+}
+
+// EvaluateFileWithSourceMap evaluates Jsonnet code in a file like
+// EvaluateFile, and also returns the source map of the output.
+//
+// The importer is used to fetch the contents of the file.
+func (vm *VM) EvaluateFileWithSourceMap(filename string) (json string, sourceMap SourceMap, formattedErr error) {
+	node, _, err := vm.importCache.importAST(context.Background(), "", filename)
+	if err != nil {
+		return "", nil, vm.formatError(err)
+	}
+	return vm.evaluateWithSourceMap(node)
+}
+
+// EvaluateAnonymousSnippetWithSourceMap evaluates a string containing Jsonnet
+// code like EvaluateAnonymousSnippet, and also returns the source map of
+// the output.
+//
+// The filename parameter is only used for error messages.
+func (vm *VM) EvaluateAnonymousSnippetWithSourceMap(filename string, snippet string) (json string, sourceMap SourceMap, formattedErr error) {
+	node, err := program.SnippetToAST(ast.DiagnosticFileName(filename), "", snippet)
+	if err != nil {
+		return "", nil, vm.formatError(err)
+	}
+	return vm.evaluateWithSourceMap(node)
+}
+
+func (vm *VM) evaluateWithSourceMap(node ast.Node) (string, SourceMap, error) {
+	output, err := vm.evaluateNode(context.Background(), node, evalKindValue)
+	var buf bytes.Buffer
+	if err := vm.writeResult(&buf, output, err); err != nil {
+		return "", nil, err
+	}
+	// The values were evaluated and cached during manifestation, so this
+	// only looks them up.
+	var sourceMap SourceMap
+	v := output.(Value)
+	err = v.inEvaluation(func() error {
+		val, err := v.force(v.i)
+		if err != nil {
+			return err
+		}
+		return v.i.recordSourceMap(&sourceMap, val, "", *node.Loc(), nil, vm.PreserveOrder)
+	})
+	if err != nil {
+		return "", nil, vm.formatError(err)
+	}
+	return buf.String(), sourceMap, nil
+}
+
+// recordSourceMap appends the entries of a manifested value and the values in
+// it to the source map.
+func (i *interpreter) recordSourceMap(sourceMap *SourceMap, v value, path string, loc ast.LocationRange, definitions []FieldDefinition, preserveOrder bool) error {
+	*sourceMap = append(*sourceMap, SourceMapEntry{Path: path, Loc: loc, Definitions: definitions})
+
+	err := i.newCall(environment{}, false)
+	if err != nil {
+		return err
+	}
+	stackSize := len(i.stack.stack)
+	defer i.stack.popIfExists(stackSize)
+
+	switch v := v.(type) {
+	case *valueArray:
+		for index, th := range v.elements {
+			msg := ast.MakeLocationRangeMessage(fmt.Sprintf("Array element %d", index))
+			i.stack.setCurrentTrace(traceElement{
+				loc: &msg,
+			})
+			elem, err := i.evaluatePV(th)
+			if err != nil {
+				i.stack.clearCurrentTrace()
+				return err
+			}
+			// The elements not produced by code, e.g. by std.split, are
+			// attributed to the array.
+			elemLoc := loc
+			if th.body != nil {
+				elemLoc = *th.body.Loc()
+			}
+			err = i.recordSourceMap(sourceMap, elem, path+"/"+strconv.Itoa(index), elemLoc, nil, preserveOrder)
+			i.stack.clearCurrentTrace()
+			if err != nil {
+				return err
+			}
+		}
+
+	case *valueObject:
+		for _, fieldName := range manifestedFields(v, preserveOrder) {
+			msg := ast.MakeLocationRangeMessage(fmt.Sprintf("Field %#v", fieldName))
+			i.stack.setCurrentTrace(traceElement{
+				loc: &msg,
+			})
+			field, err := v.index(i, fieldName)
+			if err != nil {
+				i.stack.clearCurrentTrace()
+				return err
+			}
+			definitions := objectFieldDefinitions(v, fieldName)
+			fieldPath := path + "/" + escapeJSONPointer(fieldName)
+			err = i.recordSourceMap(sourceMap, field, fieldPath, definitions[0].Loc, definitions, preserveOrder)
+			i.stack.clearCurrentTrace()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+var jsonPointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+func escapeJSONPointer(fieldName string) string {
+	return jsonPointerEscaper.Replace(fieldName)
+}
+
+type sourceMapPosition struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+type sourceMapLocation struct {
+	File  string            `json:"file"`
+	Begin sourceMapPosition `json:"begin"`
+	End   sourceMapPosition `json:"end"`
+}
+
+type sourceMapDefinition struct {
+	Location   *sourceMapLocation `json:"location"`
+	Visibility string             `json:"visibility"`
+	PlusSuper  bool               `json:"plus_super"`
+	UsesSuper  bool               `json:"uses_super"`
+}
+
+type sourceMapEntryJSON struct {
+	Location    *sourceMapLocation    `json:"location"`
+	Definitions []sourceMapDefinition `json:"definitions,omitempty"`
+}
+
+func makeSourceMapLocation(loc ast.LocationRange) *sourceMapLocation {
+	if !loc.IsSet() {
+		return nil
+	}
+	file := loc.FileName
+	if file == "" && loc.File != nil {
+		file = string(loc.File.DiagnosticFileName)
+	}
+	return &sourceMapLocation{
+		File:  file,
+		Begin: sourceMapPosition{Line: loc.Begin.Line, Column: loc.Begin.Column},
+		End:   sourceMapPosition{Line: loc.End.Line, Column: loc.End.Column},
+	}
+}
+
+func visibilityString(hide ast.ObjectFieldHide) string {
+	switch hide {
+	case ast.ObjectFieldHidden:
+		return "::"
+	case ast.ObjectFieldVisible:
+		return ":::"
+	default:
+		return ":"
+	}
+}
+
+// MarshalJSON encodes the source map as an object mapping the paths to
+// the locations, in the order of the output, e.g.
+//
+//	{
+//	   "/replicas": {
+//	      "location": {"file": "app.jsonnet", "begin": {"line": 3, "column": 14}, "end": {"line": 3, "column": 15}},
+//	      "definitions": [
+//	         {"location": {...}, "visibility": ":", "plus_super": false, "uses_super": false},
+//	         {"location": {...}, "visibility": ":", "plus_super": false, "uses_super": false}
+//	      ]
+//	   }
+//	}
+//
+// The locations which are not set are null.
+func (m SourceMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for index, entry := range m {
+		if index > 0 {
+			buf.WriteByte(',')
+		}
+		path, err := json.Marshal(entry.Path)
+		if err != nil {
+			return nil, err
+		}
+		encoded := sourceMapEntryJSON{Location: makeSourceMapLocation(entry.Loc)}
+		for _, definition := range entry.Definitions {
+			encoded.Definitions = append(encoded.Definitions, sourceMapDefinition{
+				Location:   makeSourceMapLocation(definition.Loc),
+				Visibility: visibilityString(definition.Hide),
+				PlusSuper:  definition.PlusSuper,
+				UsesSuper:  definition.UsesSuper,
+			})
+		}
+		value, err := json.Marshal(encoded)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(path)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -171,6 +171,33 @@ func (f *plusSuperUnboundField) loc() *ast.LocationRange {
 	return f.inner.loc()
 }
 
+// fieldBody returns the code of a field, nil if it was not defined by code.
+func fieldBody(f unboundField) ast.Node {
+	switch f := f.(type) {
+	case *codeUnboundField:
+		return f.body
+	case *plusSuperUnboundField:
+		return fieldBody(f.inner)
+	case *bindingsUnboundField:
+		return fieldBody(f.inner)
+	default:
+		return nil
+	}
+}
+
+// isPlusSuper tells whether a field is defined with +:, so that its value
+// is added to the one from super.
+func isPlusSuper(f unboundField) bool {
+	switch f := f.(type) {
+	case *plusSuperUnboundField:
+		return true
+	case *bindingsUnboundField:
+		return isPlusSuper(f.inner)
+	default:
+		return false
+	}
+}
+
 // evalCallables
 // -------------------------------------
 
@@ -287,7 +314,7 @@ func (native *NativeFunction) evalCall(arguments callArguments, i *interpreter)
 	if err != nil {
 		return nil, i.Error(err.Error())
 	}
-	return jsonToValue(i, resultJSON)
+	return jsonToAllocatedValue(i, resultJSON)
 }
 
 // Parameters returns a NativeFunction's parameters.
@@ -19,6 +19,7 @@ package jsonnet
 import (
 	"errors"
 	"fmt"
+	"sort"
 
 	"github.com/google/go-jsonnet/ast"
 )
@@ -121,6 +122,9 @@ type valueStringTree struct {
 	valueBase
 	left, right valueString
 	len         int
+	// i is the interpreter which counts the flattened string against
+	// the memory limit, if any.
+	i *interpreter
 }
 
 func buildFullString(s valueString, buf *[]rune) {
@@ -133,7 +137,22 @@ func buildFullString(s valueString, buf *[]rune) {
 	}
 }
 
-func (s *valueStringTree) flattenToLeft() {
+// flattenToLeft flattens the string and counts the flattened string against
+// the memory limit. The string is left as it is when the limit is exceeded.
+func (s *valueStringTree) flattenToLeft() error {
+	if s.right == nil {
+		return nil
+	}
+	if s.i != nil {
+		if err := s.i.allocateString(s.len); err != nil {
+			return err
+		}
+	}
+	s.flatten()
+	return nil
+}
+
+func (s *valueStringTree) flatten() {
 	if s.right != nil {
 		result := make([]rune, 0, s.len)
 		buildFullString(s, &result)
@@ -142,21 +161,34 @@ func (s *valueStringTree) flattenToLeft() {
 	}
 }
 
+// flattenString flattens a string built by concatenation (see
+// valueStringTree.flattenToLeft), before its characters are accessed.
+func flattenString(s valueString) error {
+	if tree, isTree := s.(*valueStringTree); isTree {
+		return tree.flattenToLeft()
+	}
+	return nil
+}
+
 func (s *valueStringTree) index(i *interpreter, index int) (value, error) {
 	if 0 <= index && index < s.len {
-		s.flattenToLeft()
+		if err := s.flattenToLeft(); err != nil {
+			return nil, err
+		}
 		return s.left.index(i, index)
 	}
 	return nil, i.Error(fmt.Sprintf("Index %d out of bounds, not within [0, %v)", index, s.length()))
 }
 
+// getRunes and getGoString flatten the string if it was not flattened
+// already, e.g. by interpreter.getString, without counting it.
 func (s *valueStringTree) getRunes() []rune {
-	s.flattenToLeft()
+	s.flatten()
 	return s.left.getRunes()
 }
 
 func (s *valueStringTree) getGoString() string {
-	s.flattenToLeft()
+	s.flatten()
 	return s.left.getGoString()
 }
 
@@ -541,8 +573,12 @@ type objectLocal struct {
 type simpleObject struct {
 	upValues bindingFrame
 	fields   simpleObjectFieldMap
-	asserts  []unboundField
-	locals   []objectLocal
+	// order are the names of the fields in the order of their definitions,
+	// nil if they have no particular order (e.g. for the objects built
+	// from Go maps).
+	order   []string
+	asserts []unboundField
+	locals  []objectLocal
 }
 
 func checkAssertionsHelper(i *interpreter, obj *valueObject, curr uncachedObject, superDepth int) error {
@@ -590,12 +626,13 @@ func (*simpleObject) inheritanceSize() int {
 	return 1
 }
 
-func makeValueSimpleObject(b bindingFrame, fields simpleObjectFieldMap, asserts []unboundField, locals []objectLocal) *valueObject {
+func makeValueSimpleObject(b bindingFrame, fields simpleObjectFieldMap, order []string, asserts []unboundField, locals []objectLocal) *valueObject {
 	return &valueObject{
 		cache: make(map[objectCacheKey]value),
 		uncached: &simpleObject{
 			upValues: b,
 			fields:   fields,
+			order:    order,
 			asserts:  asserts,
 			locals:   locals,
 		},
@@ -777,6 +814,75 @@ func objectFields(obj *valueObject, h hidden) []string {
 	return r
 }
 
+// appendFieldsInOrder appends the names of the fields of obj which are not
+// in seen yet to names, in the order of their definitions. The fields of
+// the objects without a particular order are appended in the sorted order.
+func appendFieldsInOrder(obj uncachedObject, names []string, seen map[string]bool) []string {
+	switch obj := obj.(type) {
+	case *extendedObject:
+		names = appendFieldsInOrder(obj.left, names, seen)
+		return appendFieldsInOrder(obj.right, names, seen)
+	case *simpleObject:
+		order := obj.order
+		if order == nil {
+			for fieldName := range obj.fields {
+				order = append(order, fieldName)
+			}
+			sort.Strings(order)
+		}
+		for _, fieldName := range order {
+			if !seen[fieldName] {
+				seen[fieldName] = true
+				names = append(names, fieldName)
+			}
+		}
+	}
+	return names
+}
+
+// objectFieldsInOrder returns the field names of an object in the order of
+// their definitions. With inheritance, the fields of the left object go first
+// and the fields overridden by the right one keep their places.
+func objectFieldsInOrder(obj *valueObject, h hidden) []string {
+	visibility := objectFieldsVisibility(obj)
+	var r []string
+	for _, fieldName := range appendFieldsInOrder(obj.uncached, nil, make(map[string]bool)) {
+		if h == withHidden || visibility[fieldName] != ast.ObjectFieldHidden {
+			r = append(r, fieldName)
+		}
+	}
+	return r
+}
+
+// manifestedFields returns the names of the visible fields of an object
+// in the order of manifestation: sorted, or in the order of their definitions
+// if preserveOrder is set.
+func manifestedFields(obj *valueObject, preserveOrder bool) []string {
+	if preserveOrder {
+		return objectFieldsInOrder(obj, withoutHidden)
+	}
+	fieldNames := objectFields(obj, withoutHidden)
+	sort.Strings(fieldNames)
+	return fieldNames
+}
+
 func duplicateFieldNameErrMsg(fieldName string) string {
 	return fmt.Sprintf("Duplicate field name: %s", unparseString(fieldName))
 }
+
+// fieldDefinitions returns the definitions of a field in the objects combined
+// with +, from the rightmost one, which overrides the others, to the leftmost
+// one.
+func fieldDefinitions(curr uncachedObject, f string) []simpleObjectField {
+	switch curr := curr.(type) {
+	case *extendedObject:
+		return append(fieldDefinitions(curr.right, f), fieldDefinitions(curr.left, f)...)
+	case *simpleObject:
+		if field, ok := curr.fields[f]; ok {
+			return []simpleObjectField{field}
+		}
+		return nil
+	default:
+		panic(fmt.Sprintf("Unknown object type %#v", curr))
+	}
+}
@@ -0,0 +1,337 @@
+/*
+Copyright 2026 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sort"
+
+	"github.com/google/go-jsonnet/ast"
+)
+
+// Value is a Jsonnet value, which is evaluated only when needed.
+//
+// Values are returned by VM.EvaluateToValue and friends, which do not
+// manifest the result, so that only the parts of it which are actually
+// accessed are evaluated. Values are also passed to and returned from
+// a TypedNativeFunction; the Values passed to it may only be used during
+// the call (or returned from it).
+//
+// The Values of one evaluation must not be used concurrently, because
+// evaluating them mutates shared state. The errors returned by the methods
+// are formatted by the ErrorFormatter of the VM, like the errors of
+// EvaluateFileToValue, except during a call of a native function, where they
+// are returned to the evaluation as they are.
+//
+// The Values created by the functions like JSONValue cannot be inspected,
+// they can only be returned from native functions or passed to them.
+type Value struct {
+	// The interpreter of the evaluation, nil for the Values created in Go.
+	i     *interpreter
+	thunk *cachedThunk
+	// build creates the value, for the Values created in Go.
+	build func(i *interpreter) (value, error)
+}
+
+// EvaluateFileToValue evaluates Jsonnet code in a file and returns the result
+// as a Value. Unlike EvaluateFile, it does not manifest the result, so only
+// the parts of it which are accessed are evaluated (and errors in the
+// other parts are not reported).
+//
+// The importer is used to fetch the contents of the file.
+func (vm *VM) EvaluateFileToValue(filename string) (v Value, formattedErr error) {
+	output, err := vm.evaluateFile(context.Background(), filename, evalKindValue)
+	if err != nil {
+		return Value{}, vm.formatError(err)
+	}
+	return output.(Value), nil
+}
+
+// EvaluateAnonymousSnippetToValue evaluates a string containing Jsonnet code
+// and returns the result as a Value, like EvaluateFileToValue.
+//
+// The filename parameter is only used for error messages.
+func (vm *VM) EvaluateAnonymousSnippetToValue(filename string, snippet string) (v Value, formattedErr error) {
+	output, err := vm.evaluateSnippet(context.Background(), ast.DiagnosticFileName(filename), "", snippet, evalKindValue)
+	if err != nil {
+		return Value{}, vm.formatError(err)
+	}
+	return output.(Value), nil
+}
+
+// EvaluateProgramToValue evaluates a compiled program and returns the result
+// as a Value, like EvaluateFileToValue.
+func (vm *VM) EvaluateProgramToValue(p *Program) (v Value, formattedErr error) {
+	output, err := vm.evaluateNode(context.Background(), p.node, evalKindValue)
+	if err != nil {
+		return Value{}, vm.formatError(err)
+	}
+	return output.(Value), nil
+}
+
+var errUnboundValue = errors.New("the value was not created by an evaluation, it cannot be inspected")
+
+func (v Value) force(i *interpreter) (value, error) {
+	switch {
+	case v.thunk != nil:
+		return i.evaluatePV(v.thunk)
+	case v.build != nil:
+		return v.build(i)
+	default:
+		return nil, i.Error("uninitialized jsonnet.Value")
+	}
+}
+
+func (v Value) toThunk(i *interpreter) *cachedThunk {
+	if v.thunk != nil {
+		return v.thunk
+	}
+	val, err := v.force(i)
+	if err != nil {
+		return &cachedThunk{err: err}
+	}
+	return readyThunk(val)
+}
+
+// evaluate evaluates the value within its evaluation.
+func (v Value) evaluate() (val value, err error) {
+	err = v.inEvaluation(func() error {
+		val, err = v.force(v.i)
+		return err
+	})
+	return
+}
+
+// inEvaluation runs f, which may evaluate code, within the evaluation
+// the value belongs to. After the evaluation, the error is formatted.
+func (v Value) inEvaluation(f func() error) (err error) {
+	if v.i == nil {
+		return errUnboundValue
+	}
+	if v.i.stack.currentTrace != (traceElement{}) {
+		// Within a call of a native function.
+		return f()
+	}
+	// After the evaluation finished.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("(CRASH) %v\n%s", r, debug.Stack())
+		}
+		if err != nil && v.i.errorFormatter != nil {
+			err = &formattedError{msg: v.i.errorFormatter.Format(err), err: err}
+		}
+	}()
+	loc := ast.MakeLocationRangeMessage("During inspection of the result")
+	v.i.stack.setCurrentTrace(traceElement{loc: &loc})
+	defer v.i.stack.clearCurrentTrace()
+	return f()
+}
+
+// Type evaluates the value and returns its type, as returned by std.type.
+func (v Value) Type() (string, error) {
+	val, err := v.evaluate()
+	if err != nil {
+		return "", err
+	}
+	return val.getType().name, nil
+}
+
+// Manifest evaluates the value completely and returns it as JSON,
+// represented in the same way as the arguments of a NativeFunction.
+func (v Value) Manifest() (interface{}, error) {
+	var json interface{}
+	err := v.inEvaluation(func() error {
+		val, err := v.force(v.i)
+		if err != nil {
+			return err
+		}
+		json, err = v.i.manifestJSON(val)
+		return err
+	})
+	return json, err
+}
+
+// Call calls the value, which must be a function, with positional arguments.
+func (v Value) Call(args ...Value) (Value, error) {
+	var result value
+	err := v.inEvaluation(func() error {
+		val, err := v.force(v.i)
+		if err != nil {
+			return err
+		}
+		f, err := v.i.getFunction(val)
+		if err != nil {
+			return err
+		}
+		positional := make([]*cachedThunk, len(args))
+		for index, arg := range args {
+			positional[index] = arg.toThunk(v.i)
+		}
+		result, err = f.call(v.i, callArguments{positional: positional})
+		return err
+	})
+	if err != nil {
+		return Value{}, err
+	}
+	return Value{i: v.i, thunk: readyThunk(result)}, nil
+}
+
+// Field evaluates a field of an object and returns its value. Hidden fields
+// are accessible as well.
+func (v Value) Field(name string) (Value, error) {
+	obj, err := v.object()
+	if err != nil {
+		return Value{}, err
+	}
+	var fieldVal value
+	err = v.inEvaluation(func() error {
+		fieldVal, err = obj.index(v.i, name)
+		return err
+	})
+	if err != nil {
+		return Value{}, err
+	}
+	return Value{i: v.i, thunk: readyThunk(fieldVal)}, nil
+}
+
+// FieldHidden reports whether a field of an object is hidden.
+func (v Value) FieldHidden(name string) (bool, error) {
+	obj, err := v.object()
+	if err != nil {
+		return false, err
+	}
+	hide, exists := objectFieldsVisibility(obj)[name]
+	if !exists {
+		return false, v.inEvaluation(func() error {
+			return v.i.Error(fmt.Sprintf("Field does not exist: %s", name))
+		})
+	}
+	return hide == ast.ObjectFieldHidden, nil
+}
+
+// FieldNames returns the sorted names of the fields of an object,
+// optionally including the hidden ones.
+func (v Value) FieldNames(includeHidden bool) ([]string, error) {
+	obj, err := v.object()
+	if err != nil {
+		return nil, err
+	}
+	names := objectFields(obj, withHiddenFromBool(includeHidden))
+	sort.Strings(names)
+	return names, nil
+}
+
+// Len returns the number of elements of an array.
+func (v Value) Len() (int, error) {
+	arr, err := v.array()
+	if err != nil {
+		return 0, err
+	}
+	return arr.length(), nil
+}
+
+// Index returns an element of an array. The element is not evaluated.
+func (v Value) Index(index int) (Value, error) {
+	arr, err := v.array()
+	if err != nil {
+		return Value{}, err
+	}
+	if index < 0 || index >= arr.length() {
+		return Value{}, v.inEvaluation(func() error {
+			return v.i.Error(fmt.Sprintf("Index %d out of bounds, not within [0, %v)", index, arr.length()))
+		})
+	}
+	return Value{i: v.i, thunk: arr.elements[index]}, nil
+}
+
+// AsString evaluates a string and returns it.
+func (v Value) AsString() (result string, err error) {
+	err = v.inEvaluation(func() error {
+		val, err := v.force(v.i)
+		if err != nil {
+			return err
+		}
+		str, err := v.i.getString(val)
+		if err != nil {
+			return err
+		}
+		result = str.getGoString()
+		return nil
+	})
+	return
+}
+
+// AsNumber evaluates a number and returns it.
+func (v Value) AsNumber() (result float64, err error) {
+	err = v.inEvaluation(func() error {
+		val, err := v.force(v.i)
+		if err != nil {
+			return err
+		}
+		num, err := v.i.getNumber(val)
+		if err != nil {
+			return err
+		}
+		result = num.value
+		return nil
+	})
+	return
+}
+
+// AsBool evaluates a boolean and returns it.
+func (v Value) AsBool() (result bool, err error) {
+	err = v.inEvaluation(func() error {
+		val, err := v.force(v.i)
+		if err != nil {
+			return err
+		}
+		b, err := v.i.getBoolean(val)
+		if err != nil {
+			return err
+		}
+		result = b.value
+		return nil
+	})
+	return
+}
+
+func (v Value) object() (obj *valueObject, err error) {
+	err = v.inEvaluation(func() error {
+		val, err := v.force(v.i)
+		if err != nil {
+			return err
+		}
+		obj, err = v.i.getObject(val)
+		return err
+	})
+	return
+}
+
+func (v Value) array() (arr *valueArray, err error) {
+	err = v.inEvaluation(func() error {
+		val, err := v.force(v.i)
+		if err != nil {
+			return err
+		}
+		arr, err = v.i.getArray(val)
+		return err
+	})
+	return
+}
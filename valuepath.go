@@ -0,0 +1,209 @@
+/*
+Copyright 2026 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonnet
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-jsonnet/ast"
+)
+
+// pathElement is a step of a path into a value: a field of an object or
+// an element of an array.
+type pathElement struct {
+	field   string
+	index   int
+	isIndex bool
+}
+
+// parseValuePath parses a path like a.b[3].c. The field names which contain
+// dots or brackets can be written in brackets as string literals,
+// e.g. metadata.annotations["app.kubernetes.io/name"]. The empty path is
+// the value itself.
+func parseValuePath(path string) ([]pathElement, error) {
+	var elements []pathElement
+	for i := 0; i < len(path); {
+		if path[i] == '[' {
+			element, size, err := parseBracketPathElement(path[i:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid path %q: %v", path, err)
+			}
+			elements = append(elements, element)
+			i += size
+			continue
+		}
+		if i > 0 {
+			if path[i] != '.' {
+				return nil, fmt.Errorf("invalid path %q: expected . or [ after ]", path)
+			}
+			i++
+		}
+		end := strings.IndexAny(path[i:], ".[")
+		if end < 0 {
+			end = len(path) - i
+		}
+		if end == 0 {
+			return nil, fmt.Errorf("invalid path %q: empty field name", path)
+		}
+		elements = append(elements, pathElement{field: path[i : i+end]})
+		i += end
+	}
+	return elements, nil
+}
+
+// parseBracketPathElement parses an element in brackets at the beginning
+// of s, an index or a string literal, and returns it with its length.
+func parseBracketPathElement(s string) (pathElement, int, error) {
+	inner := s[1:]
+	switch {
+	case strings.HasPrefix(inner, `"`):
+		quoted, err := strconv.QuotedPrefix(inner)
+		if err != nil {
+			return pathElement{}, 0, fmt.Errorf("invalid string literal")
+		}
+		if !strings.HasPrefix(inner[len(quoted):], "]") {
+			return pathElement{}, 0, fmt.Errorf("expected ] after %s", quoted)
+		}
+		name, _ := strconv.Unquote(quoted)
+		return pathElement{field: name}, len(quoted) + 2, nil
+
+	case strings.HasPrefix(inner, "'"):
+		end := strings.IndexByte(inner[1:], '\'')
+		if end < 0 {
+			return pathElement{}, 0, fmt.Errorf("unterminated string literal")
+		}
+		quoted := inner[:end+2]
+		if !strings.HasPrefix(inner[len(quoted):], "]") {
+			return pathElement{}, 0, fmt.Errorf("expected ] after %s", quoted)
+		}
+		return pathElement{field: quoted[1 : len(quoted)-1]}, len(quoted) + 2, nil
+
+	default:
+		end := strings.IndexByte(inner, ']')
+		if end < 0 {
+			return pathElement{}, 0, fmt.Errorf("missing ]")
+		}
+		index, err := strconv.Atoi(inner[:end])
+		if err != nil || index < 0 {
+			return pathElement{}, 0, fmt.Errorf("invalid array index %q", inner[:end])
+		}
+		return pathElement{index: index, isIndex: true}, end + 2, nil
+	}
+}
+
+// EvaluateFilePath evaluates Jsonnet code in a file, but only manifests
+// the value at a path like a.b[3].c (see Value.Lookup), which is returned
+// as JSON, or in the format set in the VM. The other parts of the result are
+// not evaluated, so this is much cheaper than EvaluateFile if only a small
+// part of a big result is needed. The empty path is the whole result.
+//
+// The importer is used to fetch the contents of the file.
+func (vm *VM) EvaluateFilePath(filename string, path string) (json string, formattedErr error) {
+	elements, err := parseValuePath(path)
+	if err != nil {
+		return "", err
+	}
+	output, err := vm.evaluateFile(context.Background(), filename, evalKindValue)
+	return vm.manifestPath(output, err, elements)
+}
+
+// EvaluateAnonymousSnippetPath evaluates a string containing Jsonnet code, but
+// only manifests the value at a path, like EvaluateFilePath.
+//
+// The filename parameter is only used for error messages.
+func (vm *VM) EvaluateAnonymousSnippetPath(filename string, snippet string, path string) (json string, formattedErr error) {
+	elements, err := parseValuePath(path)
+	if err != nil {
+		return "", err
+	}
+	output, err := vm.evaluateSnippet(context.Background(), ast.DiagnosticFileName(filename), "", snippet, evalKindValue)
+	return vm.manifestPath(output, err, elements)
+}
+
+func (vm *VM) manifestPath(output interface{}, err error, elements []pathElement) (string, error) {
+	if err != nil {
+		return "", vm.formatError(err)
+	}
+	v, err := output.(Value).lookupPath(elements)
+	if err != nil {
+		return "", vm.formatError(err)
+	}
+	var buf bytes.Buffer
+	if err := vm.writeResult(&buf, v, nil); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Lookup returns the value at a path like a.b[3].c. The field names which
+// contain dots or brackets can be written in brackets as string literals,
+// e.g. metadata.annotations["app.kubernetes.io/name"]. Only the values on
+// the path are evaluated, not their siblings, and only the assertions of
+// the objects on the path are checked.
+func (v Value) Lookup(path string) (Value, error) {
+	elements, err := parseValuePath(path)
+	if err != nil {
+		return Value{}, err
+	}
+	return v.lookupPath(elements)
+}
+
+func (v Value) lookupPath(elements []pathElement) (Value, error) {
+	var err error
+	for _, element := range elements {
+		if element.isIndex {
+			v, err = v.Index(element.index)
+		} else {
+			v, err = v.Field(element.field)
+		}
+		if err != nil {
+			return Value{}, err
+		}
+	}
+	return v, nil
+}
+
+// FieldDefinitions returns the definitions of the field at a path (see Lookup)
+// in the objects combined with + (the mixins), from the rightmost one, which
+// overrides the others, to the leftmost one. The field may be hidden.
+func (v Value) FieldDefinitions(path string) ([]FieldDefinition, error) {
+	elements, err := parseValuePath(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(elements) == 0 || elements[len(elements)-1].isIndex {
+		return nil, fmt.Errorf("path %q does not end with a field", path)
+	}
+	parent, err := v.lookupPath(elements[:len(elements)-1])
+	if err != nil {
+		return nil, err
+	}
+	obj, err := parent.object()
+	if err != nil {
+		return nil, err
+	}
+	fieldName := elements[len(elements)-1].field
+	definitions := objectFieldDefinitions(obj, fieldName)
+	if len(definitions) == 0 {
+		return nil, v.i.Error(fmt.Sprintf("Field does not exist: %s", fieldName))
+	}
+	return definitions, nil
+}
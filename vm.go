@@ -17,7 +17,7 @@ limitations under the License.
 package jsonnet
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -36,17 +36,76 @@ import (
 
 // VM is the core interpreter and is the touchpoint used to parse and execute
 // Jsonnet.
+//
+// Once configured, a VM may be used to run multiple evaluations concurrently.
+// The configuration (fields, external variables, top-level arguments, native
+// functions, importer) must not be changed while any evaluation is running.
 type VM struct { //nolint:govet
 	MaxStack       int
 	ext            vmExtMap
 	tla            vmExtMap
-	nativeFuncs    map[string]*NativeFunction
+	nativeFuncs    map[string]evalCallable
 	importer       Importer
 	ErrorFormatter ErrorFormatter
 	StringOutput   bool
-	importCache    *importCache
+	importCache    *ImportCache
+	astCache       ASTCache
 	traceOut       io.Writer
 	EvalHook       EvalHook
+	profiler       *Profiler
+	sandbox        *sandbox
+
+	// Resource limits of a single evaluation. Zero means no limit.
+	// Exceeding any of them results in a RuntimeError caused by
+	// a *LimitExceededError.
+
+	// MaxSteps limits the number of evaluation steps (roughly, the number
+	// of evaluated AST nodes).
+	MaxSteps int
+	// MaxMemory limits the approximate total size in bytes of strings and
+	// arrays allocated during the evaluation, including the ones returned
+	// by the standard library and the native functions. A concatenation of
+	// long strings only counts a small node, until the characters of
+	// the result are needed.
+	MaxMemory int
+	// MaxOutputSize limits the size in bytes of the manifested output.
+	// It is checked as the output is written, so that an output which is
+	// too big is never held in memory as a whole.
+	MaxOutputSize int
+
+	// OutputFormat is the format of the output, JSON by default. It applies
+	// to the regular output, to the files in multi mode and to the documents
+	// in stream mode, unless StringOutput is set.
+	OutputFormat OutputFormat
+	// OutputFormatByExtension makes the files in multi mode whose names end
+	// with the extension of a format (see OutputFormatOfFile) use it instead
+	// of OutputFormat.
+	OutputFormatByExtension bool
+	// PreserveOrder makes the fields of the objects in the output appear in
+	// the order of their definitions instead of the sorted order. With
+	// inheritance, the fields of the base object go first and the overridden
+	// fields keep their places. It applies to the JSON and YAML output.
+	PreserveOrder bool
+	// CanonicalJSON makes the JSON output follow the JSON Canonicalization
+	// Scheme (RFC 8785), e.g. for hashing or signing it: the numbers are
+	// formatted as in ECMAScript, the fields are sorted by their UTF-16 code
+	// units and there is no whitespace, not even a trailing newline (except
+	// after the documents in stream mode). It takes precedence over
+	// PreserveOrder.
+	CanonicalJSON bool
+
+	// Parallelism is the number of goroutines used to manifest the top-level
+	// fields in multi mode (EvaluateFileMulti and friends). Each goroutine
+	// other than the first one evaluates the program again, so this only pays
+	// off when manifesting the fields is expensive. The output and the reported
+	// error are the same as in the sequential mode. The evaluation must not
+	// have side effects to be repeated: with native functions, an EvalHook or
+	// profiling the fields are manifested sequentially, and when std.trace is
+	// called the evaluation starts over sequentially, so that the trace is
+	// written once. The resource limits (MaxSteps, MaxMemory) apply to each
+	// goroutine separately, so N goroutines get N times the budget. 0 or 1
+	// means no parallelism.
+	Parallelism int
 }
 
 // extKind indicates the kind of external variable that is being initialized for the VM
@@ -77,28 +136,49 @@ func MakeVM() *VM {
 		MaxStack:       500,
 		ext:            make(vmExtMap),
 		tla:            make(vmExtMap),
-		nativeFuncs:    make(map[string]*NativeFunction),
+		nativeFuncs:    make(map[string]evalCallable),
 		ErrorFormatter: &termErrorFormatter{pretty: false, maxStackTraceSize: 20},
 		importer:       &FileImporter{},
-		importCache:    makeImportCache(defaultImporter),
+		importCache:    MakeImportCache(defaultImporter),
 		traceOut:       os.Stderr,
-		EvalHook: EvalHook{
-			pre:  func(i *interpreter, a ast.Node) {},
-			post: func(i *interpreter, a ast.Node, v value, err error) {},
-		},
+	}
+}
+
+func (vm *VM) limits() evalLimits {
+	return evalLimits{
+		maxSteps:      vm.MaxSteps,
+		maxMemory:     vm.MaxMemory,
+		maxOutputSize: vm.MaxOutputSize,
+	}
+}
+
+func (vm *VM) evalConfig(ctx context.Context) *evalConfig {
+	return &evalConfig{
+		ctx:              ctx,
+		ext:              vm.ext,
+		tla:              vm.tla,
+		nativeFuncs:      vm.nativeFuncs,
+		maxStack:         vm.MaxStack,
+		limits:           vm.limits(),
+		importCache:      vm.importCache,
+		traceOut:         vm.traceOut,
+		evalHook:         vm.EvalHook,
+		profiler:         vm.profiler,
+		sandbox:          vm.sandbox,
+		errorFormatter:   vm.ErrorFormatter,
+		stringOutputMode: vm.StringOutput,
+		format:           vm.outputFormat(),
+		parallelism:      vm.Parallelism,
 	}
 }
 
 // Fully flush cache. This should be executed when we are no longer sure that the source files
 // didn't change, for example when the importer changed.
 func (vm *VM) flushCache() {
-	vm.importCache = makeImportCache(vm.importer)
-}
-
-// Flush value cache. This should be executed when calculated values may no longer be up to date,
-// for example due to change in extVars.
-func (vm *VM) flushValueCache() {
-	vm.importCache.flushValueCache()
+	vm.importCache = MakeImportCache(vm.importer)
+	if vm.astCache != nil {
+		vm.importCache.SetASTCache(vm.astCache)
+	}
 }
 
 // SetTraceOut sets the output stream for the builtin function std.trace().
@@ -109,45 +189,36 @@ func (vm *VM) SetTraceOut(traceOut io.Writer) {
 // ExtVar binds a Jsonnet external var to the given value.
 func (vm *VM) ExtVar(key string, val string) {
 	vm.ext[key] = vmExt{value: val, kind: extKindVar}
-	vm.flushValueCache()
 }
 
 // ExtCode binds a Jsonnet external code var to the given code.
 func (vm *VM) ExtCode(key string, val string) {
 	vm.ext[key] = vmExt{value: val, kind: extKindCode}
-	vm.flushValueCache()
 }
 
 // ExtNode binds a Jsonnet external code var to the given AST node.
 func (vm *VM) ExtNode(key string, node ast.Node) {
 	vm.ext[key] = vmExt{node: node, kind: extKindNode}
-	vm.flushValueCache()
 }
 
 // ExtReset rests all external variables registered for this VM.
 func (vm *VM) ExtReset() {
 	vm.ext = make(vmExtMap)
-	vm.flushValueCache()
 }
 
 // TLAVar binds a Jsonnet top level argument to the given value.
 func (vm *VM) TLAVar(key string, val string) {
 	vm.tla[key] = vmExt{value: val, kind: extKindVar}
-	// Setting a TLA does not require flushing the cache.
-	// Only the results of evaluation of imported files are cached
-	// and the TLAs do not affect these unlike extVars.
 }
 
 // TLACode binds a Jsonnet top level argument to the given code.
 func (vm *VM) TLACode(key string, val string) {
 	vm.tla[key] = vmExt{value: val, kind: extKindCode}
-	// Setting a TLA does not require flushing the cache - see above.
 }
 
 // TLANode binds a Jsonnet top level argument to the given AST node.
 func (vm *VM) TLANode(key string, node ast.Node) {
 	vm.tla[key] = vmExt{node: node, kind: extKindNode}
-	// Setting a TLA does not require flushing the cache - see above.
 }
 
 // TLAReset resets all TLAs registered for this VM.
@@ -161,10 +232,55 @@ func (vm *VM) Importer(i Importer) {
 	vm.flushCache()
 }
 
+// SetImportCache makes the VM use the given ImportCache and its Importer.
+// Sharing one ImportCache between multiple VMs means that every imported file
+// is read and parsed only once.
+func (vm *VM) SetImportCache(cache *ImportCache) {
+	vm.importer = cache.importer
+	vm.importCache = cache
+}
+
+// InvalidateImports makes the VM read and parse again the imported files at
+// the given locations, e.g. because they changed. See ImportCache.Invalidate.
+func (vm *VM) InvalidateImports(foundAts ...string) {
+	vm.importCache.Invalidate(foundAts...)
+}
+
+// SetASTCache makes the VM use a persistent cache of the ASTs of the imported
+// files, for example a DirASTCache. This avoids parsing large libraries again
+// in every process. It does not affect an ImportCache set with SetImportCache,
+// use ImportCache.SetASTCache for that.
+func (vm *VM) SetASTCache(cache ASTCache) {
+	vm.astCache = cache
+	vm.flushCache()
+}
+
+// SetProfiler makes the VM record its evaluations in the profiler.
+// Nil disables profiling.
+func (vm *VM) SetProfiler(p *Profiler) {
+	vm.profiler = p
+}
+
+// SetSandboxPolicy restricts what the evaluated code may access.
+// The policy is copied, later changes to it have no effect.
+// Nil removes the restrictions.
+func (vm *VM) SetSandboxPolicy(policy *SandboxPolicy) {
+	if policy == nil {
+		vm.sandbox = nil
+		return
+	}
+	vm.sandbox = makeSandbox(policy)
+}
+
 // NativeFunction registers a native function.
 func (vm *VM) NativeFunction(f *NativeFunction) {
 	vm.nativeFuncs[f.Name] = f
-	vm.flushValueCache()
+}
+
+// TypedNativeFunction registers a native function operating on Jsonnet values.
+// It shares the namespace of std.native with NativeFunction.
+func (vm *VM) TypedNativeFunction(f *TypedNativeFunction) {
+	vm.nativeFuncs[f.Name] = f
 }
 
 type evalKind int
@@ -173,6 +289,7 @@ const (
 	evalKindRegular evalKind = iota
 	evalKindMulti            = iota
 	evalKindStream           = iota
+	evalKindValue            = iota
 )
 
 // version is the current gojsonnet's version
@@ -182,38 +299,68 @@ const version = "v0.20.0"
 // and returns serialized JSON as string.
 // TODO(sbarzowski) perhaps is should return JSON in standard Go representation
 func (vm *VM) Evaluate(node ast.Node) (val string, err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			err = fmt.Errorf("(CRASH) %v\n%s", r, debug.Stack())
-		}
-	}()
-	return evaluate(node, vm.ext, vm.tla, vm.nativeFuncs, vm.MaxStack, vm.importCache, vm.traceOut, vm.StringOutput, vm.EvalHook)
+	output, err := vm.evaluateNode(context.Background(), node, evalKindRegular)
+	if err != nil {
+		return "", err
+	}
+	return output.(string), nil
 }
 
 // EvaluateStream evaluates a Jsonnet program given by an Abstract Syntax Tree
 // and returns an array of JSON strings.
 func (vm *VM) EvaluateStream(node ast.Node) (output []string, err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			err = fmt.Errorf("(CRASH) %v\n%s", r, debug.Stack())
-		}
-	}()
-	return evaluateStream(node, vm.ext, vm.tla, vm.nativeFuncs, vm.MaxStack, vm.importCache, vm.traceOut, vm.EvalHook)
+	rawOutput, err := vm.evaluateNode(context.Background(), node, evalKindStream)
+	if err != nil {
+		return nil, err
+	}
+	return rawOutput.([]string), nil
 }
 
 // EvaluateMulti evaluates a Jsonnet program given by an Abstract Syntax Tree
 // and returns key-value pairs.
 // The keys are strings and the values are JSON strigns (serialized JSON).
 func (vm *VM) EvaluateMulti(node ast.Node) (output map[string]string, err error) {
+	rawOutput, err := vm.evaluateNode(context.Background(), node, evalKindMulti)
+	if err != nil {
+		return nil, err
+	}
+	return rawOutput.(map[string]string), nil
+}
+
+// EvaluateToValue evaluates a Jsonnet program given by an Abstract Syntax Tree
+// and returns the result as a Value, without manifesting it.
+func (vm *VM) EvaluateToValue(node ast.Node) (Value, error) {
+	output, err := vm.evaluateNode(context.Background(), node, evalKindValue)
+	if err != nil {
+		return Value{}, err
+	}
+	return output.(Value), nil
+}
+
+func (vm *VM) evaluateNode(ctx context.Context, node ast.Node, kind evalKind) (output interface{}, err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			err = fmt.Errorf("(CRASH) %v\n%s", r, debug.Stack())
 		}
 	}()
-	return evaluateMulti(node, vm.ext, vm.tla, vm.nativeFuncs, vm.MaxStack, vm.importCache, vm.traceOut, vm.StringOutput, vm.EvalHook)
+	c := vm.evalConfig(ctx)
+	switch kind {
+	case evalKindRegular:
+		output, err = evaluate(node, c)
+	case evalKindMulti:
+		output, err = evaluateMulti(node, c)
+	case evalKindStream:
+		output, err = evaluateStream(node, c)
+	case evalKindValue:
+		output, err = evaluateToValue(node, c)
+	}
+	if err != nil {
+		return "", err
+	}
+	return output, nil
 }
 
-func (vm *VM) evaluateSnippet(diagnosticFileName ast.DiagnosticFileName, filename string, snippet string, kind evalKind) (output interface{}, err error) {
+func (vm *VM) evaluateSnippet(ctx context.Context, diagnosticFileName ast.DiagnosticFileName, filename string, snippet string, kind evalKind) (output interface{}, err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			err = fmt.Errorf("(CRASH) %v\n%s", r, debug.Stack())
@@ -223,18 +370,36 @@ func (vm *VM) evaluateSnippet(diagnosticFileName ast.DiagnosticFileName, filenam
 	if err != nil {
 		return "", err
 	}
-	switch kind {
-	case evalKindRegular:
-		output, err = evaluate(node, vm.ext, vm.tla, vm.nativeFuncs, vm.MaxStack, vm.importCache, vm.traceOut, vm.StringOutput, vm.EvalHook)
-	case evalKindMulti:
-		output, err = evaluateMulti(node, vm.ext, vm.tla, vm.nativeFuncs, vm.MaxStack, vm.importCache, vm.traceOut, vm.StringOutput, vm.EvalHook)
-	case evalKindStream:
-		output, err = evaluateStream(node, vm.ext, vm.tla, vm.nativeFuncs, vm.MaxStack, vm.importCache, vm.traceOut, vm.EvalHook)
-	}
+	return vm.evaluateNode(ctx, node, kind)
+}
+
+func (vm *VM) evaluateFile(ctx context.Context, filename string, kind evalKind) (output interface{}, err error) {
+	node, _, err := vm.importCache.importAST(ctx, "", filename)
 	if err != nil {
 		return "", err
 	}
-	return output, nil
+	return vm.evaluateNode(ctx, node, kind)
+}
+
+// formattedError is an error already formatted by an ErrorFormatter.
+// It still wraps the original error, so that it can be inspected with
+// errors.Is and errors.As.
+type formattedError struct {
+	msg string
+	err error
+}
+
+func (err *formattedError) Error() string {
+	return err.msg
+}
+
+func (err *formattedError) Unwrap() error {
+	return err.err
+}
+
+// formatError formats the error for returning from the public API.
+func (vm *VM) formatError(err error) error {
+	return &formattedError{msg: vm.ErrorFormatter.Format(err), err: err}
 }
 
 func getAbsPath(path string) (string, error) {
@@ -330,9 +495,9 @@ func (vm *VM) findDependencies(filePath string, node *ast.Node, dependencies map
 //
 // Deprecated: Use EvaluateFile or EvaluateAnonymousSnippet instead.
 func (vm *VM) EvaluateSnippet(filename string, snippet string) (json string, formattedErr error) {
-	output, err := vm.evaluateSnippet(ast.DiagnosticFileName(filename), filename, snippet, evalKindRegular)
+	output, err := vm.evaluateSnippet(context.Background(), ast.DiagnosticFileName(filename), filename, snippet, evalKindRegular)
 	if err != nil {
-		return "", errors.New(vm.ErrorFormatter.Format(err))
+		return "", vm.formatError(err)
 	}
 	json = output.(string)
 	return
@@ -345,9 +510,9 @@ func (vm *VM) EvaluateSnippet(filename string, snippet string) (json string, for
 //
 // Deprecated: Use EvaluateFileStream or EvaluateAnonymousSnippetStream instead.
 func (vm *VM) EvaluateSnippetStream(filename string, snippet string) (docs []string, formattedErr error) {
-	output, err := vm.evaluateSnippet(ast.DiagnosticFileName(filename), filename, snippet, evalKindStream)
+	output, err := vm.evaluateSnippet(context.Background(), ast.DiagnosticFileName(filename), filename, snippet, evalKindStream)
 	if err != nil {
-		return nil, errors.New(vm.ErrorFormatter.Format(err))
+		return nil, vm.formatError(err)
 	}
 	docs = output.([]string)
 	return
@@ -360,9 +525,9 @@ func (vm *VM) EvaluateSnippetStream(filename string, snippet string) (docs []str
 //
 // Deprecated: Use EvaluateFileMulti or EvaluateAnonymousSnippetMulti instead.
 func (vm *VM) EvaluateSnippetMulti(filename string, snippet string) (files map[string]string, formattedErr error) {
-	output, err := vm.evaluateSnippet(ast.DiagnosticFileName(filename), filename, snippet, evalKindMulti)
+	output, err := vm.evaluateSnippet(context.Background(), ast.DiagnosticFileName(filename), filename, snippet, evalKindMulti)
 	if err != nil {
-		return nil, errors.New(vm.ErrorFormatter.Format(err))
+		return nil, vm.formatError(err)
 	}
 	files = output.(map[string]string)
 	return
@@ -373,9 +538,16 @@ func (vm *VM) EvaluateSnippetMulti(filename string, snippet string) (files map[s
 //
 // The filename parameter is only used for error messages.
 func (vm *VM) EvaluateAnonymousSnippet(filename string, snippet string) (json string, formattedErr error) {
-	output, err := vm.evaluateSnippet(ast.DiagnosticFileName(filename), "", snippet, evalKindRegular)
+	return vm.EvaluateAnonymousSnippetContext(context.Background(), filename, snippet)
+}
+
+// EvaluateAnonymousSnippetContext is like EvaluateAnonymousSnippet, but the
+// evaluation is aborted when ctx is cancelled or its deadline passes. In that
+// case the returned error wraps ctx.Err().
+func (vm *VM) EvaluateAnonymousSnippetContext(ctx context.Context, filename string, snippet string) (json string, formattedErr error) {
+	output, err := vm.evaluateSnippet(ctx, ast.DiagnosticFileName(filename), "", snippet, evalKindRegular)
 	if err != nil {
-		return "", errors.New(vm.ErrorFormatter.Format(err))
+		return "", vm.formatError(err)
 	}
 	json = output.(string)
 	return
@@ -386,9 +558,9 @@ func (vm *VM) EvaluateAnonymousSnippet(filename string, snippet string) (json st
 //
 // The filename parameter is only used for error messages.
 func (vm *VM) EvaluateAnonymousSnippetStream(filename string, snippet string) (docs []string, formattedErr error) {
-	output, err := vm.evaluateSnippet(ast.DiagnosticFileName(filename), "", snippet, evalKindStream)
+	output, err := vm.evaluateSnippet(context.Background(), ast.DiagnosticFileName(filename), "", snippet, evalKindStream)
 	if err != nil {
-		return nil, errors.New(vm.ErrorFormatter.Format(err))
+		return nil, vm.formatError(err)
 	}
 	docs = output.([]string)
 	return
@@ -399,9 +571,9 @@ func (vm *VM) EvaluateAnonymousSnippetStream(filename string, snippet string) (d
 //
 // The filename parameter is only used for error messages.
 func (vm *VM) EvaluateAnonymousSnippetMulti(filename string, snippet string) (files map[string]string, formattedErr error) {
-	output, err := vm.evaluateSnippet(ast.DiagnosticFileName(filename), "", snippet, evalKindMulti)
+	output, err := vm.evaluateSnippet(context.Background(), ast.DiagnosticFileName(filename), "", snippet, evalKindMulti)
 	if err != nil {
-		return nil, errors.New(vm.ErrorFormatter.Format(err))
+		return nil, vm.formatError(err)
 	}
 	files = output.(map[string]string)
 	return
@@ -412,15 +584,18 @@ func (vm *VM) EvaluateAnonymousSnippetMulti(filename string, snippet string) (fi
 //
 // The importer is used to fetch the contents of the file.
 func (vm *VM) EvaluateFile(filename string) (json string, formattedErr error) {
-	node, _, err := vm.ImportAST("", filename)
-	if err != nil {
-		return "", errors.New(vm.ErrorFormatter.Format(err))
-	}
-	output, err := vm.Evaluate(node)
+	return vm.EvaluateFileContext(context.Background(), filename)
+}
+
+// EvaluateFileContext is like EvaluateFile, but the evaluation is aborted
+// when ctx is cancelled or its deadline passes. In that case the returned
+// error wraps ctx.Err().
+func (vm *VM) EvaluateFileContext(ctx context.Context, filename string) (json string, formattedErr error) {
+	output, err := vm.evaluateFile(ctx, filename, evalKindRegular)
 	if err != nil {
-		return "", errors.New(vm.ErrorFormatter.Format(err))
+		return "", vm.formatError(err)
 	}
-	return output, nil
+	return output.(string), nil
 }
 
 // EvaluateFileStream evaluates Jsonnet code in a file to an array.
@@ -428,15 +603,18 @@ func (vm *VM) EvaluateFile(filename string) (json string, formattedErr error) {
 //
 // The importer is used to fetch the contents of the file.
 func (vm *VM) EvaluateFileStream(filename string) (docs []string, formattedErr error) {
-	node, _, err := vm.ImportAST("", filename)
-	if err != nil {
-		return nil, errors.New(vm.ErrorFormatter.Format(err))
-	}
-	output, err := vm.EvaluateStream(node)
+	return vm.EvaluateFileStreamContext(context.Background(), filename)
+}
+
+// EvaluateFileStreamContext is like EvaluateFileStream, but the evaluation
+// is aborted when ctx is cancelled or its deadline passes. In that case the
+// returned error wraps ctx.Err().
+func (vm *VM) EvaluateFileStreamContext(ctx context.Context, filename string) (docs []string, formattedErr error) {
+	output, err := vm.evaluateFile(ctx, filename, evalKindStream)
 	if err != nil {
-		return nil, errors.New(vm.ErrorFormatter.Format(err))
+		return nil, vm.formatError(err)
 	}
-	return output, nil
+	return output.([]string), nil
 }
 
 // EvaluateFileMulti evaluates Jsonnet code in a file to key-value
@@ -444,15 +622,18 @@ func (vm *VM) EvaluateFileStream(filename string) (docs []string, formattedErr e
 //
 // The importer is used to fetch the contents of the file.
 func (vm *VM) EvaluateFileMulti(filename string) (files map[string]string, formattedErr error) {
-	node, _, err := vm.ImportAST("", filename)
-	if err != nil {
-		return nil, errors.New(vm.ErrorFormatter.Format(err))
-	}
-	output, err := vm.EvaluateMulti(node)
+	return vm.EvaluateFileMultiContext(context.Background(), filename)
+}
+
+// EvaluateFileMultiContext is like EvaluateFileMulti, but the evaluation
+// is aborted when ctx is cancelled or its deadline passes. In that case the
+// returned error wraps ctx.Err().
+func (vm *VM) EvaluateFileMultiContext(ctx context.Context, filename string) (files map[string]string, formattedErr error) {
+	output, err := vm.evaluateFile(ctx, filename, evalKindMulti)
 	if err != nil {
-		return nil, errors.New(vm.ErrorFormatter.Format(err))
+		return nil, vm.formatError(err)
 	}
-	return output, nil
+	return output.(map[string]string), nil
 }
 
 // FindDependencies returns a sorted array of unique transitive dependencies (via import/importstr/importbin)
@@ -490,7 +671,7 @@ func (vm *VM) FindDependencies(importedFrom string, importedPaths []string) ([]s
 		err := vm.findDependencies(filePath, nodes[i], deps, &stackTrace)
 		if err != nil {
 			err = makeRuntimeError(err.Error(), stackTrace)
-			return nil, errors.New(vm.ErrorFormatter.Format(err))
+			return nil, vm.formatError(err)
 		}
 	}
 
@@ -513,14 +694,14 @@ func (vm *VM) FindDependencies(importedFrom string, importedPaths []string) ([]s
 // It will cache the contents of the file immediately as well, to avoid the possibility of the file
 // disappearing after being checked.
 func (vm *VM) ResolveImport(importedFrom, importedPath string) (foundAt string, err error) {
-	_, foundAt, err = vm.importCache.importData(importedFrom, importedPath)
+	_, foundAt, err = vm.importCache.importData(context.Background(), importedFrom, importedPath)
 	return
 }
 
 // ImportData fetches the data just as if it was imported from a Jsonnet file located at `importedFrom`.
 // It shares the cache with the actual evaluation.
 func (vm *VM) ImportData(importedFrom, importedPath string) (contents string, foundAt string, err error) {
-	c, foundAt, err := vm.importCache.importData(importedFrom, importedPath)
+	c, foundAt, err := vm.importCache.importData(context.Background(), importedFrom, importedPath)
 	if err != nil {
 		return "", foundAt, err
 	}
@@ -530,7 +711,7 @@ func (vm *VM) ImportData(importedFrom, importedPath string) (contents string, fo
 // ImportAST fetches the Jsonnet AST just as if it was imported from a Jsonnet file located at `importedFrom`.
 // It shares the cache with the actual evaluation.
 func (vm *VM) ImportAST(importedFrom, importedPath string) (contents ast.Node, foundAt string, err error) {
-	return vm.importCache.importAST(importedFrom, importedPath)
+	return vm.importCache.importAST(context.Background(), importedFrom, importedPath)
 }
 
 // SnippetToAST parses a snippet and returns the resulting AST.